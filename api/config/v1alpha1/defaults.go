@@ -0,0 +1,102 @@
+package v1alpha1
+
+import (
+	"time"
+
+	componentconfig "k8s.io/component-base/config/v1alpha1"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+// 默认值， 与 manager 的命令行参数默认值保持一致
+const (
+	DefaultMetricsBindAddress      = ":8080"
+	DefaultHealthProbeBindAddress  = ":8081"
+	DefaultWebhookPort             = 9443
+	DefaultLeaderElectionID        = "1cc0fdbc.tangx.in"
+	DefaultMaxConcurrentReconciles = 1
+	DefaultEventLanguage           = "zh"
+
+	// 与 workqueue.DefaultControllerRateLimiter 保持一致
+	DefaultRateLimiterBaseDelay = 5 * time.Millisecond
+	DefaultRateLimiterMaxDelay  = 1000 * time.Second
+	DefaultRateLimiterQPS       = 10
+	DefaultRateLimiterBurst     = 100
+	DefaultBudgetBackoff        = 5 * time.Minute
+
+	DefaultOrphanPods          = OrphanPolicyAdopt
+	DefaultOrphanSweepInterval = 5 * time.Minute
+)
+
+// Default 补全配置文件中没有设置的字段
+func (c *OperatorConfig) Default() {
+	if c.Metrics.BindAddress == "" {
+		c.Metrics.BindAddress = DefaultMetricsBindAddress
+	}
+	if c.Health.HealthProbeBindAddress == "" {
+		c.Health.HealthProbeBindAddress = DefaultHealthProbeBindAddress
+	}
+	if c.Webhook.Port == nil {
+		port := DefaultWebhookPort
+		c.Webhook.Port = &port
+	}
+
+	// manager 读取配置时不检查 LeaderElection 是否为 nil
+	if c.LeaderElection == nil {
+		c.LeaderElection = &componentconfig.LeaderElectionConfiguration{}
+	}
+	if c.LeaderElection.LeaderElect == nil {
+		leaderElect := false
+		c.LeaderElection.LeaderElect = &leaderElect
+	}
+	if c.LeaderElection.ResourceName == "" {
+		c.LeaderElection.ResourceName = DefaultLeaderElectionID
+	}
+	if c.Controller == nil {
+		c.Controller = &cfg.ControllerConfigurationSpec{}
+	}
+
+	if c.Operator.DefaultImage == "" {
+		c.Operator.DefaultImage = myappv1.DefaultRedisImage
+	}
+	if len(c.Operator.Versions) == 0 {
+		c.Operator.Versions = append([]myappv1.RedisVersion(nil), myappv1.DefaultVersionCatalog...)
+	}
+	if c.Operator.EnableWebhooks == nil {
+		enable := true
+		c.Operator.EnableWebhooks = &enable
+	}
+	if c.Operator.MaxConcurrentReconciles == 0 {
+		c.Operator.MaxConcurrentReconciles = DefaultMaxConcurrentReconciles
+	}
+	rl := &c.Operator.RateLimiter
+	if rl.BaseDelay.Duration == 0 {
+		rl.BaseDelay.Duration = DefaultRateLimiterBaseDelay
+	}
+	if rl.MaxDelay.Duration == 0 {
+		rl.MaxDelay.Duration = DefaultRateLimiterMaxDelay
+	}
+	if rl.QPS == 0 {
+		rl.QPS = DefaultRateLimiterQPS
+	}
+	if rl.Burst == 0 {
+		rl.Burst = DefaultRateLimiterBurst
+	}
+	if rl.BudgetBackoff.Duration == 0 {
+		rl.BudgetBackoff.Duration = DefaultBudgetBackoff
+	}
+	if c.Operator.EventLanguage == "" {
+		c.Operator.EventLanguage = DefaultEventLanguage
+	}
+	if c.Operator.Policy.RedactSecrets == nil {
+		redact := true
+		c.Operator.Policy.RedactSecrets = &redact
+	}
+	if c.Operator.Policy.OrphanPods == "" {
+		c.Operator.Policy.OrphanPods = DefaultOrphanPods
+	}
+	if c.Operator.Policy.OrphanSweepInterval.Duration == 0 {
+		c.Operator.Policy.OrphanSweepInterval.Duration = DefaultOrphanSweepInterval
+	}
+}
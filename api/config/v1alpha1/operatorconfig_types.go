@@ -0,0 +1,113 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+//+kubebuilder:object:root=true
+
+// OperatorConfig redis operator 的配置文件。
+// 除 controller-runtime 的 manager 配置外， 还包含 operator 自身的配置。
+type OperatorConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// ControllerManagerConfigurationSpec manager 配置， 包括 metrics、 健康检查、 webhook 和选主
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// Operator operator 自身的配置
+	Operator OperatorSpec `json:"operator,omitempty"`
+}
+
+// OperatorSpec operator 自身的配置
+type OperatorSpec struct {
+	// DefaultImage redis 没有指定 spec.image 时使用的镜像
+	DefaultImage string `json:"defaultImage,omitempty"`
+
+	// Versions redis spec.version 可以使用的版本目录， 为空时使用内置目录
+	Versions []myappv1.RedisVersion `json:"versions,omitempty"`
+
+	// WatchNamespaces 只管理这些命名空间中的 redis， 为空时管理所有命名空间。
+	// 设置后 cache 只 list/watch 这些命名空间， 只需要在这些命名空间中授予 Role 权限。
+	WatchNamespaces []string `json:"watchNamespaces,omitempty"`
+
+	// ShardSelector 标签选择器， 只管理标签匹配的 redis， 为空时管理全部 redis。
+	// 多个 operator 使用互不重叠的选择器分担 redis， 每个分片使用各自的选主 ID。
+	ShardSelector string `json:"shardSelector,omitempty"`
+
+	// Shards 全部分片的选择器， 启动时用于检查不属于任何分片的 redis。
+	// 为空时只检查缺少 ShardSelector 中标签的 redis。
+	Shards []string `json:"shards,omitempty"`
+
+	// EnableWebhooks 是否启动 admission webhook， 默认开启。
+	// 租户自行部署的 operator 没有权限创建 webhook 配置， 需要关闭。
+	EnableWebhooks *bool `json:"enableWebhooks,omitempty"`
+
+	// MaxConcurrentReconciles 同时调谐的 redis 数量
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles,omitempty"`
+
+	// RateLimiter 调谐失败后重新入队的限速配置
+	RateLimiter RateLimiterSpec `json:"rateLimiter,omitempty"`
+
+	// EventLanguage redis 事件消息的语言
+	EventLanguage string `json:"eventLanguage,omitempty"`
+
+	// Policy operator 行为策略
+	Policy PolicySpec `json:"policy,omitempty"`
+}
+
+// RateLimiterSpec 调谐队列的限速配置。
+// 单个 redis 按指数退避重试， 整个队列按令牌桶限速， 取两者中较长的等待时间。
+type RateLimiterSpec struct {
+	// BaseDelay 第一次失败后的重试间隔， 之后每次失败翻倍
+	BaseDelay metav1.Duration `json:"baseDelay,omitempty"`
+
+	// MaxDelay 指数退避的最大间隔
+	MaxDelay metav1.Duration `json:"maxDelay,omitempty"`
+
+	// QPS 整个队列每秒允许重新入队的次数
+	QPS int `json:"qps,omitempty"`
+
+	// Burst 令牌桶容量
+	Burst int `json:"burst,omitempty"`
+
+	// RequeueBudget 单个 redis 连续重试的次数上限， 超过后至少间隔 BudgetBackoff 再重试。
+	// 为 0 时不限制。
+	RequeueBudget int `json:"requeueBudget,omitempty"`
+
+	// BudgetBackoff 超过重试次数上限后的重试间隔
+	BudgetBackoff metav1.Duration `json:"budgetBackoff,omitempty"`
+}
+
+// PolicySpec operator 行为策略
+type PolicySpec struct {
+	// RedactSecrets 输出对象日志时隐去敏感字段， 默认开启
+	RedactSecrets *bool `json:"redactSecrets,omitempty"`
+
+	// OrphanPods 如何处理 operator 标签的孤儿 pod， 即所属 redis 已经删除或 OwnerReference 指向其他 redis 的 pod
+	OrphanPods OrphanPolicy `json:"orphanPods,omitempty"`
+
+	// OrphanSweepInterval 检查孤儿 pod 的间隔
+	OrphanSweepInterval metav1.Duration `json:"orphanSweepInterval,omitempty"`
+}
+
+// OrphanPolicy 孤儿 pod 的处理策略
+type OrphanPolicy string
+
+const (
+	// OrphanPolicyAdopt 同名 redis 仍然需要该 pod 时收养， 否则删除
+	OrphanPolicyAdopt OrphanPolicy = "Adopt"
+	// OrphanPolicyDelete 删除全部孤儿 pod， 由 redis 重新创建需要的 pod
+	OrphanPolicyDelete OrphanPolicy = "Delete"
+	// OrphanPolicyIgnore 不处理孤儿 pod
+	OrphanPolicyIgnore OrphanPolicy = "Ignore"
+)
+
+// OrphanPolicies 全部孤儿 pod 处理策略
+var OrphanPolicies = []OrphanPolicy{OrphanPolicyAdopt, OrphanPolicyDelete, OrphanPolicyIgnore}
+
+func init() {
+	SchemeBuilder.Register(&OperatorConfig{})
+}
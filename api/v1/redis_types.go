@@ -17,6 +17,8 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -35,6 +37,265 @@ type RedisSpec struct {
 	Port int32 `json:"port,omitempty"`
 
 	Image string `json:"image,omitempty"`
+
+	// Version redis 版本， 通过 operator 的版本目录解析为镜像， 与 spec.image 不能同时设置。
+	// 修改版本后 operator 逐个重建 pod， pod 之间没有主从复制， 只有设置了 spec.storage 时才会重建就绪的 pod。
+	//+optional
+	Version string `json:"version,omitempty"`
+
+	// Auth redis 访问密码配置， 为空时不开启密码认证
+	Auth *RedisAuth `json:"auth,omitempty"`
+
+	// Monitoring 监控配置， 开启后注入 redis_exporter sidecar
+	Monitoring *RedisMonitoring `json:"monitoring,omitempty"`
+
+	// Storage 数据目录使用的持久化存储， 为空时数据保存在容器文件系统中， pod 删除后丢失。
+	// 创建后不能修改。
+	//+optional
+	Storage *RedisStorage `json:"storage,omitempty"`
+
+	// DeletionPolicy 删除 redis 时的处理流程， 为空时立即删除全部 pod
+	DeletionPolicy *DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// TLS 客户端和主从复制使用 TLS， 需要 redis 6 及以上版本
+	TLS *RedisTLS `json:"tls,omitempty"`
+
+	// NetworkPolicy 只允许指定的客户端访问 redis， 为空时不限制
+	NetworkPolicy *RedisNetworkPolicy `json:"networkPolicy,omitempty"`
+}
+
+// RedisStorage 定义每个 pod 的 PVC。
+// PVC 不设置 OwnerReference， 删除 redis 后保留， 同名 redis 重建时继续使用， 不再需要时手动删除。
+type RedisStorage struct {
+	// Size PVC 申请的容量
+	Size resource.Quantity `json:"size"`
+
+	// StorageClassName 为空时使用集群默认的 StorageClass
+	//+optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+}
+
+// RedisNetworkPolicy 定义 operator 生成的 NetworkPolicy。
+// redis pod 之间的主从复制和 operator 自己的访问始终允许。
+type RedisNetworkPolicy struct {
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Clients 允许访问 redis 端口的客户端， 为空时只允许 redis pod 之间互相访问
+	//+optional
+	Clients []NetworkPolicyPeer `json:"clients,omitempty"`
+
+	// MetricsScrapers 允许访问 exporter metrics 端口的客户端， 为空时不限制
+	//+optional
+	MetricsScrapers []NetworkPolicyPeer `json:"metricsScrapers,omitempty"`
+}
+
+// NetworkPolicyPeer 通过 namespace 和 pod 标签选择客户端。
+// 只设置 podSelector 时选择 redis 所在 namespace 中的 pod，
+// 只设置 namespaceSelector 时选择这些 namespace 中的全部 pod。
+type NetworkPolicyPeer struct {
+	//+optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	//+optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+}
+
+// RedisTLS 定义 redis 证书的来源。
+// 开启后 redis 只在 spec.port 上提供 TLS 服务， 关闭明文端口。
+type RedisTLS struct {
+	Enabled bool `json:"enabled,omitempty"`
+
+	// IssuerRef 使用 cert-manager 的 Issuer 签发证书， 为空时由 operator 自己的 CA 签发
+	//+optional
+	IssuerRef *IssuerReference `json:"issuerRef,omitempty"`
+
+	// RecreatePods 允许逐个删除 pod 来应用无法原地重新加载的 TLS 变化， 例如开启或关闭 TLS。
+	// 没有设置 spec.storage 时 pod 删除后数据丢失。 证书更新始终原地重新加载， 不需要重建 pod。
+	//+optional
+	RecreatePods bool `json:"recreatePods,omitempty"`
+}
+
+// IssuerReference 引用 cert-manager 的 Issuer 或 ClusterIssuer
+type IssuerReference struct {
+	Name string `json:"name"`
+
+	// Kind 默认为 Issuer
+	//+kubebuilder:validation:Enum=Issuer;ClusterIssuer
+	//+optional
+	Kind string `json:"kind,omitempty"`
+
+	// Group 默认为 cert-manager.io
+	//+optional
+	Group string `json:"group,omitempty"`
+}
+
+// DeletionMode 删除 redis 时如何处理 pod
+//+kubebuilder:validation:Enum=Delete;Retain
+type DeletionMode string
+
+const (
+	// DeletionModeDelete 删除全部 pod
+	DeletionModeDelete DeletionMode = "Delete"
+	// DeletionModeRetain 只删除 redis， pod 去掉 OwnerReference 后保留， 便于人工检查
+	DeletionModeRetain DeletionMode = "Retain"
+)
+
+// DeletionPolicy 删除 redis 时依次执行最终备份、 等待客户端断开、 等待宽限期， 最后删除或保留 pod
+type DeletionPolicy struct {
+	// Mode 默认为 Delete
+	//+optional
+	Mode DeletionMode `json:"mode,omitempty"`
+
+	// FinalBackup 删除前在每个 pod 上执行 BGSAVE 并等待完成， 需要设置 spec.storage，
+	// 备份写入数据目录所在的 PVC， 删除 pod 后保留。
+	//+optional
+	FinalBackup bool `json:"finalBackup,omitempty"`
+
+	// FinalBackupTimeout 从开始删除算起等待备份完成的最长时间， 超时后继续删除。 为空时一直等待。
+	//+optional
+	FinalBackupTimeout *metav1.Duration `json:"finalBackupTimeout,omitempty"`
+
+	// DrainClients 等待客户端连接数降到阈值以下
+	//+optional
+	DrainClients *DrainClients `json:"drainClients,omitempty"`
+
+	// GracePeriod 从开始删除算起， 至少等待这么久才删除 pod
+	//+optional
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// DrainClients 等待客户端断开的配置
+type DrainClients struct {
+	// MaxClients 全部 pod 的客户端连接数之和不超过该值时认为已经排空， 不包括主从复制连接
+	//+kubebuilder:validation:Minimum:=0
+	MaxClients int `json:"maxClients"`
+
+	// Timeout 从开始删除算起的最长等待时间， 超时后继续删除。 为空时一直等待。
+	//+optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// RedisAuth 定义 redis 密码来源
+type RedisAuth struct {
+	// PasswordSecret 引用保存 redis 密码的 secret
+	PasswordSecret corev1.SecretKeySelector `json:"passwordSecret"`
+}
+
+// RedisMonitoring 定义 redis_exporter sidecar 及 ServiceMonitor
+type RedisMonitoring struct {
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Image redis_exporter 镜像
+	Image string `json:"image,omitempty"`
+
+	// Port redis_exporter 监听的 metrics 端口
+	//+kubebuilder:validation:Minimum:=1
+	//+kubebuilder:validation:Maximum:=65535
+	Port int32 `json:"port,omitempty"`
+
+	// ServiceMonitor 集群中安装了 prometheus-operator 时创建 ServiceMonitor
+	ServiceMonitor *ServiceMonitorSpec `json:"serviceMonitor,omitempty"`
+}
+
+// ServiceMonitorSpec 定义 ServiceMonitor 的可选配置
+type ServiceMonitorSpec struct {
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Interval 抓取间隔， 例如 30s
+	Interval string `json:"interval,omitempty"`
+
+	// Labels 附加到 ServiceMonitor 上的标签， 用于匹配 prometheus 的 serviceMonitorSelector
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+const (
+	// PausedAnnotation 值为 "true" 时 operator 暂停调谐该 redis
+	PausedAnnotation = "myapp.tangx.in/paused"
+
+	// ConditionReady 是否所有 pod 都已就绪
+	ConditionReady = "Ready"
+
+	// ConditionPaused 调谐是否被 PausedAnnotation 暂停
+	ConditionPaused = "Paused"
+
+	// ConditionApplyConflict 其他 field manager 是否占有了 operator 需要 apply 的字段
+	ConditionApplyConflict = "ApplyConflict"
+
+	// ConditionTerminating 删除流程当前所处的步骤。 删除流程的 condition 只在 deletionTimestamp 设置后出现
+	ConditionTerminating = "Terminating"
+	// ConditionFinalBackup 最终备份是否完成
+	ConditionFinalBackup = "FinalBackupCompleted"
+	// ConditionClientsDrained 客户端连接是否已经排空
+	ConditionClientsDrained = "ClientsDrained"
+
+	// RetainedAnnotation 记录 Retain 模式下保留的 pod 原来所属的 redis
+	RetainedAnnotation = "myapp.tangx.in/retained-from"
+
+	// ConditionTLSReady TLS 证书是否已经签发
+	ConditionTLSReady = "TLSReady"
+	// TLSHashAnnotation pod 使用的证书的 hash， 证书更新后 pod 重新加载证书时更新
+	TLSHashAnnotation = "myapp.tangx.in/tls-cert-hash"
+	// ConditionTLSRotating 是否有 pod 还没有使用当前的证书
+	ConditionTLSRotating = "TLSRotating"
+
+	// ConditionUpgrading pod 是否正在升级到 spec.version 或 spec.image
+	ConditionUpgrading = "Upgrading"
+
+	// DefaultRedisImage spec.image 为空时使用的默认镜像
+	DefaultRedisImage = "redis:5-alpine"
+
+	// DefaultExporterImage 默认 redis_exporter 镜像
+	DefaultExporterImage = "oliver006/redis_exporter:v1.27.0"
+	// DefaultExporterPort 默认 redis_exporter metrics 端口
+	DefaultExporterPort int32 = 9121
+)
+
+// MonitoringEnabled 判断是否开启了监控
+func (r *Redis) MonitoringEnabled() bool {
+	return r.Spec.Monitoring != nil && r.Spec.Monitoring.Enabled
+}
+
+// ServiceMonitorEnabled 判断是否需要创建 ServiceMonitor
+func (r *Redis) ServiceMonitorEnabled() bool {
+	return r.MonitoringEnabled() &&
+		r.Spec.Monitoring.ServiceMonitor != nil &&
+		r.Spec.Monitoring.ServiceMonitor.Enabled
+}
+
+// NetworkPolicyEnabled 判断是否需要创建 NetworkPolicy
+func (r *Redis) NetworkPolicyEnabled() bool {
+	return r.Spec.NetworkPolicy != nil && r.Spec.NetworkPolicy.Enabled
+}
+
+// PersistentStorage 判断数据目录是否位于持久化存储
+func (r *Redis) PersistentStorage() bool {
+	return r.Spec.Storage != nil
+}
+
+// DeletionMode 返回删除 redis 时处理 pod 的方式
+func (r *Redis) DeletionMode() DeletionMode {
+	if r.Spec.DeletionPolicy == nil || r.Spec.DeletionPolicy.Mode == "" {
+		return DeletionModeDelete
+	}
+	return r.Spec.DeletionPolicy.Mode
+}
+
+// TLSEnabled 判断是否开启了 TLS
+func (r *Redis) TLSEnabled() bool {
+	return r.Spec.TLS != nil && r.Spec.TLS.Enabled
+}
+
+// TLSCertificateHash 返回 pod 应该使用的证书的 hash， 没有开启 TLS 时为空
+func (r *Redis) TLSCertificateHash() string {
+	if !r.TLSEnabled() || r.Status.TLS == nil {
+		return ""
+	}
+	return r.Status.TLS.CertificateHash
+}
+
+// IsPaused 判断是否通过 PausedAnnotation 暂停了调谐
+func (r *Redis) IsPaused() bool {
+	return r.Annotations[PausedAnnotation] == "true"
 }
 
 // RedisStatus defines the observed state of Redis
@@ -42,6 +303,51 @@ type RedisStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 	Replicas int `json:"replicas"`
+
+	// Conditions redis 当前状态， 例如 Paused、 ApplyConflict
+	//+optional
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// TLS 当前使用的证书
+	//+optional
+	TLS *TLSStatus `json:"tls,omitempty"`
+
+	// Binding 保存连接信息的 secret， 遵循 Service Binding 规范的 Provisioned Service
+	//+optional
+	Binding *corev1.LocalObjectReference `json:"binding,omitempty"`
+
+	// Version 正在运行和期望的 redis 版本， 没有设置 spec.version 时为空
+	//+optional
+	Version *VersionStatus `json:"version,omitempty"`
+
+	// FinalBackupStartTime 删除时最近一次开始最终备份的时间， 只有之后完成的备份才算作最终备份
+	//+optional
+	FinalBackupStartTime *metav1.Time `json:"finalBackupStartTime,omitempty"`
+}
+
+// VersionStatus 记录版本升级的进度
+type VersionStatus struct {
+	// Current 全部 pod 都在运行的版本， 升级完成前保持为旧版本
+	//+optional
+	Current string `json:"current,omitempty"`
+
+	// Target spec.version 中期望的版本
+	Target string `json:"target"`
+}
+
+// TLSStatus 当前使用的证书
+type TLSStatus struct {
+	// SecretName 保存证书的 secret
+	SecretName string `json:"secretName"`
+
+	// CertificateHash 证书的 sha256， 与 pod 的 TLSHashAnnotation 不同时轮换 pod
+	CertificateHash string `json:"certificateHash"`
+
+	// NotAfter 证书过期时间
+	//+optional
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -49,6 +355,7 @@ type RedisStatus struct {
 //+kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.spec.selector
 //+kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.status.replicas`
 //+kubebuilder:printcolumn:name="ImageName",type=string,JSONPath=`.spec.image`
+//+kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.version.current`
 //+kubebuilder:printcolumn:name="Uuid",type=string,JSONPath=`.metadata.uid`
 //+kubebuilder:printcolumn:name="Alias",type=string,JSONPath=`.spec.alias`
 
@@ -17,70 +17,336 @@ limitations under the License.
 package v1
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 
-	"k8s.io/apimachinery/pkg/runtime"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 // log is for logging in this package.
 var redislog = logf.Log.WithName("redis-resource")
 
-func (r *Redis) SetupWebhookWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewWebhookManagedBy(mgr).
-		For(r).
-		Complete()
+// OperatorDefaults operator 配置中影响 redis 默认值和校验的部分， 零值使用内置的默认镜像和版本目录
+type OperatorDefaults struct {
+	// Image spec.image 和 spec.version 都没有设置时使用的镜像
+	Image string
+
+	// Versions spec.version 可以使用的版本目录
+	Versions VersionCatalog
 }
 
-// TODO(user): EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
+func (d OperatorDefaults) image() string {
+	if d.Image == "" {
+		return DefaultRedisImage
+	}
+	return d.Image
+}
 
-//+kubebuilder:webhook:path=/mutate-myapp-tangx-in-v1-redis,mutating=true,failurePolicy=fail,sideEffects=None,groups=myapp.tangx.in,resources=redis,verbs=create;update,versions=v1,name=mredis.kb.io,admissionReviewVersions=v1
+// redis webhook 的路径， 与 kubebuilder:webhook 标记一致
+const (
+	RedisMutatePath   = "/mutate-myapp-tangx-in-v1-redis"
+	RedisValidatePath = "/validate-myapp-tangx-in-v1-redis"
+)
+
+// RedisWebhook 使用 operator 配置处理 redis 的 admission 请求
+//+kubebuilder:object:generate=false
+type RedisWebhook struct {
+	Defaults OperatorDefaults
+}
 
-var _ webhook.Defaulter = &Redis{}
+// SetupWebhookWithManager 注册 redis 的 webhook， 跳过已经注册的路径
+func (w *RedisWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	server := mgr.GetWebhookServer()
+	hooks := []struct {
+		path string
+		hook *webhook.Admission
+	}{
+		{RedisMutatePath, w.DefaultingWebhook()},
+		{RedisValidatePath, w.ValidatingWebhook()},
+	}
+	for _, h := range hooks {
+		if server.WebhookMux != nil {
+			if _, pattern := server.WebhookMux.Handler(&http.Request{URL: &url.URL{Path: h.path}}); pattern == h.path {
+				continue
+			}
+		}
+		server.Register(h.path, h.hook)
+	}
+	return nil
+}
+
+// DefaultingWebhook 返回补全 redis 默认值的 webhook
+func (w *RedisWebhook) DefaultingWebhook() *webhook.Admission {
+	return &webhook.Admission{Handler: &redisDefaulter{defaults: w.Defaults}}
+}
+
+// ValidatingWebhook 返回校验 redis 的 webhook， 拒绝时响应中带有 ValidationError 的校验规则
+func (w *RedisWebhook) ValidatingWebhook() *webhook.Admission {
+	return &webhook.Admission{Handler: &redisValidator{defaults: w.Defaults}}
+}
+
+type redisDefaulter struct {
+	defaults OperatorDefaults
+	decoder  *admission.Decoder
+}
+
+func (h *redisDefaulter) InjectDecoder(d *admission.Decoder) error {
+	h.decoder = d
+	return nil
+}
+
+func (h *redisDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	r := &Redis{}
+	if err := h.decoder.Decode(req, r); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	r.Default(h.defaults)
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, data)
+}
 
-// Default implements webhook.Defaulter so a webhook will be registered for the type
-func (r *Redis) Default() {
+type redisValidator struct {
+	defaults OperatorDefaults
+	decoder  *admission.Decoder
+}
+
+func (h *redisValidator) InjectDecoder(d *admission.Decoder) error {
+	h.decoder = d
+	return nil
+}
+
+func (h *redisValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	r := &Redis{}
+	if err := h.decoder.DecodeRaw(req.Object, r); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	var err error
+	switch req.Operation {
+	case admissionv1.Create:
+		err = r.ValidateCreate(h.defaults)
+	case admissionv1.Update:
+		old := &Redis{}
+		if err := h.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		err = r.ValidateUpdate(old, h.defaults)
+	}
+	if err == nil {
+		return admission.Allowed("")
+	}
+
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		result := status.Status()
+		return admission.Response{AdmissionResponse: admissionv1.AdmissionResponse{Allowed: false, Result: &result}}
+	}
+	return admission.Denied(err.Error())
+}
+
+//+kubebuilder:webhook:path=/mutate-myapp-tangx-in-v1-redis,mutating=true,failurePolicy=fail,sideEffects=None,groups=myapp.tangx.in,resources=redis,verbs=create;update,versions=v1,name=mredis.kb.io,admissionReviewVersions=v1
+
+// Default 补全 redis 的默认值
+func (r *Redis) Default(defaults OperatorDefaults) {
 	redislog.Info("default", "name", r.Name)
 
-	// TODO(user): fill in your defaulting logic.
+	// 设置了 spec.version 时由版本目录决定镜像
+	if r.Spec.Image == "" && r.Spec.Version == "" {
+		r.Spec.Image = defaults.image()
+	}
+
+	// 开启监控时补全 exporter 默认值
+	if r.MonitoringEnabled() {
+		if r.Spec.Monitoring.Image == "" {
+			r.Spec.Monitoring.Image = DefaultExporterImage
+		}
+		if r.Spec.Monitoring.Port == 0 {
+			r.Spec.Monitoring.Port = DefaultExporterPort
+		}
+	}
+
+	// 补全 cert-manager issuer 的默认值
+	if r.TLSEnabled() && r.Spec.TLS.IssuerRef != nil {
+		if r.Spec.TLS.IssuerRef.Kind == "" {
+			r.Spec.TLS.IssuerRef.Kind = "Issuer"
+		}
+		if r.Spec.TLS.IssuerRef.Group == "" {
+			r.Spec.TLS.IssuerRef.Group = "cert-manager.io"
+		}
+	}
 }
 
-// TODO(user): change verbs to "verbs=create;update;delete" if you want to enable deletion validation.
 //+kubebuilder:webhook:path=/validate-myapp-tangx-in-v1-redis,mutating=false,failurePolicy=fail,sideEffects=None,groups=myapp.tangx.in,resources=redis,verbs=create;update,versions=v1,name=vredis.kb.io,admissionReviewVersions=v1
 
-var _ webhook.Validator = &Redis{}
-
-// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
-func (r *Redis) ValidateCreate() error {
+// ValidateCreate 校验新建的 redis
+func (r *Redis) ValidateCreate(defaults OperatorDefaults) error {
 	redislog.Info("validate create", "name", r.Name)
 
 	// 条件判断
 	if r.ObjectMeta.Name == "tangx-in" {
-		return fmt.Errorf("不合法名字: tangx-in")
+		return reject("reserved-name", fmt.Errorf("不合法名字: tangx-in"))
 	}
 
-	if r.Spec.Port < 6379 {
-		return fmt.Errorf("端口必须大于等于 6379")
+	if err := r.validateSpec(); err != nil {
+		return err
+	}
+	if err := r.validateVersion(defaults.Versions); err != nil {
+		return err
+	}
+	if err := r.validateFinalBackup(); err != nil {
+		return err
 	}
 
 	// TODO(user): fill in your validation logic upon object creation.
 	return nil
 }
 
-// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
-func (r *Redis) ValidateUpdate(old runtime.Object) error {
-	redislog.Info("validate update", "name", r.Name)
+// validateSpec 校验创建和更新时都需要满足的字段
+func (r *Redis) validateSpec() error {
+	if r.Spec.Port < 6379 {
+		return reject("port-range", fmt.Errorf("端口必须大于等于 6379"))
+	}
 
-	// TODO(user): fill in your validation logic upon object update.
+	if r.Spec.Auth != nil && r.Spec.Auth.PasswordSecret.Name == "" {
+		return reject("auth-secret", fmt.Errorf("auth.passwordSecret.name 不能为空"))
+	}
+
+	if r.MonitoringEnabled() && r.Spec.Monitoring.Port == r.Spec.Port {
+		return reject("monitoring-port", fmt.Errorf("monitoring.port 不能与 redis 端口相同"))
+	}
+
+	if r.TLSEnabled() && r.Spec.TLS.IssuerRef != nil && r.Spec.TLS.IssuerRef.Name == "" {
+		return reject("tls-issuer", fmt.Errorf("tls.issuerRef.name 不能为空"))
+	}
 	return nil
 }
 
-// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
-func (r *Redis) ValidateDelete() error {
-	redislog.Info("validate delete", "name", r.Name)
+// validateVersion 检查 spec.version 是否在版本目录中
+func (r *Redis) validateVersion(versions VersionCatalog) error {
+	if r.Spec.Version == "" {
+		return nil
+	}
+	if r.Spec.Image != "" {
+		return reject("version-image", fmt.Errorf("spec.image 和 spec.version 不能同时设置"))
+	}
+	if _, ok := versions.Lookup(r.Spec.Version); !ok {
+		return reject("version", fmt.Errorf("版本目录中没有 redis 版本 %s", r.Spec.Version))
+	}
+	return nil
+}
+
+// validateFinalBackup 最终备份需要持久化存储， 否则 BGSAVE 写出的 RDB 随 pod 一起删除
+func (r *Redis) validateFinalBackup() error {
+	if r.Spec.DeletionPolicy != nil && r.Spec.DeletionPolicy.FinalBackup && !r.PersistentStorage() {
+		return reject("final-backup-storage", fmt.Errorf("deletionPolicy.finalBackup 需要设置 spec.storage"))
+	}
+	return nil
+}
+
+// validateStorage 拒绝修改 spec.storage， 已经创建的 pod 和 PVC 无法修改
+func (r *Redis) validateStorage(old *Redis) error {
+	if !equality.Semantic.DeepEqual(r.Spec.Storage, old.Spec.Storage) {
+		return reject("storage-immutable", fmt.Errorf("spec.storage 创建后不能修改"))
+	}
+	return nil
+}
 
-	// TODO(user): fill in your validation logic upon object deletion.
+// validateDowngrade 拒绝降级到无法加载现有 RDB 的版本。
+// 正在升级时部分 pod 已经运行目标版本， 同时比较正在运行的版本和之前的目标版本。
+func (r *Redis) validateDowngrade(old *Redis, catalog VersionCatalog) error {
+	if r.Spec.Version == "" {
+		return nil
+	}
+
+	versions := []string{old.Spec.Version}
+	if old.Status.Version != nil {
+		versions = append(versions, old.Status.Version.Current)
+	}
+	for _, version := range versions {
+		if err := catalog.CheckDowngrade(version, r.Spec.Version); err != nil {
+			return reject("version-downgrade", err)
+		}
+	}
+	return nil
+}
+
+// CauseTypeValidationRule webhook 拒绝请求时， status details 中记录触发的校验规则
+const CauseTypeValidationRule metav1.CauseType = "ValidationRule"
+
+// ValidationError 校验失败的原因， Rule 为触发的校验规则
+//+kubebuilder:object:generate=false
+type ValidationError struct {
+	Rule string
+	Err  error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Status 实现 apierrors.APIStatus， webhook 把校验规则带到响应中， 由 operator 统计
+func (e *ValidationError) Status() metav1.Status {
+	return metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusForbidden,
+		Reason:  metav1.StatusReasonForbidden,
+		Message: e.Err.Error(),
+		Details: &metav1.StatusDetails{
+			Causes: []metav1.StatusCause{{Type: CauseTypeValidationRule, Message: e.Rule}},
+		},
+	}
+}
+
+// reject 返回带有校验规则的错误
+func reject(rule string, err error) error {
+	return &ValidationError{Rule: rule, Err: err}
+}
+
+// ValidateUpdate 校验对 redis 的修改
+func (r *Redis) ValidateUpdate(old *Redis, defaults OperatorDefaults) error {
+	redislog.Info("validate update", "name", r.Name)
+
+	if err := r.validateSpec(); err != nil {
+		return err
+	}
+	// 只在修改版本或镜像时检查版本目录， operator 配置移除了版本后已有对象仍然可以更新和删除
+	if r.Spec.Version != old.Spec.Version || r.Spec.Image != old.Spec.Image {
+		if err := r.validateVersion(defaults.Versions); err != nil {
+			return err
+		}
+		if err := r.validateDowngrade(old, defaults.Versions); err != nil {
+			return err
+		}
+	}
+	if err := r.validateStorage(old); err != nil {
+		return err
+	}
+	// 只在修改删除策略时检查， 不阻止已有对象删除时移除 finalizer 的更新
+	if !equality.Semantic.DeepEqual(r.Spec.DeletionPolicy, old.Spec.DeletionPolicy) {
+		if err := r.validateFinalBackup(); err != nil {
+			return err
+		}
+	}
+
+	// TODO(user): fill in your validation logic upon object update.
 	return nil
 }
@@ -0,0 +1,122 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func newStorageRedis() *Redis {
+	return &Redis{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"},
+		Spec: RedisSpec{
+			Replicas: 1,
+			Port:     6379,
+			Storage:  &RedisStorage{Size: resource.MustParse("1Gi")},
+		},
+	}
+}
+
+func assertRule(t *testing.T, err error, rule string) {
+	t.Helper()
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.Rule != rule {
+		t.Fatalf("err = %v, want rule %s", err, rule)
+	}
+}
+
+func TestValidateFinalBackup(t *testing.T) {
+	r := newStorageRedis()
+	r.Spec.DeletionPolicy = &DeletionPolicy{FinalBackup: true}
+	if err := r.ValidateCreate(OperatorDefaults{}); err != nil {
+		t.Fatalf("final backup with storage rejected: %v", err)
+	}
+
+	r.Spec.Storage = nil
+	assertRule(t, r.ValidateCreate(OperatorDefaults{}), "final-backup-storage")
+
+	// 开启最终备份的更新同样检查
+	old := r.DeepCopy()
+	old.Spec.DeletionPolicy = nil
+	assertRule(t, r.ValidateUpdate(old, OperatorDefaults{}), "final-backup-storage")
+
+	// 删除策略没有变化时不阻止更新， 例如删除时移除 finalizer
+	if err := r.ValidateUpdate(r.DeepCopy(), OperatorDefaults{}); err != nil {
+		t.Fatalf("unchanged deletion policy rejected: %v", err)
+	}
+}
+
+func TestValidateStorage(t *testing.T) {
+	old := newStorageRedis()
+
+	r := old.DeepCopy()
+	r.Spec.Storage.Size = resource.MustParse("2Gi")
+	assertRule(t, r.ValidateUpdate(old, OperatorDefaults{}), "storage-immutable")
+
+	r.Spec.Storage = nil
+	assertRule(t, r.ValidateUpdate(old, OperatorDefaults{}), "storage-immutable")
+
+	if err := old.DeepCopy().ValidateUpdate(old, OperatorDefaults{}); err != nil {
+		t.Fatalf("unchanged storage rejected: %v", err)
+	}
+}
+
+// 创建后才添加的字段同样需要校验
+func TestValidateUpdateSpec(t *testing.T) {
+	old := newStorageRedis()
+
+	r := old.DeepCopy()
+	r.Spec.TLS = &RedisTLS{Enabled: true, IssuerRef: &IssuerReference{}}
+	assertRule(t, r.ValidateUpdate(old, OperatorDefaults{}), "tls-issuer")
+
+	r = old.DeepCopy()
+	r.Spec.Monitoring = &RedisMonitoring{Enabled: true, Port: r.Spec.Port}
+	assertRule(t, r.ValidateUpdate(old, OperatorDefaults{}), "monitoring-port")
+
+	r = old.DeepCopy()
+	r.Spec.Auth = &RedisAuth{}
+	assertRule(t, r.ValidateUpdate(old, OperatorDefaults{}), "auth-secret")
+
+	r = old.DeepCopy()
+	r.Spec.Port = 80
+	assertRule(t, r.ValidateUpdate(old, OperatorDefaults{}), "port-range")
+}
+
+func TestRedisWebhookDefaults(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook := (&RedisWebhook{Defaults: OperatorDefaults{Image: "registry.local/redis:6.2"}}).DefaultingWebhook()
+	if _, err := admission.InjectDecoderInto(decoder, hook.Handler); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := json.Marshal(&Redis{
+		TypeMeta:   metav1.TypeMeta{APIVersion: GroupVersion.String(), Kind: "Redis"},
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"},
+		Spec:       RedisSpec{Replicas: 1, Port: 6379},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := hook.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}})
+	if !resp.Allowed || len(resp.Patches) != 1 || resp.Patches[0].Path != "/spec/image" || resp.Patches[0].Value != "registry.local/redis:6.2" {
+		t.Fatalf("response = %+v, want spec.image set to the configured default", resp)
+	}
+}
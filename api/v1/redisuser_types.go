@@ -0,0 +1,124 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RedisUserSpec defines the desired state of RedisUser
+type RedisUserSpec struct {
+	// RedisRef 用户所属的 redis， 必须在同一个 namespace 中
+	RedisRef corev1.LocalObjectReference `json:"redisRef"`
+
+	// Username ACL 用户名， 为空时使用 RedisUser 的名称
+	//+optional
+	Username string `json:"username,omitempty"`
+
+	// PasswordSecret 用户密码所在的 secret
+	PasswordSecret corev1.SecretKeySelector `json:"passwordSecret"`
+
+	// Commands 允许或禁止的命令， 例如 +get、 -flushall、 +@read、 -@dangerous
+	//+optional
+	Commands []string `json:"commands,omitempty"`
+
+	// Keys 允许访问的 key 模式， 例如 cache:*
+	//+optional
+	Keys []string `json:"keys,omitempty"`
+
+	// Channels 允许访问的 pub/sub channel 模式， 需要 redis 6.2 及以上版本
+	//+optional
+	Channels []string `json:"channels,omitempty"`
+}
+
+// 同步状态的 condition 和 reason
+const (
+	// ConditionSynced 用户是否已经同步到全部运行中的 pod
+	ConditionSynced = "Synced"
+
+	// RedisUserFinalizer 删除 RedisUser 前从 redis 中删除用户
+	RedisUserFinalizer = "myapp.tangx.in/acl-user"
+)
+
+// RedisUserPodStatus 用户在单个 pod 上的同步状态
+type RedisUserPodStatus struct {
+	Name string `json:"name"`
+
+	Synced bool `json:"synced"`
+
+	// Message 同步失败的原因
+	//+optional
+	Message string `json:"message,omitempty"`
+
+	// LastSyncTime 上次同步成功的时间
+	//+optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+}
+
+// RedisUserStatus defines the observed state of RedisUser
+type RedisUserStatus struct {
+	// ObservedGeneration 最近一次同步的 spec
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Pods 每个运行中的 pod 上的同步状态
+	//+optional
+	Pods []RedisUserPodStatus `json:"pods,omitempty"`
+
+	//+optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// ReservedUsername 由 redis 的 spec.auth 管理的用户， operator 使用它访问 redis， 不能通过 RedisUser 修改
+const ReservedUsername = "default"
+
+// ACLUsername 返回 ACL 用户名
+func (u *RedisUser) ACLUsername() string {
+	if u.Spec.Username != "" {
+		return u.Spec.Username
+	}
+	return u.Name
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Redis",type=string,JSONPath=`.spec.redisRef.name`
+//+kubebuilder:printcolumn:name="Username",type=string,JSONPath=`.spec.username`
+//+kubebuilder:printcolumn:name="Synced",type=string,JSONPath=`.status.conditions[?(@.type=="Synced")].status`
+
+// RedisUser is the Schema for the redisusers API
+type RedisUser struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RedisUserSpec   `json:"spec,omitempty"`
+	Status RedisUserStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// RedisUserList contains a list of RedisUser
+type RedisUserList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RedisUser `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RedisUser{}, &RedisUserList{})
+}
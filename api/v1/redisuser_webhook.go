@@ -0,0 +1,124 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var redisuserlog = logf.Log.WithName("redisuser-resource")
+
+var (
+	// usernamePattern ACL 用户名， 不允许空白和 ACL 规则使用的前缀字符
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]*$`)
+	// commandPattern +command、 -command、 +command|subcommand、 +@category
+	commandPattern = regexp.MustCompile(`^[+-](@[a-z]+|[a-z][a-z0-9-]*(\|[a-z][a-z0-9-]*)?)$`)
+)
+
+func (r *RedisUser) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-myapp-tangx-in-v1-redisuser,mutating=false,failurePolicy=fail,sideEffects=None,groups=myapp.tangx.in,resources=redisusers,verbs=create;update,versions=v1,name=vredisuser.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &RedisUser{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *RedisUser) ValidateCreate() error {
+	redisuserlog.Info("validate create", "name", r.Name)
+
+	return r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *RedisUser) ValidateUpdate(old runtime.Object) error {
+	redisuserlog.Info("validate update", "name", r.Name)
+
+	// 修改 redis 或用户名后， 原来的用户无法再被删除
+	if oldUser, ok := old.(*RedisUser); ok {
+		if oldUser.Spec.RedisRef.Name != r.Spec.RedisRef.Name {
+			return reject("acl-immutable", fmt.Errorf("redisRef 不能修改"))
+		}
+		if oldUser.ACLUsername() != r.ACLUsername() {
+			return reject("acl-immutable", fmt.Errorf("username 不能修改"))
+		}
+	}
+	return r.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *RedisUser) ValidateDelete() error {
+	return nil
+}
+
+func (r *RedisUser) validate() error {
+	if r.Spec.RedisRef.Name == "" {
+		return reject("acl-redis", fmt.Errorf("redisRef.name 不能为空"))
+	}
+
+	username := r.ACLUsername()
+	if !usernamePattern.MatchString(username) {
+		return reject("acl-username", fmt.Errorf("不合法的用户名: %q", username))
+	}
+	if username == ReservedUsername {
+		return reject("acl-username", fmt.Errorf("不能管理 default 用户"))
+	}
+
+	if r.Spec.PasswordSecret.Name == "" || r.Spec.PasswordSecret.Key == "" {
+		return reject("acl-password", fmt.Errorf("passwordSecret.name 和 passwordSecret.key 不能为空"))
+	}
+
+	for _, command := range r.Spec.Commands {
+		if !commandPattern.MatchString(strings.ToLower(command)) {
+			return reject("acl-command", fmt.Errorf("不合法的命令规则: %q， 格式为 +command、 -command、 +command|subcommand 或 +@category", command))
+		}
+	}
+	for _, key := range r.Spec.Keys {
+		if err := validatePattern(key); err != nil {
+			return reject("acl-key", fmt.Errorf("不合法的 key 模式 %q: %v", key, err))
+		}
+	}
+	for _, channel := range r.Spec.Channels {
+		if err := validatePattern(channel); err != nil {
+			return reject("acl-channel", fmt.Errorf("不合法的 channel 模式 %q: %v", channel, err))
+		}
+	}
+	return nil
+}
+
+// validatePattern 检查 key 和 channel 模式， ~ 和 & 前缀由 operator 添加
+func validatePattern(pattern string) error {
+	switch {
+	case pattern == "":
+		return fmt.Errorf("不能为空")
+	case strings.ContainsAny(pattern, " \t\r\n"):
+		return fmt.Errorf("不能包含空白字符")
+	case strings.HasPrefix(pattern, "~") || strings.HasPrefix(pattern, "&") || strings.HasPrefix(pattern, "%"):
+		return fmt.Errorf("不需要 ~、 & 或 %% 前缀")
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package v1
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newRedisUser() *RedisUser {
+	return &RedisUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: RedisUserSpec{
+			RedisRef: corev1.LocalObjectReference{Name: "cache"},
+			PasswordSecret: corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "app-password"},
+				Key:                  "password",
+			},
+			Commands: []string{"+@read", "-@dangerous", "+client|setname", "+SET"},
+			Keys:     []string{"app:*"},
+			Channels: []string{"events.*"},
+		},
+	}
+}
+
+func TestRedisUserValidate(t *testing.T) {
+	if err := newRedisUser().ValidateCreate(); err != nil {
+		t.Fatalf("valid user rejected: %v", err)
+	}
+
+	cases := map[string]func(u *RedisUser){
+		"missing redis":      func(u *RedisUser) { u.Spec.RedisRef.Name = "" },
+		"default user":       func(u *RedisUser) { u.Spec.Username = "default" },
+		"username space":     func(u *RedisUser) { u.Spec.Username = "app user" },
+		"missing secret key": func(u *RedisUser) { u.Spec.PasswordSecret.Key = "" },
+		"command prefix":     func(u *RedisUser) { u.Spec.Commands = []string{"get"} },
+		"command rule":       func(u *RedisUser) { u.Spec.Commands = []string{"+get ~*"} },
+		"key prefix":         func(u *RedisUser) { u.Spec.Keys = []string{"~app:*"} },
+		"key whitespace":     func(u *RedisUser) { u.Spec.Keys = []string{"app:* other"} },
+		"empty channel":      func(u *RedisUser) { u.Spec.Channels = []string{""} },
+	}
+	for name, mutate := range cases {
+		u := newRedisUser()
+		mutate(u)
+		if err := u.ValidateCreate(); err == nil {
+			t.Errorf("%s: expected rejection", name)
+		}
+	}
+}
+
+func TestRedisUserValidateUpdate(t *testing.T) {
+	old := newRedisUser()
+
+	renamed := newRedisUser()
+	renamed.Spec.Username = "other"
+	if err := renamed.ValidateUpdate(old); err == nil {
+		t.Error("username change must be rejected")
+	}
+
+	moved := newRedisUser()
+	moved.Spec.RedisRef.Name = "other"
+	if err := moved.ValidateUpdate(old); err == nil {
+		t.Error("redisRef change must be rejected")
+	}
+
+	rules := newRedisUser()
+	rules.Spec.Commands = []string{"+@all"}
+	if err := rules.ValidateUpdate(old); err != nil {
+		t.Errorf("rule change rejected: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package v1
+
+import (
+	"fmt"
+)
+
+// RedisVersion operator 版本目录中的一项， spec.version 通过目录解析为镜像
+type RedisVersion struct {
+	// Version 版本号， 例如 6.2
+	Version string `json:"version"`
+
+	// Image 该版本使用的镜像
+	Image string `json:"image"`
+
+	// RDBVersion 该版本写出的 RDB 格式版本。
+	// redis 无法加载更高版本的 RDB， 降级到 RDBVersion 更低的版本会被拒绝。
+	RDBVersion int `json:"rdbVersion"`
+}
+
+// VersionCatalog operator 的版本目录， 来自 operator 配置中的 versions
+type VersionCatalog []RedisVersion
+
+// DefaultVersionCatalog operator 配置中没有设置 versions 时使用的版本目录
+var DefaultVersionCatalog = VersionCatalog{
+	{Version: "5.0", Image: "redis:5.0-alpine", RDBVersion: 9},
+	{Version: "6.0", Image: "redis:6.0-alpine", RDBVersion: 9},
+	{Version: "6.2", Image: "redis:6.2-alpine", RDBVersion: 9},
+	{Version: "7.0", Image: "redis:7.0-alpine", RDBVersion: 10},
+	{Version: "7.2", Image: "redis:7.2-alpine", RDBVersion: 11},
+}
+
+// Lookup 在版本目录中查找版本， 目录为空时使用 DefaultVersionCatalog
+func (c VersionCatalog) Lookup(version string) (RedisVersion, bool) {
+	if len(c) == 0 {
+		c = DefaultVersionCatalog
+	}
+	for _, v := range c {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return RedisVersion{}, false
+}
+
+// RedisImage 返回 redis 容器使用的镜像， 设置了 spec.version 时从版本目录中解析
+func (r *Redis) RedisImage(versions VersionCatalog) (string, error) {
+	if r.Spec.Version == "" {
+		return r.Spec.Image, nil
+	}
+	v, ok := versions.Lookup(r.Spec.Version)
+	if !ok {
+		return "", fmt.Errorf("版本目录中没有 redis 版本 %s", r.Spec.Version)
+	}
+	return v.Image, nil
+}
+
+// CheckDowngrade 检查从 from 升级或降级到 to 后能否加载现有的 RDB， 不在版本目录中的版本不检查
+func (c VersionCatalog) CheckDowngrade(from, to string) error {
+	current, ok := c.Lookup(from)
+	if !ok {
+		return nil
+	}
+	target, ok := c.Lookup(to)
+	if !ok {
+		return nil
+	}
+	if current.RDBVersion > target.RDBVersion {
+		return fmt.Errorf("redis %s 无法加载 %s 写出的 RDB (版本 %d > %d)， 不能降级",
+			target.Version, current.Version, current.RDBVersion, target.RDBVersion)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package v1
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newVersionedRedis(version string) *Redis {
+	return &Redis{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"},
+		Spec:       RedisSpec{Replicas: 3, Port: 6379, Version: version},
+	}
+}
+
+func TestRedisImage(t *testing.T) {
+	image, err := newVersionedRedis("6.2").RedisImage(DefaultVersionCatalog)
+	if err != nil || image != "redis:6.2-alpine" {
+		t.Fatalf("RedisImage(DefaultVersionCatalog) = %q, %v", image, err)
+	}
+
+	if _, err := newVersionedRedis("4.0").RedisImage(DefaultVersionCatalog); err == nil {
+		t.Error("unknown version must fail")
+	}
+
+	r := newVersionedRedis("")
+	r.Spec.Image = "redis:custom"
+	if image, _ := r.RedisImage(DefaultVersionCatalog); image != "redis:custom" {
+		t.Errorf("RedisImage(DefaultVersionCatalog) = %q, want spec.image", image)
+	}
+}
+
+func TestVersionDefault(t *testing.T) {
+	r := newVersionedRedis("6.2")
+	r.Default(OperatorDefaults{})
+	if r.Spec.Image != "" {
+		t.Errorf("spec.image = %q, must stay empty when spec.version is set", r.Spec.Image)
+	}
+}
+
+func TestValidateVersion(t *testing.T) {
+	if err := newVersionedRedis("7.0").ValidateCreate(OperatorDefaults{}); err != nil {
+		t.Fatalf("valid version rejected: %v", err)
+	}
+
+	unknown := newVersionedRedis("4.0")
+	if err := unknown.ValidateCreate(OperatorDefaults{}); err == nil {
+		t.Error("unknown version must be rejected")
+	}
+
+	both := newVersionedRedis("7.0")
+	both.Spec.Image = "redis:7.0"
+	if err := both.ValidateCreate(OperatorDefaults{}); err == nil {
+		t.Error("spec.image together with spec.version must be rejected")
+	}
+}
+
+func TestOperatorDefaults(t *testing.T) {
+	defaults := OperatorDefaults{
+		Image:    "registry.local/redis:6.2",
+		Versions: VersionCatalog{{Version: "6.2", Image: "registry.local/redis:6.2", RDBVersion: 9}},
+	}
+
+	r := newVersionedRedis("")
+	r.Default(defaults)
+	if r.Spec.Image != defaults.Image {
+		t.Errorf("spec.image = %q, want the configured default image", r.Spec.Image)
+	}
+
+	// 只能使用配置中的版本目录
+	if err := newVersionedRedis("7.0").ValidateCreate(defaults); err == nil {
+		t.Error("version missing from the configured catalog must be rejected")
+	}
+	if image, err := newVersionedRedis("6.2").RedisImage(defaults.Versions); err != nil || image != "registry.local/redis:6.2" {
+		t.Errorf("RedisImage() = %q, %v", image, err)
+	}
+}
+
+func TestValidateDowngrade(t *testing.T) {
+	cases := []struct {
+		name    string
+		current string
+		from    string
+		to      string
+		allowed bool
+	}{
+		{name: "upgrade", current: "6.2", from: "6.2", to: "7.0", allowed: true},
+		{name: "same rdb version", current: "6.2", from: "6.2", to: "6.0", allowed: true},
+		{name: "older rdb version", current: "7.0", from: "7.0", to: "6.2"},
+		// 升级中途回退， 已经升级的 pod 可能写出了新格式的 RDB
+		{name: "rollback during upgrade", current: "6.2", from: "7.2", to: "6.2"},
+		{name: "first version", from: "", to: "6.2", allowed: true},
+	}
+	for _, tc := range cases {
+		old := newVersionedRedis(tc.from)
+		if tc.current != "" {
+			old.Status.Version = &VersionStatus{Current: tc.current, Target: tc.from}
+		}
+		r := newVersionedRedis(tc.to)
+		err := r.ValidateUpdate(old, OperatorDefaults{})
+		if tc.allowed && err != nil {
+			t.Errorf("%s: rejected: %v", tc.name, err)
+		}
+		if !tc.allowed && err == nil {
+			t.Errorf("%s: expected rejection", tc.name)
+		}
+	}
+}
+
+// 版本目录中移除了正在使用的版本时， 不修改版本的更新仍然允许， 例如删除时移除 finalizer
+func TestValidateUpdateRemovedVersion(t *testing.T) {
+	defaults := OperatorDefaults{Versions: VersionCatalog{{Version: "7.0", Image: "redis:7.0-alpine", RDBVersion: 10}}}
+
+	old := newVersionedRedis("6.2")
+	old.Finalizers = []string{"cache-0"}
+	r := old.DeepCopy()
+	r.Finalizers = nil
+	if err := r.ValidateUpdate(old, defaults); err != nil {
+		t.Fatalf("update without a version change rejected: %v", err)
+	}
+
+	r.Spec.Version = "6.0"
+	if err := r.ValidateUpdate(old, defaults); err == nil {
+		t.Error("changing to a version missing from the catalog must be rejected")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+var (
+	backupPod     string
+	backupOutput  string
+	backupTimeout time.Duration
+)
+
+var backupCommand = &command{
+	usage: "backup NAME [--pod POD] [-o FILE]",
+	short: "Trigger BGSAVE and optionally download the RDB file",
+	run:   runBackup,
+	flags: func(fs *flag.FlagSet) {
+		fs.StringVar(&backupPod, "pod", "", "Pod to back up. Defaults to the primary.")
+		fs.StringVar(&backupOutput, "o", "", "Copy the RDB file to this local path after the save finished.")
+		fs.DurationVar(&backupTimeout, "timeout", 5*time.Minute, "How long to wait for BGSAVE to finish.")
+	},
+}
+
+// backupSource 选择执行备份的节点， 默认使用主节点
+func backupSource(nodes []*node, pod string) (*node, error) {
+	for _, n := range nodes {
+		if n.errorText != "" {
+			continue
+		}
+		if pod != "" && n.pod.Name == pod {
+			return n, nil
+		}
+		if pod == "" && n.isPrimary() {
+			return n, nil
+		}
+	}
+	if pod != "" {
+		return nil, fmt.Errorf("pod %s is not a reachable pod of this redis", pod)
+	}
+	return nil, fmt.Errorf("no reachable primary found")
+}
+
+func runBackup(ctx context.Context, o *options, args []string) error {
+	name, err := requireName(args)
+	if err != nil {
+		return err
+	}
+	redis, err := o.getRedis(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := o.topology(ctx, redis)
+	if err != nil {
+		return err
+	}
+	source, err := backupSource(nodes, backupPod)
+	if err != nil {
+		return err
+	}
+
+	before, err := o.redisCLI(redis, source.pod, "LASTSAVE")
+	if err != nil {
+		return err
+	}
+	if _, err := o.redisCLI(redis, source.pod, "BGSAVE"); err != nil {
+		return err
+	}
+	fmt.Printf("BGSAVE started on %s\n", source.pod.Name)
+
+	// LASTSAVE 变化说明 BGSAVE 已经完成
+	deadline := time.Now().Add(backupTimeout)
+	for {
+		last, err := o.redisCLI(redis, source.pod, "LASTSAVE")
+		if err != nil {
+			return err
+		}
+		if last != before {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("BGSAVE on %s did not finish within %s", source.pod.Name, backupTimeout)
+		}
+		time.Sleep(time.Second)
+	}
+	fmt.Printf("BGSAVE finished on %s\n", source.pod.Name)
+
+	if backupOutput == "" {
+		return nil
+	}
+
+	dir, err := o.configValue(redis, source, "dir")
+	if err != nil {
+		return err
+	}
+	file, err := o.configValue(redis, source, "dbfilename")
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(backupOutput)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	rdb := path.Join(dir, file)
+	if err := o.exec(redis, source.pod, []string{"cat", rdb}, streams{out: out, errOut: os.Stderr}); err != nil {
+		return fmt.Errorf("copy %s from %s: %v", rdb, source.pod.Name, err)
+	}
+	fmt.Printf("%s:%s copied to %s\n", source.pod.Name, rdb, backupOutput)
+	return nil
+}
+
+// configValue 读取 CONFIG GET 的结果， redis-cli 输出为 key 和 value 两行
+func (o *options) configValue(redis *myappv1.Redis, n *node, key string) (string, error) {
+	result, err := o.redisCLI(redis, n.pod, "CONFIG", "GET", key)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(result, "\n")
+	if len(lines) != 2 {
+		return "", fmt.Errorf("%s: unexpected CONFIG GET %s output %q", n.pod.Name, key, result)
+	}
+	return strings.TrimSpace(lines[1]), nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+var cliPod string
+
+var cliCommand = &command{
+	usage: "cli NAME [--pod POD] [-- REDIS-CLI-ARGS...]",
+	short: "Run redis-cli in a pod, authenticated with the managed password",
+	run:   runCLI,
+	flags: func(fs *flag.FlagSet) {
+		fs.StringVar(&cliPod, "pod", "", "Pod to connect to. Defaults to the primary.")
+	},
+}
+
+func runCLI(ctx context.Context, o *options, args []string) error {
+	name, err := requireName(args)
+	if err != nil {
+		return err
+	}
+	redis, err := o.getRedis(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := o.topology(ctx, redis)
+	if err != nil {
+		return err
+	}
+	target, err := backupSource(nodes, cliPod)
+	if err != nil {
+		return err
+	}
+
+	s := streams{in: os.Stdin, out: os.Stdout, errOut: os.Stderr}
+
+	// 交互模式下使用 TTY， 并把本地终端切换到 raw 模式
+	fd := int(os.Stdin.Fd())
+	if len(args) == 1 && term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = term.Restore(fd, state)
+		}()
+		s.tty = true
+	}
+
+	if err := o.exec(redis, target.pod, redisCLICommand(redis, args[1:]...), s); err != nil {
+		return fmt.Errorf("%s: %v", target.pod.Name, err)
+	}
+	return nil
+}
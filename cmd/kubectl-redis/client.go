@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/remotecommand"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	// 支持 kubeconfig 中的各类认证插件
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(myappv1.AddToScheme(scheme))
+}
+
+// options 所有子命令共用的集群连接参数
+type options struct {
+	kubeconfig  string
+	kubeContext string
+	namespace   string
+
+	restConfig *rest.Config
+	client     client.Client
+	clientset  kubernetes.Interface
+}
+
+func (o *options) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file to use.")
+	fs.StringVar(&o.kubeContext, "context", "", "The name of the kubeconfig context to use.")
+	fs.StringVar(&o.namespace, "n", "", "Namespace of the Redis object. Defaults to the kubeconfig namespace.")
+	fs.StringVar(&o.namespace, "namespace", "", "Namespace of the Redis object. Defaults to the kubeconfig namespace.")
+}
+
+// complete 根据 kubeconfig 创建客户端
+func (o *options) complete() error {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if o.kubeconfig != "" {
+		rules.ExplicitPath = o.kubeconfig
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: o.kubeContext}
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+
+	if o.namespace == "" {
+		ns, _, err := loader.Namespace()
+		if err != nil {
+			return err
+		}
+		o.namespace = ns
+	}
+
+	var err error
+	o.restConfig, err = loader.ClientConfig()
+	if err != nil {
+		return err
+	}
+
+	o.client, err = client.New(o.restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return err
+	}
+
+	o.clientset, err = kubernetes.NewForConfig(o.restConfig)
+	return err
+}
+
+// requireName 校验位置参数中的 redis 名称
+func requireName(args []string) (string, error) {
+	if len(args) < 1 || args[0] == "" {
+		return "", fmt.Errorf("redis name is required")
+	}
+	return args[0], nil
+}
+
+func (o *options) getRedis(ctx context.Context, name string) (*myappv1.Redis, error) {
+	redis := &myappv1.Redis{}
+	key := client.ObjectKey{Namespace: o.namespace, Name: name}
+	if err := o.client.Get(ctx, key, redis); err != nil {
+		return nil, err
+	}
+	return redis, nil
+}
+
+// listPods 返回 redis 管理的 pod， 按名称排序
+func (o *options) listPods(ctx context.Context, redis *myappv1.Redis) ([]corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	err := o.client.List(ctx, pods,
+		client.InNamespace(redis.Namespace),
+		client.MatchingLabels(builder.SelectorLabels(redis)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].Name < pods.Items[j].Name
+	})
+	return pods.Items, nil
+}
+
+// runningPods 返回处于 Running 状态的 pod
+func (o *options) runningPods(ctx context.Context, redis *myappv1.Redis) ([]corev1.Pod, error) {
+	pods, err := o.listPods(ctx, redis)
+	if err != nil {
+		return nil, err
+	}
+
+	var running []corev1.Pod
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp.IsZero() {
+			running = append(running, pod)
+		}
+	}
+	if len(running) == 0 {
+		return nil, fmt.Errorf("redis %s has no running pods", redis.Name)
+	}
+	return running, nil
+}
+
+// streams exec 使用的输入输出
+type streams struct {
+	in     io.Reader
+	out    io.Writer
+	errOut io.Writer
+	tty    bool
+}
+
+// exec 在 redis 容器中执行命令
+func (o *options) exec(redis *myappv1.Redis, pod *corev1.Pod, command []string, s streams) error {
+	req := o.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			// redis 主容器与 redis 同名
+			Container: redis.Name,
+			Command:   command,
+			Stdin:     s.in != nil,
+			Stdout:    s.out != nil,
+			Stderr:    s.errOut != nil && !s.tty,
+			TTY:       s.tty,
+		}, clientgoscheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(o.restConfig, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+
+	opts := remotecommand.StreamOptions{
+		Stdin:  s.in,
+		Stdout: s.out,
+		Tty:    s.tty,
+	}
+	if !s.tty {
+		opts.Stderr = s.errOut
+	}
+	return executor.Stream(opts)
+}
+
+// redisCLICommand 返回在容器中执行 redis-cli 的命令。
+// 密码通过容器中由 operator 注入的 REDIS_PASSWORD 环境变量传递， 不经过本地， 也不会出现在进程参数中。
+// 开启 TLS 时使用 pod 中挂载的 CA 校验证书， 证书中包含 localhost。
+func redisCLICommand(redis *myappv1.Redis, args ...string) []string {
+	script := `[ -n "$REDIS_PASSWORD" ] && export REDISCLI_AUTH="$REDIS_PASSWORD"; exec redis-cli -p "$0" "$@"`
+	if redis.TLSEnabled() {
+		args = append([]string{"-h", "localhost", "--tls", "--cacert", builder.TLSMountPath + "/" + builder.TLSCAKey}, args...)
+	}
+	return append([]string{"sh", "-c", script, fmt.Sprintf("%d", redis.Spec.Port)}, args...)
+}
+
+// redisCLI 在 pod 中执行一条 redis-cli 命令并返回输出
+func (o *options) redisCLI(redis *myappv1.Redis, pod *corev1.Pod, args ...string) (string, error) {
+	out := &bytes.Buffer{}
+	errOut := &bytes.Buffer{}
+
+	err := o.exec(redis, pod, redisCLICommand(redis, args...), streams{out: out, errOut: errOut})
+	if err != nil {
+		return "", fmt.Errorf("%s: redis-cli %s: %v: %s", pod.Name, strings.Join(args, " "), err, strings.TrimSpace(errOut.String()))
+	}
+
+	result := strings.TrimSpace(out.String())
+	if strings.HasPrefix(result, "ERR") || strings.HasPrefix(result, "NOAUTH") || strings.HasPrefix(result, "WRONGPASS") {
+		return "", fmt.Errorf("%s: redis-cli %s: %s", pod.Name, strings.Join(args, " "), result)
+	}
+	return result, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+)
+
+var failoverTarget string
+
+var failoverCommand = &command{
+	usage: "failover NAME [--to POD]",
+	short: "Promote a replica to primary and repoint the other pods",
+	run:   runFailover,
+	flags: func(fs *flag.FlagSet) {
+		fs.StringVar(&failoverTarget, "to", "", "Pod to promote. Defaults to the replica with the highest replication offset.")
+	},
+}
+
+// chooseFailoverTarget 选择要提升的从节点
+func chooseFailoverTarget(nodes []*node, to string) (*node, error) {
+	if to != "" {
+		for _, n := range nodes {
+			if n.pod.Name != to {
+				continue
+			}
+			if n.errorText != "" {
+				return nil, fmt.Errorf("pod %s is unreachable: %s", to, n.errorText)
+			}
+			return n, nil
+		}
+		return nil, fmt.Errorf("pod %s is not a running pod of this redis", to)
+	}
+
+	var best *node
+	for _, n := range nodes {
+		if n.errorText != "" || n.role != "slave" {
+			continue
+		}
+		if best == nil || n.offset > best.offset {
+			best = n
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no replica to promote, replication is not configured")
+	}
+	return best, nil
+}
+
+// currentPrimary 返回当前的主节点。
+// 只有所有 pod 都能访问， 并且除主节点外都是它的从节点时才允许切换，
+// 否则 REPLICAOF 会让独立运行的 pod 清空数据去同步新的主节点。
+func currentPrimary(nodes []*node, port string) (*node, error) {
+	for _, n := range nodes {
+		if n.errorText != "" {
+			return nil, fmt.Errorf("pod %s is unreachable, cannot verify replication: %s", n.pod.Name, n.errorText)
+		}
+	}
+
+	masters := primaries(nodes)
+	if len(masters) != 1 {
+		return nil, fmt.Errorf("found %d primaries, replication is not configured", len(masters))
+	}
+	primary := masters[0]
+
+	for _, n := range nodes {
+		if n == primary {
+			continue
+		}
+		if n.masterHost != primary.pod.Status.PodIP || n.masterPort != port {
+			return nil, fmt.Errorf("pod %s is not a replica of the primary %s", n.pod.Name, primary.pod.Name)
+		}
+	}
+	return primary, nil
+}
+
+func runFailover(ctx context.Context, o *options, args []string) error {
+	name, err := requireName(args)
+	if err != nil {
+		return err
+	}
+	redis, err := o.getRedis(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := o.topology(ctx, redis)
+	if err != nil {
+		return err
+	}
+
+	port := strconv.Itoa(int(redis.Spec.Port))
+	primary, err := currentPrimary(nodes, port)
+	if err != nil {
+		return fmt.Errorf("refusing to fail over: %w", err)
+	}
+	target, err := chooseFailoverTarget(nodes, failoverTarget)
+	if err != nil {
+		return err
+	}
+	if target == primary {
+		return fmt.Errorf("pod %s is already the primary", target.pod.Name)
+	}
+
+	if _, err := o.redisCLI(redis, target.pod, "REPLICAOF", "NO", "ONE"); err != nil {
+		return err
+	}
+	fmt.Printf("%s promoted to primary\n", target.pod.Name)
+
+	for _, n := range nodes {
+		if n == target {
+			continue
+		}
+		if _, err := o.redisCLI(redis, n.pod, "REPLICAOF", target.pod.Status.PodIP, port); err != nil {
+			return err
+		}
+		fmt.Printf("%s now replicates from %s\n", n.pod.Name, target.pod.Name)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// kubectl-redis 是 redis operator 的 kubectl 插件， 提供日常运维命令。
+//
+// 将编译后的 kubectl-redis 放到 PATH 中即可通过 kubectl redis <command> 使用。
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// command 插件子命令
+type command struct {
+	usage string
+	short string
+	run   func(ctx context.Context, o *options, args []string) error
+	// flags 注册子命令自己的参数
+	flags func(fs *flag.FlagSet)
+}
+
+var commands = map[string]*command{
+	"status":            statusCommand,
+	"failover":          failoverCommand,
+	"backup":            backupCommand,
+	"cli":               cliCommand,
+	"pause":             pauseCommand,
+	"resume":            resumeCommand,
+	"describe-topology": topologyCommand,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	cmd, ok := commands[name]
+	if !ok {
+		if name != "-h" && name != "--help" && name != "help" {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		}
+		usage()
+		os.Exit(2)
+	}
+
+	o := &options{}
+	fs := flag.NewFlagSet("kubectl redis "+name, flag.ExitOnError)
+	o.bindFlags(fs)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: kubectl redis %s\n\n%s\n\nFlags:\n", cmd.usage, cmd.short)
+		fs.PrintDefaults()
+	}
+
+	args := parseInterspersed(fs, os.Args[2:])
+
+	if err := o.complete(); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+
+	if err := cmd.run(context.Background(), o, args); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Day-2 operations for Redis objects managed by the redis operator.")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-18s %s\n", name, commands[name].short)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Use \"kubectl redis <command> -h\" for more information about a command.")
+}
+
+// parseInterspersed 允许参数和位置参数混用， 例如 kubectl redis status my-redis -n demo。
+// "--" 之后的参数不做解析， 原样追加到位置参数之后。
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var passthrough []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, passthrough = args[:i], args[i+1:]
+			break
+		}
+	}
+
+	var positional []string
+	for {
+		_ = fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return append(positional, passthrough...)
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+func TestParseInterspersed(t *testing.T) {
+	o := &options{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	o.bindFlags(fs)
+
+	args := parseInterspersed(fs, []string{"cache", "-n", "demo", "--", "GET", "-n"})
+
+	if o.namespace != "demo" {
+		t.Fatalf("namespace = %q, want demo", o.namespace)
+	}
+	if want := []string{"cache", "GET", "-n"}; !reflect.DeepEqual(args, want) {
+		t.Fatalf("args = %v, want %v", args, want)
+	}
+}
+
+func newNode(name, ip, info string) *node {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     corev1.PodStatus{PodIP: ip},
+	}
+	return parseReplicationInfo(pod, info)
+}
+
+func TestParseReplicationInfo(t *testing.T) {
+	n := newNode("cache-1", "10.0.0.2", "# Replication\r\nrole:slave\r\nmaster_host:10.0.0.1\r\nmaster_port:6379\r\nmaster_link_status:up\r\nslave_repl_offset:42\r\nconnected_slaves:0\r\n")
+
+	if n.role != "slave" || n.masterHost != "10.0.0.1" || n.masterPort != "6379" || n.linkStatus != "up" || n.offset != 42 {
+		t.Fatalf("unexpected node %+v", n)
+	}
+	if n.isPrimary() {
+		t.Fatal("replica reported as primary")
+	}
+}
+
+func TestChooseFailoverTarget(t *testing.T) {
+	nodes := []*node{
+		newNode("cache-0", "10.0.0.1", "role:master\nmaster_repl_offset:100\nconnected_slaves:2\n"),
+		newNode("cache-1", "10.0.0.2", "role:slave\nmaster_host:10.0.0.1\nmaster_port:6379\nslave_repl_offset:90\n"),
+		newNode("cache-2", "10.0.0.3", "role:slave\nmaster_host:10.0.0.1\nmaster_port:6379\nslave_repl_offset:99\n"),
+	}
+
+	target, err := chooseFailoverTarget(nodes, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.pod.Name != "cache-2" {
+		t.Fatalf("target = %s, want the most up to date replica cache-2", target.pod.Name)
+	}
+
+	target, err = chooseFailoverTarget(nodes, "cache-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.pod.Name != "cache-1" {
+		t.Fatalf("target = %s, want cache-1", target.pod.Name)
+	}
+
+	if _, err := chooseFailoverTarget(nodes[:1], ""); err == nil {
+		t.Fatal("expected an error without replicas")
+	}
+}
+
+func TestCurrentPrimary(t *testing.T) {
+	primary := newNode("cache-0", "10.0.0.1", "role:master\n")
+	replica := newNode("cache-1", "10.0.0.2", "role:slave\nmaster_host:10.0.0.1\nmaster_port:6379\n")
+
+	got, err := currentPrimary([]*node{primary, replica}, "6379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != primary {
+		t.Fatalf("primary = %s, want cache-0", got.pod.Name)
+	}
+
+	for name, nodes := range map[string][]*node{
+		// 没有配置复制时每个 pod 都是独立的主节点
+		"standalone":    {primary, newNode("cache-1", "10.0.0.2", "role:master\n")},
+		"other primary": {primary, newNode("cache-1", "10.0.0.2", "role:slave\nmaster_host:10.0.0.9\nmaster_port:6379\n")},
+		"other port":    {primary, newNode("cache-1", "10.0.0.2", "role:slave\nmaster_host:10.0.0.1\nmaster_port:6380\n")},
+		"unreachable":   {primary, replica, {pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "cache-2"}}, errorText: "timeout"}},
+	} {
+		if _, err := currentPrimary(nodes, "6379"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestRedisCLICommandTLS(t *testing.T) {
+	redis := &myappv1.Redis{Spec: myappv1.RedisSpec{Port: 6379, TLS: &myappv1.RedisTLS{Enabled: true}}}
+
+	got := redisCLICommand(redis, "PING")
+	want := []string{"-h", "localhost", "--tls", "--cacert", "/tls/ca.crt", "PING"}
+	if !reflect.DeepEqual(got[4:], want) {
+		t.Fatalf("redis-cli args = %v, want %v", got[4:], want)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+var pauseCommand = &command{
+	usage: "pause NAME",
+	short: "Stop the operator from reconciling a Redis",
+	run: func(ctx context.Context, o *options, args []string) error {
+		return setPaused(ctx, o, args, true)
+	},
+}
+
+var resumeCommand = &command{
+	usage: "resume NAME",
+	short: "Resume reconciliation of a paused Redis",
+	run: func(ctx context.Context, o *options, args []string) error {
+		return setPaused(ctx, o, args, false)
+	},
+}
+
+// setPaused 通过 merge patch 设置或删除 PausedAnnotation
+func setPaused(ctx context.Context, o *options, args []string, paused bool) error {
+	name, err := requireName(args)
+	if err != nil {
+		return err
+	}
+	redis, err := o.getRedis(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	patch := client.MergeFrom(redis.DeepCopy())
+	if paused {
+		if redis.Annotations == nil {
+			redis.Annotations = map[string]string{}
+		}
+		redis.Annotations[myappv1.PausedAnnotation] = "true"
+	} else {
+		delete(redis.Annotations, myappv1.PausedAnnotation)
+	}
+
+	if err := o.client.Patch(ctx, redis, patch); err != nil {
+		return err
+	}
+
+	if paused {
+		fmt.Printf("redis/%s paused\n", redis.Name)
+	} else {
+		fmt.Printf("redis/%s resumed\n", redis.Name)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+var statusCommand = &command{
+	usage: "status NAME",
+	short: "Show a Redis together with its pods and recent events",
+	run:   runStatus,
+}
+
+func runStatus(ctx context.Context, o *options, args []string) error {
+	name, err := requireName(args)
+	if err != nil {
+		return err
+	}
+	redis, err := o.getRedis(ctx, name)
+	if err != nil {
+		return err
+	}
+	pods, err := o.listPods(ctx, redis)
+	if err != nil {
+		return err
+	}
+
+	eventList := &corev1.EventList{}
+	err = o.client.List(ctx, eventList,
+		client.InNamespace(redis.Namespace),
+		client.MatchingFields{"involvedObject.name": redis.Name},
+	)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Name:\t%s\n", redis.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", redis.Namespace)
+	fmt.Fprintf(w, "Image:\t%s\n", redis.Spec.Image)
+	fmt.Fprintf(w, "Port:\t%d\n", redis.Spec.Port)
+	fmt.Fprintf(w, "Replicas:\t%d desired | %d managed | %d ready\n",
+		redis.Spec.Replicas, redis.Status.Replicas, countReady(pods))
+	fmt.Fprintf(w, "Auth:\t%t\n", redis.Spec.Auth != nil)
+	fmt.Fprintf(w, "Monitoring:\t%t\n", redis.MonitoringEnabled())
+	fmt.Fprintf(w, "Paused:\t%t\n", redis.Annotations[myappv1.PausedAnnotation] == "true")
+	if !redis.DeletionTimestamp.IsZero() {
+		fmt.Fprintf(w, "Deleting:\tsince %s\n", age(now, redis.DeletionTimestamp.Time))
+	}
+
+	if len(redis.Status.Conditions) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "CONDITION\tSTATUS\tREASON\tAGE\tMESSAGE")
+		for _, cond := range redis.Status.Conditions {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				cond.Type, cond.Status, cond.Reason, age(now, cond.LastTransitionTime.Time), cond.Message)
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "POD\tPHASE\tREADY\tRESTARTS\tIP\tNODE\tAGE")
+	for _, pod := range pods {
+		ready, restarts := 0, int32(0)
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Ready {
+				ready++
+			}
+			restarts += cs.RestartCount
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d/%d\t%d\t%s\t%s\t%s\n",
+			pod.Name, pod.Status.Phase, ready, len(pod.Spec.Containers), restarts,
+			orNone(pod.Status.PodIP), orNone(pod.Spec.NodeName), age(now, pod.CreationTimestamp.Time))
+	}
+
+	events := eventList.Items
+	sort.Slice(events, func(i, j int) bool {
+		return eventTime(events[i]).Before(eventTime(events[j]))
+	})
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "TYPE\tREASON\tAGE\tMESSAGE")
+	for _, e := range events {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Type, e.Reason, age(now, eventTime(e)), e.Message)
+	}
+	return w.Flush()
+}
+
+// countReady 统计 Ready 的 pod 数量
+func countReady(pods []corev1.Pod) int {
+	n := 0
+	for _, pod := range pods {
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func eventTime(e corev1.Event) time.Time {
+	if !e.LastTimestamp.IsZero() {
+		return e.LastTimestamp.Time
+	}
+	if !e.EventTime.IsZero() {
+		return e.EventTime.Time
+	}
+	return e.CreationTimestamp.Time
+}
+
+func age(now, t time.Time) string {
+	if t.IsZero() {
+		return "<unknown>"
+	}
+	return duration.HumanDuration(now.Sub(t))
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
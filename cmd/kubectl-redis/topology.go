@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+var topologyCommand = &command{
+	usage: "describe-topology NAME",
+	short: "Show the replication role of every pod",
+	run:   runTopology,
+}
+
+// node 一个 redis 实例的复制信息， 来自 INFO replication
+type node struct {
+	pod *corev1.Pod
+	// role master 或 slave
+	role       string
+	masterHost string
+	masterPort string
+	linkStatus string
+	// offset 主节点为 master_repl_offset， 从节点为 slave_repl_offset
+	offset    int64
+	replicas  int
+	errorText string
+}
+
+func (n *node) isPrimary() bool {
+	return n.errorText == "" && n.role == "master"
+}
+
+// parseReplicationInfo 解析 INFO replication 的输出
+func parseReplicationInfo(pod *corev1.Pod, info string) *node {
+	n := &node{pod: pod}
+	values := map[string]string{}
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		values[line[:i]] = line[i+1:]
+	}
+
+	n.role = values["role"]
+	n.masterHost = values["master_host"]
+	n.masterPort = values["master_port"]
+	n.linkStatus = values["master_link_status"]
+	n.replicas, _ = strconv.Atoi(values["connected_slaves"])
+
+	offset := values["master_repl_offset"]
+	if n.role == "slave" {
+		offset = values["slave_repl_offset"]
+	}
+	n.offset, _ = strconv.ParseInt(offset, 10, 64)
+	return n
+}
+
+// topology 查询所有运行中 pod 的复制信息
+func (o *options) topology(ctx context.Context, redis *myappv1.Redis) ([]*node, error) {
+	pods, err := o.runningPods(ctx, redis)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]*node, 0, len(pods))
+	for i := range pods {
+		pod := &pods[i]
+		info, err := o.redisCLI(redis, pod, "INFO", "replication")
+		if err != nil {
+			nodes = append(nodes, &node{pod: pod, errorText: err.Error()})
+			continue
+		}
+		nodes = append(nodes, parseReplicationInfo(pod, info))
+	}
+	return nodes, nil
+}
+
+// primaries 返回角色为 master 的实例。
+// 没有配置复制时每个 pod 都是独立的 master。
+func primaries(nodes []*node) []*node {
+	var result []*node
+	for _, n := range nodes {
+		if n.isPrimary() {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// podByIP 根据 master_host 找到对应的 pod 名称
+func podByIP(nodes []*node, ip string) string {
+	for _, n := range nodes {
+		if n.pod.Status.PodIP == ip {
+			return n.pod.Name
+		}
+	}
+	return ip
+}
+
+func runTopology(ctx context.Context, o *options, args []string) error {
+	name, err := requireName(args)
+	if err != nil {
+		return err
+	}
+	redis, err := o.getRedis(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := o.topology(ctx, redis)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "POD\tIP\tROLE\tMASTER\tLINK\tOFFSET\tREPLICAS")
+	for _, n := range nodes {
+		if n.errorText != "" {
+			fmt.Fprintf(w, "%s\t%s\tunknown\t-\t-\t-\t-\n", n.pod.Name, n.pod.Status.PodIP)
+			continue
+		}
+
+		master, link := "-", "-"
+		if n.role == "slave" {
+			master = podByIP(nodes, n.masterHost) + ":" + n.masterPort
+			link = n.linkStatus
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\n",
+			n.pod.Name, n.pod.Status.PodIP, n.role, master, link, n.offset, n.replicas)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for _, n := range nodes {
+		if n.errorText != "" {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", n.errorText)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/events"
+	"github.com/tangx/k8s-operator-demo/controllers/helper2"
+)
+
+// benchmarkRedisCount 基准测试中创建的 redis 数量
+const benchmarkRedisCount = 1000
+
+// BenchmarkReconcile 在 envtest 中创建 1000 个 redis， 测量稳定状态下调谐全部 redis 的耗时。
+// pod 通过 cache 索引读取， 每次调谐只发起一次 List 请求， 不再逐个 Get。
+//
+//	make bench
+func BenchmarkReconcile(b *testing.B) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		b.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+
+	env := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases")},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := env.Start()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = env.Stop() }()
+
+	if err := myappv1.AddToScheme(scheme.Scheme); err != nil {
+		b.Fatal(err)
+	}
+
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:             scheme.Scheme,
+		MetricsBindAddress: "0",
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := helper2.SetupIndexes(ctx, mgr.GetFieldIndexer()); err != nil {
+		b.Fatal(err)
+	}
+	recorder, err := events.NewRecorder(record.NewFakeRecorder(benchmarkRedisCount*10), events.LanguageEnglish)
+	if err != nil {
+		b.Fatal(err)
+	}
+	// 不注册 controller， 由基准测试直接调用 Reconcile
+	r := &RedisReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		EventRecord: recorder,
+	}
+
+	go func() {
+		_ = mgr.Start(ctx)
+	}()
+	if !mgr.GetCache().WaitForCacheSync(ctx) {
+		b.Fatal("cache did not sync")
+	}
+
+	c := mgr.GetClient()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "redis-benchmark"}}
+	if err := c.Create(ctx, ns); err != nil {
+		b.Fatal(err)
+	}
+
+	var requests []ctrl.Request
+	for i := 0; i < benchmarkRedisCount; i++ {
+		redis := &myappv1.Redis{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("bench-%04d", i),
+				Namespace: ns.Name,
+			},
+			Spec: myappv1.RedisSpec{
+				Replicas: 1,
+				Port:     6379,
+				Image:    "redis:5-alpine",
+			},
+		}
+		if err := c.Create(ctx, redis); err != nil {
+			b.Fatal(err)
+		}
+		requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(redis)})
+	}
+
+	// 首次调谐创建 pod， 不计入耗时
+	reconcileAll(ctx, b, r, requests)
+	waitForPods(ctx, b, c, ns.Name, benchmarkRedisCount)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		reconcileAll(ctx, b, r, requests)
+	}
+}
+
+func reconcileAll(ctx context.Context, b *testing.B, r *RedisReconciler, requests []ctrl.Request) {
+	b.Helper()
+
+	for _, req := range requests {
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// waitForPods 等待 cache 中出现全部 pod
+func waitForPods(ctx context.Context, b *testing.B, c client.Client, namespace string, want int) {
+	b.Helper()
+
+	for {
+		pods := &corev1.PodList{}
+		if err := c.List(ctx, pods, client.InNamespace(namespace)); err != nil {
+			b.Fatal(err)
+		}
+		if len(pods.Items) >= want {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			b.Fatal(ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package builder
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Service Binding 规范 (https://servicebinding.io) 中 secret 的类型和 key
+const (
+	BindingSecretType corev1.SecretType = "servicebinding.io/redis"
+
+	BindingType     = "type"
+	BindingProvider = "provider"
+	BindingHost     = "host"
+	BindingPort     = "port"
+	BindingPassword = "password"
+	BindingURI      = "uri"
+	BindingCA       = "ca.crt"
+)
+
+// BindingSecretName 返回保存连接信息的 secret 名称
+func BindingSecretName(redis *appv1.Redis) string {
+	return redis.Name + "-binding"
+}
+
+// BindingSecret 生成应用连接 redis 使用的 secret。
+// secret 中包含密码和 CA， 需要在调谐时读取， 因此不包含在 Build 的结果中。
+// password 为空表示没有开启密码认证， ca 为空表示没有开启 TLS。
+func BindingSecret(redis *appv1.Redis, password string, ca []byte, scheme *runtime.Scheme) (*corev1.Secret, error) {
+
+	secret := &corev1.Secret{}
+	secret.Name = BindingSecretName(redis)
+	secret.Namespace = redis.Namespace
+	secret.Labels = SelectorLabels(redis)
+	secret.Type = BindingSecretType
+
+	if err := controllerutil.SetOwnerReference(redis, secret, scheme); err != nil {
+		return nil, err
+	}
+
+	host := fmt.Sprintf("%s.%s.svc", redis.Name, redis.Namespace)
+	port := strconv.Itoa(int(redis.Spec.Port))
+
+	uri := &url.URL{Scheme: "redis", Host: net.JoinHostPort(host, port)}
+	if redis.TLSEnabled() {
+		uri.Scheme = "rediss"
+	}
+
+	secret.Data = map[string][]byte{
+		BindingType:     []byte("redis"),
+		BindingProvider: []byte(ManagedBy),
+		BindingHost:     []byte(host),
+		BindingPort:     []byte(port),
+	}
+	if password != "" {
+		uri.User = url.UserPassword("", password)
+		secret.Data[BindingPassword] = []byte(password)
+	}
+	if len(ca) > 0 {
+		secret.Data[BindingCA] = ca
+	}
+	secret.Data[BindingURI] = []byte(uri.String())
+
+	return secret, nil
+}
@@ -0,0 +1,50 @@
+package builder
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+func TestBindingSecret(t *testing.T) {
+	redis := &appv1.Redis{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default", UID: "redis-uid"},
+		Spec:       appv1.RedisSpec{Port: 6380},
+	}
+
+	secret, err := BindingSecret(redis, "", nil, testScheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(secret.Data[BindingURI]); got != "redis://cache.default.svc:6380" {
+		t.Errorf("uri = %q", got)
+	}
+	if _, ok := secret.Data[BindingPassword]; ok {
+		t.Error("password must be omitted without auth")
+	}
+
+	redis.Spec.TLS = &appv1.RedisTLS{Enabled: true}
+	secret, err = BindingSecret(redis, "p@ss/word", []byte("ca"), testScheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		BindingType:     "redis",
+		BindingProvider: "redis-operator",
+		BindingHost:     "cache.default.svc",
+		BindingPort:     "6380",
+		BindingPassword: "p@ss/word",
+		BindingCA:       "ca",
+		BindingURI:      "rediss://:p%40ss%2Fword@cache.default.svc:6380",
+	}
+	for key, value := range want {
+		if got := string(secret.Data[key]); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	if secret.Type != BindingSecretType || secret.Name != "cache-binding" {
+		t.Errorf("secret %s has type %s", secret.Name, secret.Type)
+	}
+}
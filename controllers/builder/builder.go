@@ -0,0 +1,138 @@
+// Package builder 根据 redis 生成 operator 管理的全部 k8s 对象。
+// 这里只负责生成对象， 不访问 k8s， 便于调谐、 离线渲染和 golden 测试共用。
+package builder
+
+import (
+	"bytes"
+	"fmt"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/yaml"
+)
+
+// PodName 返回 redis 第 i 个 pod 的名称
+func PodName(redis *appv1.Redis, i int) string {
+	return fmt.Sprintf("%s-%d", redis.Name, i)
+}
+
+// operator 管理的对象使用的推荐标签。
+// 不再使用 app 标签， 它很容易与其他工作负载的标签冲突。
+const (
+	LabelName      = "app.kubernetes.io/name"
+	LabelInstance  = "app.kubernetes.io/instance"
+	LabelManagedBy = "app.kubernetes.io/managed-by"
+
+	// AppName LabelName 的值
+	AppName = "redis"
+	// ManagedBy LabelManagedBy 的值
+	ManagedBy = "redis-operator"
+)
+
+// SelectorLabels 返回 redis 管理的 pod 的标签， service 通过这些标签选择 pod
+func SelectorLabels(redis *appv1.Redis) map[string]string {
+	return map[string]string{
+		LabelName:      AppName,
+		LabelInstance:  redis.Name,
+		LabelManagedBy: ManagedBy,
+	}
+}
+
+// Labels 返回 pod 和 service 的标签。
+// redis 的标签会复制到这些对象上， 按标签过滤 redis 的分片 operator 可以用同样的标签过滤它们。
+func Labels(redis *appv1.Redis) map[string]string {
+	labels := map[string]string{}
+	for k, v := range redis.Labels {
+		labels[k] = v
+	}
+	for k, v := range SelectorLabels(redis) {
+		labels[k] = v
+	}
+	return labels
+}
+
+// Options 生成对象时使用的 operator 配置
+type Options struct {
+	// OperatorNamespace operator 所在的 namespace， 为空时 NetworkPolicy 中不包含 operator
+	OperatorNamespace string
+
+	// Versions 解析 spec.version 的版本目录， 为空时使用内置目录
+	Versions appv1.VersionCatalog
+}
+
+// Build 返回 redis 期望的全部对象
+func Build(redis *appv1.Redis, opts Options, scheme *runtime.Scheme) ([]client.Object, error) {
+
+	var objs []client.Object
+
+	svc, err := Service(redis, scheme)
+	if err != nil {
+		return nil, err
+	}
+	objs = append(objs, svc)
+
+	if redis.NetworkPolicyEnabled() {
+		np, err := NetworkPolicy(redis, opts.OperatorNamespace, scheme)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, np)
+	}
+
+	if redis.ServiceMonitorEnabled() {
+		sm, err := ServiceMonitor(redis, scheme)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, sm)
+	}
+
+	// 没有指定 issuerRef 时证书由 operator 在调谐时签发， 不在这里生成
+	if redis.TLSEnabled() && redis.Spec.TLS.IssuerRef != nil {
+		cert, err := Certificate(redis, scheme)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, cert)
+	}
+
+	for i := 0; i < redis.Spec.Replicas; i++ {
+		if redis.PersistentStorage() {
+			objs = append(objs, PersistentVolumeClaim(redis, PodName(redis, i)))
+		}
+		pod, err := Pod(redis, PodName(redis, i), opts.Versions, scheme)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, pod)
+	}
+
+	return objs, nil
+}
+
+// RenderYAML 将对象渲染为多文档 YAML
+func RenderYAML(objs []client.Object, scheme *runtime.Scheme) ([]byte, error) {
+
+	buf := &bytes.Buffer{}
+	for _, obj := range objs {
+		// typed 对象没有 apiVersion 和 kind， 从 scheme 中补全
+		gvk, err := apiutil.GVKForObject(obj, scheme)
+		if err != nil {
+			return nil, err
+		}
+		obj = obj.DeepCopyObject().(client.Object)
+		obj.GetObjectKind().SetGroupVersionKind(gvk)
+
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, fmt.Errorf("渲染 %s %s 失败: %w", gvk.Kind, obj.GetName(), err)
+		}
+
+		buf.WriteString("---\n")
+		buf.Write(data)
+	}
+
+	return buf.Bytes(), nil
+}
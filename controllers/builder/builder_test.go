@@ -0,0 +1,83 @@
+package builder
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+// 修改生成逻辑后执行 go test ./controllers/builder -update 更新 golden 文件，
+// review 时通过 golden 文件的 diff 查看最终下发到集群中的对象变化。
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+func testScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(appv1.AddToScheme(scheme))
+	return scheme
+}
+
+// TestGolden 渲染 testdata/<case>.redis.yaml 对应的全部对象， 与 testdata/<case>.golden.yaml 比较
+func TestGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.redis.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no test cases found in testdata")
+	}
+
+	scheme := testScheme()
+	opts := Options{OperatorNamespace: "redis-operator-system"}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".redis.yaml")
+		golden := filepath.Join("testdata", name+".golden.yaml")
+
+		t.Run(name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			redis := &appv1.Redis{}
+			if err := yaml.UnmarshalStrict(data, redis); err != nil {
+				t.Fatalf("decode %s: %v", input, err)
+			}
+
+			objs, err := Build(redis, opts, scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := RenderYAML(objs, scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("read golden file (run with -update to create it): %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("rendered objects differ from %s (run with -update to accept):\n%s", golden, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package builder
+
+import (
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// ServiceMonitorGVK prometheus-operator 的 ServiceMonitor 类型。
+// 为了不引入 prometheus-operator 依赖， 使用 unstructured 操作。
+var ServiceMonitorGVK = schema.GroupVersionKind{
+	Group:   "monitoring.coreos.com",
+	Version: "v1",
+	Kind:    "ServiceMonitor",
+}
+
+// ServiceMonitor 生成 redis 的 ServiceMonitor
+func ServiceMonitor(redis *appv1.Redis, scheme *runtime.Scheme) (*unstructured.Unstructured, error) {
+
+	sm := &unstructured.Unstructured{}
+	sm.SetGroupVersionKind(ServiceMonitorGVK)
+	sm.SetName(redis.Name)
+	sm.SetNamespace(redis.Namespace)
+
+	labels := SelectorLabels(redis)
+	for k, v := range redis.Spec.Monitoring.ServiceMonitor.Labels {
+		labels[k] = v
+	}
+	sm.SetLabels(labels)
+
+	if err := controllerutil.SetOwnerReference(redis, sm, scheme); err != nil {
+		return nil, err
+	}
+
+	endpoint := map[string]interface{}{
+		"port": "metrics",
+		"path": "/metrics",
+	}
+	if interval := redis.Spec.Monitoring.ServiceMonitor.Interval; interval != "" {
+		endpoint["interval"] = interval
+	}
+
+	matchLabels := map[string]interface{}{}
+	for k, v := range SelectorLabels(redis) {
+		matchLabels[k] = v
+	}
+
+	spec := map[string]interface{}{
+		"endpoints": []interface{}{endpoint},
+		"selector": map[string]interface{}{
+			"matchLabels": matchLabels,
+		},
+	}
+	if err := unstructured.SetNestedField(sm.Object, spec, "spec"); err != nil {
+		return nil, err
+	}
+
+	return sm, nil
+}
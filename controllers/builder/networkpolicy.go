@@ -0,0 +1,79 @@
+package builder
+
+import (
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// OperatorPodLabels operator pod 的标签， 与 config/manager 中的 Deployment 相同
+var OperatorPodLabels = map[string]string{"control-plane": "controller-manager"}
+
+// NetworkPolicy 生成只允许 spec.networkPolicy 中的客户端访问 redis 的 NetworkPolicy。
+// operator 需要直接连接 redis 执行最终备份、 统计客户端连接等操作，
+// operatorNamespace 为 operator 所在的 namespace， 为空时 NetworkPolicy 中不包含 operator。
+func NetworkPolicy(redis *appv1.Redis, operatorNamespace string, scheme *runtime.Scheme) (*networkingv1.NetworkPolicy, error) {
+
+	np := &networkingv1.NetworkPolicy{}
+	np.Name = redis.Name
+	np.Namespace = redis.Namespace
+	np.Labels = SelectorLabels(redis)
+
+	if err := controllerutil.SetOwnerReference(redis, np, scheme); err != nil {
+		return nil, err
+	}
+
+	redisPort := []networkingv1.NetworkPolicyPort{port(intstr.FromInt(int(redis.Spec.Port)))}
+
+	// redis pod 之间的主从复制
+	from := []networkingv1.NetworkPolicyPeer{
+		{PodSelector: &metav1.LabelSelector{MatchLabels: SelectorLabels(redis)}},
+	}
+	if operatorNamespace != "" {
+		from = append(from, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{corev1.LabelMetadataName: operatorNamespace},
+			},
+			PodSelector: &metav1.LabelSelector{MatchLabels: OperatorPodLabels},
+		})
+	}
+	from = append(from, peers(redis.Spec.NetworkPolicy.Clients)...)
+
+	np.Spec = networkingv1.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{MatchLabels: SelectorLabels(redis)},
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		Ingress: []networkingv1.NetworkPolicyIngressRule{
+			{From: from, Ports: redisPort},
+		},
+	}
+
+	// 没有指定 metricsScrapers 时 metrics 端口不限制来源
+	if redis.MonitoringEnabled() {
+		np.Spec.Ingress = append(np.Spec.Ingress, networkingv1.NetworkPolicyIngressRule{
+			From:  peers(redis.Spec.NetworkPolicy.MetricsScrapers),
+			Ports: []networkingv1.NetworkPolicyPort{port(intstr.FromInt(int(ExporterPort(redis))))},
+		})
+	}
+
+	return np, nil
+}
+
+func peers(in []appv1.NetworkPolicyPeer) []networkingv1.NetworkPolicyPeer {
+	var out []networkingv1.NetworkPolicyPeer
+	for _, p := range in {
+		out = append(out, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: p.NamespaceSelector.DeepCopy(),
+			PodSelector:       p.PodSelector.DeepCopy(),
+		})
+	}
+	return out
+}
+
+func port(p intstr.IntOrString) networkingv1.NetworkPolicyPort {
+	protocol := corev1.ProtocolTCP
+	return networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &p}
+}
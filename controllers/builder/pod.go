@@ -0,0 +1,156 @@
+package builder
+
+import (
+	"fmt"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Pod 生成 redis pod
+func Pod(redis *appv1.Redis, name string, versions appv1.VersionCatalog, scheme *runtime.Scheme) (*corev1.Pod, error) {
+
+	pod := &corev1.Pod{}
+	pod.Name = name
+	pod.Namespace = redis.Namespace
+
+	// 创建 pod 时添加 OwnerReference
+	if err := controllerutil.SetOwnerReference(redis, pod, scheme); err != nil {
+		return nil, err
+	}
+
+	// 增加 label 便于删除
+	pod.ObjectMeta.Labels = Labels(redis)
+
+	// 设置了 spec.version 时从版本目录中解析镜像
+	image, err := redis.RedisImage(versions)
+	if err != nil {
+		return nil, err
+	}
+	pod.Spec.Containers = []corev1.Container{
+		redisContainer(redis, image),
+	}
+
+	// 开启监控时注入 redis_exporter sidecar
+	if redis.MonitoringEnabled() {
+		pod.Spec.Containers = append(pod.Spec.Containers, exporterContainer(redis))
+	}
+
+	// 数据目录挂载到 pod 自己的 PVC
+	if redis.PersistentStorage() {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, dataPodVolume(name))
+	}
+
+	// 开启 TLS 时挂载证书， 并记录证书的 hash， 证书更新后由 operator 轮换 pod
+	if redis.TLSEnabled() {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, tlsPodVolume(redis))
+		if hash := redis.TLSCertificateHash(); hash != "" {
+			pod.Annotations = map[string]string{appv1.TLSHashAnnotation: hash}
+		}
+	}
+
+	return pod, nil
+}
+
+func redisContainer(redis *appv1.Redis, image string) corev1.Container {
+	container := corev1.Container{
+		Name:            redis.Name,
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Args: []string{
+			"redis-server",
+		},
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          "redis",
+				ContainerPort: redis.Spec.Port,
+			},
+		},
+	}
+
+	if redis.PersistentStorage() {
+		container.Args = append(container.Args, "--dir", DataMountPath)
+		container.VolumeMounts = append(container.VolumeMounts, dataVolumeMount())
+	}
+
+	if redis.TLSEnabled() {
+		container.Args = append(container.Args, tlsArgs(redis)...)
+		container.VolumeMounts = append(container.VolumeMounts, tlsVolumeMount())
+	} else {
+		container.Args = append(container.Args, "--port", fmt.Sprintf("%d", redis.Spec.Port))
+	}
+
+	// 开启密码认证， 密码通过环境变量从 secret 注入， 不出现在 pod spec 中
+	if redis.Spec.Auth != nil {
+		container.Env = []corev1.EnvVar{passwordEnv(redis)}
+		container.Args = append(container.Args, "--requirepass", "$(REDIS_PASSWORD)")
+	}
+
+	return container
+}
+
+func exporterContainer(redis *appv1.Redis) corev1.Container {
+	image := redis.Spec.Monitoring.Image
+	if image == "" {
+		image = appv1.DefaultExporterImage
+	}
+
+	container := corev1.Container{
+		Name:            "redis-exporter",
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Env: []corev1.EnvVar{
+			{
+				Name:  "REDIS_ADDR",
+				Value: fmt.Sprintf("redis://localhost:%d", redis.Spec.Port),
+			},
+			{
+				Name:  "REDIS_EXPORTER_WEB_LISTEN_ADDRESS",
+				Value: fmt.Sprintf(":%d", ExporterPort(redis)),
+			},
+		},
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          "metrics",
+				ContainerPort: ExporterPort(redis),
+			},
+		},
+	}
+
+	// exporter 通过 localhost 连接， 证书中包含 localhost
+	if redis.TLSEnabled() {
+		container.Env[0].Value = fmt.Sprintf("rediss://localhost:%d", redis.Spec.Port)
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  "REDIS_EXPORTER_TLS_CA_CERT_FILE",
+			Value: TLSMountPath + "/" + TLSCAKey,
+		})
+		container.VolumeMounts = []corev1.VolumeMount{tlsVolumeMount()}
+	}
+
+	// exporter 使用与 redis 相同的密码
+	if redis.Spec.Auth != nil {
+		container.Env = append(container.Env, passwordEnv(redis))
+	}
+
+	return container
+}
+
+func passwordEnv(redis *appv1.Redis) corev1.EnvVar {
+	ref := redis.Spec.Auth.PasswordSecret
+	return corev1.EnvVar{
+		Name: "REDIS_PASSWORD",
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &ref,
+		},
+	}
+}
+
+// ExporterPort 返回 redis_exporter 的 metrics 端口
+func ExporterPort(redis *appv1.Redis) int32 {
+	if redis.Spec.Monitoring.Port == 0 {
+		return appv1.DefaultExporterPort
+	}
+	return redis.Spec.Monitoring.Port
+}
@@ -0,0 +1,43 @@
+package builder
+
+import (
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Service 生成 redis service
+func Service(redis *appv1.Redis, scheme *runtime.Scheme) (*corev1.Service, error) {
+
+	svc := &corev1.Service{}
+	svc.Name = redis.Name
+	svc.Namespace = redis.Namespace
+
+	if err := controllerutil.SetOwnerReference(redis, svc, scheme); err != nil {
+		return nil, err
+	}
+
+	svc.ObjectMeta.Labels = Labels(redis)
+	svc.Spec.Selector = SelectorLabels(redis)
+
+	svc.Spec.Ports = []corev1.ServicePort{
+		{
+			Name:       "redis",
+			Port:       redis.Spec.Port,
+			TargetPort: intstr.FromString("redis"),
+		},
+	}
+
+	// 开启监控时暴露 metrics 端口， 供 ServiceMonitor 抓取
+	if redis.MonitoringEnabled() {
+		svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+			Name:       "metrics",
+			Port:       ExporterPort(redis),
+			TargetPort: intstr.FromString("metrics"),
+		})
+	}
+
+	return svc, nil
+}
@@ -0,0 +1,57 @@
+package builder
+
+import (
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// DataMountPath redis 数据目录， RDB 和 AOF 文件写在这里
+	DataMountPath = "/data"
+
+	dataVolume = "data"
+)
+
+// ClaimName 返回 pod 数据目录使用的 PVC 名称
+func ClaimName(podName string) string {
+	return dataVolume + "-" + podName
+}
+
+// PersistentVolumeClaim 生成 pod 数据目录使用的 PVC。
+// 不设置 OwnerReference， 删除 redis 后 PVC 和其中的数据保留。
+func PersistentVolumeClaim(redis *appv1.Redis, podName string) *corev1.PersistentVolumeClaim {
+	storage := redis.Spec.Storage
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	pvc.Name = ClaimName(podName)
+	pvc.Namespace = redis.Namespace
+	pvc.Labels = Labels(redis)
+	pvc.Spec = corev1.PersistentVolumeClaimSpec{
+		AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+		StorageClassName: storage.StorageClassName,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceStorage: storage.Size,
+			},
+		},
+	}
+	return pvc
+}
+
+func dataVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      dataVolume,
+		MountPath: DataMountPath,
+	}
+}
+
+func dataPodVolume(podName string) corev1.Volume {
+	return corev1.Volume{
+		Name: dataVolume,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: ClaimName(podName),
+			},
+		},
+	}
+}
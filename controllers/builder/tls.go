@@ -0,0 +1,133 @@
+package builder
+
+import (
+	"fmt"
+	"net"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// CertificateGVK cert-manager 的 Certificate 类型， 与 ServiceMonitor 一样使用 unstructured 操作
+var CertificateGVK = schema.GroupVersionKind{
+	Group:   "cert-manager.io",
+	Version: "v1",
+	Kind:    "Certificate",
+}
+
+const (
+	// TLSMountPath 证书在容器中的挂载目录， 文件名与 kubernetes.io/tls secret 的 key 相同
+	TLSMountPath = "/tls"
+
+	// TLSCAKey secret 中 CA 证书的 key， 与 cert-manager 相同
+	TLSCAKey = "ca.crt"
+
+	tlsVolume = "tls"
+)
+
+// TLSSecretName 返回保存 redis 证书的 secret 名称
+func TLSSecretName(redis *appv1.Redis) string {
+	return redis.Name + "-tls"
+}
+
+// TLSHosts 返回证书需要包含的域名和 IP。
+// operator 连接 pod IP 时使用 service 域名校验证书， exporter 通过 localhost 连接。
+func TLSHosts(redis *appv1.Redis) []string {
+	svc := fmt.Sprintf("%s.%s.svc", redis.Name, redis.Namespace)
+	return []string{
+		svc,
+		redis.Name,
+		fmt.Sprintf("%s.%s", redis.Name, redis.Namespace),
+		svc + ".cluster.local",
+		"localhost",
+		"127.0.0.1",
+	}
+}
+
+// Certificate 生成使用 spec.tls.issuerRef 签发 redis 证书的 Certificate
+func Certificate(redis *appv1.Redis, scheme *runtime.Scheme) (*unstructured.Unstructured, error) {
+
+	cert := &unstructured.Unstructured{}
+	cert.SetGroupVersionKind(CertificateGVK)
+	cert.SetName(redis.Name)
+	cert.SetNamespace(redis.Namespace)
+	cert.SetLabels(SelectorLabels(redis))
+
+	if err := controllerutil.SetOwnerReference(redis, cert, scheme); err != nil {
+		return nil, err
+	}
+
+	var dnsNames, ipAddresses []interface{}
+	for _, host := range TLSHosts(redis) {
+		if net.ParseIP(host) != nil {
+			ipAddresses = append(ipAddresses, host)
+		} else {
+			dnsNames = append(dnsNames, host)
+		}
+	}
+
+	ref := redis.Spec.TLS.IssuerRef
+	issuerRef := map[string]interface{}{
+		"name":  ref.Name,
+		"kind":  ref.Kind,
+		"group": ref.Group,
+	}
+	if ref.Kind == "" {
+		issuerRef["kind"] = "Issuer"
+	}
+	if ref.Group == "" {
+		issuerRef["group"] = CertificateGVK.Group
+	}
+
+	spec := map[string]interface{}{
+		"secretName":  TLSSecretName(redis),
+		"commonName":  dnsNames[0],
+		"dnsNames":    dnsNames,
+		"ipAddresses": ipAddresses,
+		"issuerRef":   issuerRef,
+		// 主从复制时 redis 同时作为客户端
+		"usages": []interface{}{"server auth", "client auth"},
+	}
+	if err := unstructured.SetNestedField(cert.Object, spec, "spec"); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// tlsArgs 返回开启 TLS 的 redis-server 参数， 关闭明文端口， 在 spec.port 上提供 TLS 服务
+func tlsArgs(redis *appv1.Redis) []string {
+	return []string{
+		"--port", "0",
+		"--tls-port", fmt.Sprintf("%d", redis.Spec.Port),
+		"--tls-cert-file", TLSMountPath + "/" + corev1.TLSCertKey,
+		"--tls-key-file", TLSMountPath + "/" + corev1.TLSPrivateKeyKey,
+		"--tls-ca-cert-file", TLSMountPath + "/" + TLSCAKey,
+		"--tls-replication", "yes",
+		// 客户端仍然通过密码认证， 不强制要求客户端证书
+		"--tls-auth-clients", "optional",
+	}
+}
+
+func tlsVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      tlsVolume,
+		MountPath: TLSMountPath,
+		ReadOnly:  true,
+	}
+}
+
+func tlsPodVolume(redis *appv1.Redis) corev1.Volume {
+	return corev1.Volume{
+		Name: tlsVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: TLSSecretName(redis),
+			},
+		},
+	}
+}
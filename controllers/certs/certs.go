@@ -0,0 +1,161 @@
+// Package certs 实现 operator 自己的 CA， 为没有使用 cert-manager 的 redis 签发 TLS 证书。
+// 证书和私钥都使用 PEM 编码， 与 kubernetes.io/tls 类型的 secret 保持一致。
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+const (
+	// CAValidity operator CA 的有效期
+	CAValidity = 10 * 365 * 24 * time.Hour
+	// CertValidity redis 证书的有效期
+	CertValidity = 90 * 24 * time.Hour
+)
+
+// KeyPair PEM 编码的证书和私钥
+type KeyPair struct {
+	Cert []byte
+	Key  []byte
+}
+
+// NewCA 生成自签名的 CA
+func NewCA(commonName string, now time.Time) (*KeyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template(commonName, now, CAValidity)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("生成 CA 失败: %w", err)
+	}
+	return encode(der, key)
+}
+
+// Issue 使用 ca 签发证书， hosts 中的 IP 写入 IPAddresses， 其余写入 DNSNames
+func Issue(ca *KeyPair, hosts []string, now time.Time) (*KeyPair, error) {
+	caCert, err := ParseCert(ca.Cert)
+	if err != nil {
+		return nil, err
+	}
+	caKey, err := parseKey(ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("证书至少需要一个域名")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template(hosts[0], now, CertValidity)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	// redis 之间的主从复制同时使用服务端和客户端证书
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, fmt.Errorf("签发证书失败: %w", err)
+	}
+	return encode(der, key)
+}
+
+// NeedsRenewal 判断证书是否需要更新： 剩余有效期不足三分之一， 或者不包含全部 hosts
+func NeedsRenewal(certPEM []byte, hosts []string, now time.Time) bool {
+	cert, err := ParseCert(certPEM)
+	if err != nil {
+		return true
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	if cert.NotAfter.Sub(now) < lifetime/3 {
+		return true
+	}
+	for _, host := range hosts {
+		if cert.VerifyHostname(host) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCert 解析 PEM 编码的第一个证书
+func ParseCert(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("没有找到 PEM 编码的证书")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// Hash 返回证书的 sha256， 用于判断证书是否更新
+func Hash(certPEM []byte) string {
+	sum := sha256.Sum256(certPEM)
+	return hex.EncodeToString(sum[:])
+}
+
+func template(commonName string, now time.Time, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		// 容忍节点之间的时钟偏差
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(validity),
+	}, nil
+}
+
+func encode(der []byte, key *ecdsa.PrivateKey) (*KeyPair, error) {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{
+		Cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Key:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+func parseKey(keyPEM []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("没有找到 PEM 编码的私钥")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
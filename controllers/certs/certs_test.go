@@ -0,0 +1,50 @@
+package certs
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestIssue(t *testing.T) {
+	now := time.Now()
+	ca, err := NewCA("redis-operator", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := []string{"cache.default.svc", "localhost", "127.0.0.1"}
+	pair, err := Issue(ca, hosts, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCert, err := ParseCert(ca.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ParseCert(pair.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	for _, host := range hosts {
+		opts := x509.VerifyOptions{DNSName: host, Roots: roots, CurrentTime: now}
+		if _, err := cert.Verify(opts); err != nil {
+			t.Errorf("verify %s: %v", host, err)
+		}
+	}
+
+	if NeedsRenewal(pair.Cert, hosts, now) {
+		t.Error("new certificate must not need renewal")
+	}
+	if !NeedsRenewal(pair.Cert, hosts, now.Add(CertValidity*3/4)) {
+		t.Error("certificate must be renewed in the last third of its lifetime")
+	}
+	if !NeedsRenewal(pair.Cert, append(hosts, "cache.other.svc"), now) {
+		t.Error("certificate must be renewed when a host is missing")
+	}
+	if Hash(pair.Cert) == Hash(ca.Cert) {
+		t.Error("hash must differ between certificates")
+	}
+}
@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+// setCondition 设置 conditions 中 conditionType 对应的 condition， 状态不变时保留原来的 LastTransitionTime
+func setCondition(conditions *[]metav1.Condition, generation int64, conditionType string, status bool, reason, message string) {
+	cond := metav1.Condition{
+		Type:               conditionType,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	}
+	if status {
+		cond.Status = metav1.ConditionTrue
+	}
+	meta.SetStatusCondition(conditions, cond)
+}
+
+// setRedisCondition 设置 redis 的 condition
+func setRedisCondition(redis *myappv1.Redis, conditionType string, status bool, reason, message string) {
+	setCondition(&redis.Status.Conditions, redis.Generation, conditionType, status, reason, message)
+}
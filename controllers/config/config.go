@@ -0,0 +1,258 @@
+// Package config 读取并校验 operator 配置文件
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/yaml"
+
+	configv1alpha1 "github.com/tangx/k8s-operator-demo/api/config/v1alpha1"
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/events"
+	"github.com/tangx/k8s-operator-demo/controllers/shard"
+)
+
+// Load 读取配置文件， 不认识的字段视为错误。
+// path 为空时返回只包含默认值的配置。
+func Load(path string) (*configv1alpha1.OperatorConfig, error) {
+	c := &configv1alpha1.OperatorConfig{}
+	if path == "" {
+		return c, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("解析配置文件 %s 失败: %w", path, err)
+	}
+
+	gvk := c.GroupVersionKind()
+	if gvk.GroupVersion() != configv1alpha1.GroupVersion || gvk.Kind != "OperatorConfig" {
+		return nil, fmt.Errorf("配置文件 %s 不是 %s OperatorConfig: %s", path, configv1alpha1.GroupVersion, gvk)
+	}
+	return c, nil
+}
+
+// Defaults 返回配置中影响 redis 默认值、 校验和版本解析的部分
+func Defaults(c *configv1alpha1.OperatorConfig) myappv1.OperatorDefaults {
+	return myappv1.OperatorDefaults{
+		Image:    c.Operator.DefaultImage,
+		Versions: myappv1.VersionCatalog(c.Operator.Versions),
+	}
+}
+
+// Validate 校验补全默认值之后的配置
+func Validate(c *configv1alpha1.OperatorConfig) error {
+	var errs field.ErrorList
+
+	errs = append(errs, validateAddress(field.NewPath("metrics", "bindAddress"), c.Metrics.BindAddress)...)
+	errs = append(errs, validateAddress(field.NewPath("health", "healthProbeBindAddress"), c.Health.HealthProbeBindAddress)...)
+
+	if port := c.Webhook.Port; port != nil && (*port < 1 || *port > 65535) {
+		errs = append(errs, field.Invalid(field.NewPath("webhook", "port"), *port, "must be between 1 and 65535"))
+	}
+
+	if le := c.LeaderElection; le != nil && le.LeaderElect != nil && *le.LeaderElect {
+		for _, msg := range validation.IsDNS1123Subdomain(le.ResourceName) {
+			errs = append(errs, field.Invalid(field.NewPath("leaderElection", "resourceName"), le.ResourceName, msg))
+		}
+	}
+
+	op := field.NewPath("operator")
+	if c.Operator.DefaultImage == "" {
+		errs = append(errs, field.Required(op.Child("defaultImage"), ""))
+	}
+	errs = append(errs, validateVersions(op.Child("versions"), c.Operator.Versions)...)
+	if c.Operator.MaxConcurrentReconciles < 1 {
+		errs = append(errs, field.Invalid(op.Child("maxConcurrentReconciles"), c.Operator.MaxConcurrentReconciles, "must be at least 1"))
+	}
+	errs = append(errs, validateRateLimiter(op.Child("rateLimiter"), &c.Operator.RateLimiter)...)
+	if !contains(events.Languages(), c.Operator.EventLanguage) {
+		errs = append(errs, field.NotSupported(op.Child("eventLanguage"), c.Operator.EventLanguage, events.Languages()))
+	}
+
+	errs = append(errs, validatePolicy(op.Child("policy"), &c.Operator.Policy)...)
+
+	if _, err := shard.Parse(c.Operator.ShardSelector); err != nil {
+		errs = append(errs, field.Invalid(op.Child("shardSelector"), c.Operator.ShardSelector, err.Error()))
+	}
+	for i, selector := range c.Operator.Shards {
+		if _, err := shard.Parse(selector); err != nil {
+			errs = append(errs, field.Invalid(op.Child("shards").Index(i), selector, err.Error()))
+		}
+	}
+
+	seen := map[string]bool{}
+	for i, ns := range c.Operator.WatchNamespaces {
+		path := op.Child("watchNamespaces").Index(i)
+		for _, msg := range validation.IsDNS1123Label(ns) {
+			errs = append(errs, field.Invalid(path, ns, msg))
+		}
+		if seen[ns] {
+			errs = append(errs, field.Duplicate(path, ns))
+		}
+		seen[ns] = true
+	}
+
+	return errs.ToAggregate()
+}
+
+func validatePolicy(path *field.Path, p *configv1alpha1.PolicySpec) field.ErrorList {
+	var errs field.ErrorList
+
+	supported := false
+	var policies []string
+	for _, policy := range configv1alpha1.OrphanPolicies {
+		supported = supported || p.OrphanPods == policy
+		policies = append(policies, string(policy))
+	}
+	if !supported {
+		errs = append(errs, field.NotSupported(path.Child("orphanPods"), p.OrphanPods, policies))
+	}
+	if p.OrphanSweepInterval.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("orphanSweepInterval"), p.OrphanSweepInterval.Duration.String(), "must be positive"))
+	}
+	return errs
+}
+
+func validateVersions(path *field.Path, versions []myappv1.RedisVersion) field.ErrorList {
+	var errs field.ErrorList
+
+	seen := map[string]bool{}
+	for i, v := range versions {
+		p := path.Index(i)
+		if v.Version == "" {
+			errs = append(errs, field.Required(p.Child("version"), ""))
+		} else if seen[v.Version] {
+			errs = append(errs, field.Duplicate(p.Child("version"), v.Version))
+		}
+		seen[v.Version] = true
+		if v.Image == "" {
+			errs = append(errs, field.Required(p.Child("image"), ""))
+		}
+		if v.RDBVersion < 1 {
+			errs = append(errs, field.Invalid(p.Child("rdbVersion"), v.RDBVersion, "must be at least 1"))
+		}
+	}
+	return errs
+}
+
+func validateRateLimiter(path *field.Path, rl *configv1alpha1.RateLimiterSpec) field.ErrorList {
+	var errs field.ErrorList
+
+	if rl.BaseDelay.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("baseDelay"), rl.BaseDelay.Duration.String(), "must be positive"))
+	}
+	if rl.MaxDelay.Duration < rl.BaseDelay.Duration {
+		errs = append(errs, field.Invalid(path.Child("maxDelay"), rl.MaxDelay.Duration.String(), "must not be less than baseDelay"))
+	}
+	if rl.QPS < 1 {
+		errs = append(errs, field.Invalid(path.Child("qps"), rl.QPS, "must be at least 1"))
+	}
+	if rl.Burst < 1 {
+		errs = append(errs, field.Invalid(path.Child("burst"), rl.Burst, "must be at least 1"))
+	}
+	if rl.RequeueBudget < 0 {
+		errs = append(errs, field.Invalid(path.Child("requeueBudget"), rl.RequeueBudget, "must not be negative"))
+	}
+	if rl.BudgetBackoff.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("budgetBackoff"), rl.BudgetBackoff.Duration.String(), "must be positive"))
+	}
+	return errs
+}
+
+// validateAddress 校验监听地址， "0" 表示关闭
+func validateAddress(path *field.Path, addr string) field.ErrorList {
+	if addr == "0" {
+		return nil
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return field.ErrorList{field.Invalid(path, addr, err.Error())}
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return field.ErrorList{field.Invalid(path, addr, "invalid port")}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ManagerOptions 根据配置生成 manager 参数
+func ManagerOptions(c *configv1alpha1.OperatorConfig, options ctrl.Options) (ctrl.Options, error) {
+	options, err := options.AndFrom(c)
+	if err != nil {
+		return options, err
+	}
+
+	selector, err := shard.Parse(c.Operator.ShardSelector)
+	if err != nil {
+		return options, err
+	}
+	options.LeaderElectionID = shard.LeaderElectionID(options.LeaderElectionID, selector)
+	options.NewCache = newCache(c.Operator.WatchNamespaces, shard.CacheSelectors(selector))
+
+	return options, nil
+}
+
+// newCache 按命名空间和分片选择器过滤 cache。
+// manager 只支持单个命名空间， 多个命名空间时使用 MultiNamespacedCache。
+func newCache(namespaces []string, selectors cache.SelectorsByObject) cache.NewCacheFunc {
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		opts.SelectorsByObject = selectors
+
+		switch len(namespaces) {
+		case 0:
+			return cache.New(config, opts)
+		case 1:
+			opts.Namespace = namespaces[0]
+			return cache.New(config, opts)
+		default:
+			return cache.MultiNamespacedCacheBuilder(namespaces)(config, opts)
+		}
+	}
+}
+
+// ShardSelectors 返回用于检查 redis 是否属于某个分片的选择器
+func ShardSelectors(c *configv1alpha1.OperatorConfig) ([]labels.Selector, error) {
+	own, err := shard.Parse(c.Operator.ShardSelector)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Operator.Shards) == 0 {
+		keys, err := shard.KeySelector(own)
+		if err != nil {
+			return nil, err
+		}
+		return []labels.Selector{keys}, nil
+	}
+
+	selectors := []labels.Selector{own}
+	for _, s := range c.Operator.Shards {
+		sel, err := shard.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, sel)
+	}
+	return selectors, nil
+}
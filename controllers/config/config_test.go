@@ -0,0 +1,133 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	configv1alpha1 "github.com/tangx/k8s-operator-demo/api/config/v1alpha1"
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+// 仓库中的配置文件必须可以加载并通过校验
+func TestLoadManagerConfig(t *testing.T) {
+	c, err := Load(filepath.Join("..", "..", "config", "manager", "controller_manager_config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Default()
+	if err := Validate(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Metrics.BindAddress != "127.0.0.1:8080" || *c.Webhook.Port != 9443 || !*c.LeaderElection.LeaderElect {
+		t.Fatalf("manager options not loaded: %+v", c.ControllerManagerConfigurationSpec)
+	}
+	if c.Operator.DefaultImage != "redis:5-alpine" || c.Operator.MaxConcurrentReconciles != 1 {
+		t.Fatalf("operator options not loaded: %+v", c.Operator)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	c, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Default()
+	if err := Validate(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.LeaderElection.ResourceName != configv1alpha1.DefaultLeaderElectionID {
+		t.Fatalf("leader election id = %q", c.LeaderElection.ResourceName)
+	}
+	if len(c.Operator.Versions) != len(myappv1.DefaultVersionCatalog) {
+		t.Fatalf("versions = %v, want the built-in catalog", c.Operator.Versions)
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	path := writeConfig(t, `
+apiVersion: config.tangx.in/v1alpha1
+kind: OperatorConfig
+operator:
+  defaultImag: redis:6
+`)
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected an error for unknown field")
+	}
+}
+
+func TestLoadRejectsWrongKind(t *testing.T) {
+	path := writeConfig(t, `
+apiVersion: controller-runtime.sigs.k8s.io/v1alpha1
+kind: ControllerManagerConfig
+`)
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected an error for wrong kind")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	path := writeConfig(t, `
+apiVersion: config.tangx.in/v1alpha1
+kind: OperatorConfig
+metrics:
+  bindAddress: localhost
+webhook:
+  port: 70000
+operator:
+  watchNamespaces: [team-a, Team_B, team-a]
+  maxConcurrentReconciles: -1
+  eventLanguage: fr
+  shardSelector: "shard in a"
+  rateLimiter:
+    baseDelay: 1s
+    maxDelay: 10ms
+    requeueBudget: -1
+  policy:
+    orphanPods: Keep
+  versions:
+  - {version: "7.0", image: redis:7.0, rdbVersion: 10}
+  - {version: "7.0", rdbVersion: 0}
+`)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Default()
+
+	err = Validate(c)
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, field := range []string{
+		"metrics.bindAddress",
+		"webhook.port",
+		"operator.watchNamespaces[1]",
+		"operator.watchNamespaces[2]",
+		"operator.maxConcurrentReconciles",
+		"operator.eventLanguage",
+		"operator.shardSelector",
+		"operator.rateLimiter.maxDelay",
+		"operator.rateLimiter.requeueBudget",
+		"operator.policy.orphanPods",
+		"operator.versions[1].version",
+		"operator.versions[1].image",
+		"operator.versions[1].rdbVersion",
+	} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error does not mention %s: %v", field, err)
+		}
+	}
+}
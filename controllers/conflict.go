@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/helper2"
+)
+
+// ApplyConflict condition 的 reason
+const (
+	reasonFieldManagerConflict = "FieldManagerConflict"
+	reasonNotOwned             = "NotOwned"
+	reasonApplied              = "Applied"
+)
+
+// setConflictCondition 根据 apply 的结果设置 ApplyConflict condition。
+// 同名对象不属于当前 redis 时同样记录为冲突。
+// 其他错误无法判断是否仍然存在冲突， 保留原来的 condition。
+func setConflictCondition(redis *myappv1.Redis, err error) {
+	switch {
+	case err == nil:
+		setRedisCondition(redis, myappv1.ConditionApplyConflict, false, reasonApplied,
+			"All owned objects are applied by field manager "+helper2.FieldManager)
+	case helper2.IsApplyConflict(err):
+		setRedisCondition(redis, myappv1.ConditionApplyConflict, true, reasonFieldManagerConflict, err.Error())
+	case helper2.IsNotOwned(err):
+		setRedisCondition(redis, myappv1.ConditionApplyConflict, true, reasonNotOwned, err.Error())
+	}
+}
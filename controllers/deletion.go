@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/events"
+	"github.com/tangx/k8s-operator-demo/controllers/helper2"
+)
+
+// deletionPollInterval 等待备份完成或客户端断开时的检查间隔
+const deletionPollInterval = 5 * time.Second
+
+// 删除流程 condition 的 reason
+const (
+	reasonFinalBackup    = "FinalBackup"
+	reasonDrainClients   = "DrainingClients"
+	reasonGracePeriod    = "GracePeriod"
+	reasonDeletingPods   = "DeletingPods"
+	reasonRetainingPods  = "RetainingPods"
+	reasonInProgress     = "InProgress"
+	reasonCompleted      = "Completed"
+	reasonBackupFailed   = "Failed"
+	reasonBackupSkipped  = "NoPersistentStorage"
+	reasonBackupTimeout  = "TimedOut"
+	reasonClientsPending = "ClientsConnected"
+	reasonDrained        = "Drained"
+	reasonDrainTimeout   = "TimedOut"
+)
+
+// deleteReconcile 按 spec.deletionPolicy 依次执行最终备份、 等待客户端断开、 等待宽限期，
+// 最后删除或保留 pod。 每个步骤的进度记录在 status conditions 中， 未完成时定时重新调谐。
+func (r *RedisReconciler) deleteReconcile(ctx context.Context, redis *myappv1.Redis) (ctrl.Result, error) {
+
+	if meta.FindStatusCondition(redis.Status.Conditions, myappv1.ConditionTerminating) == nil {
+		r.EventRecord.Event(redis, events.ReasonDeletionStarted, redis.Name)
+	}
+
+	policy := redis.Spec.DeletionPolicy
+	if policy == nil {
+		policy = &myappv1.DeletionPolicy{}
+	}
+
+	if policy.FinalBackup {
+		done, err := r.finalBackup(ctx, redis, policy.FinalBackupTimeout)
+		if err != nil || !done {
+			return ctrl.Result{RequeueAfter: deletionPollInterval}, err
+		}
+	}
+
+	if policy.DrainClients != nil {
+		if !r.drainClients(ctx, redis, policy.DrainClients) {
+			return ctrl.Result{RequeueAfter: deletionPollInterval}, nil
+		}
+	}
+
+	if policy.GracePeriod != nil {
+		deadline := redis.DeletionTimestamp.Add(policy.GracePeriod.Duration)
+		if remaining := time.Until(deadline); remaining > 0 {
+			setTerminating(redis, reasonGracePeriod, fmt.Sprintf("Waiting until %s before removing pods", deadline.UTC().Format(time.RFC3339)))
+			return ctrl.Result{RequeueAfter: remaining}, nil
+		}
+	}
+
+	if redis.DeletionMode() == myappv1.DeletionModeRetain {
+		setTerminating(redis, reasonRetainingPods, "Removing owner references from pods")
+		retained, err := helper2.RetainRedis2(ctx, r.Client, redis)
+		if len(retained) > 0 {
+			r.EventRecord.Event(redis, events.ReasonPodsRetained, redis.Name, retained)
+		}
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("保留 redis pod 失败: %w", err)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	setTerminating(redis, reasonDeletingPods, "Deleting pods")
+	if err := helper2.DeleteRedis2(ctx, r.Client, redis); err != nil {
+		return ctrl.Result{}, fmt.Errorf("删除 redis 失败: %w", err)
+	}
+	return ctrl.Result{}, nil
+}
+
+// finalBackup 第一次调用时开始备份， 之后检查备份是否完成。 备份失败时重新开始， 超时后不再等待。
+// 没有持久化存储时 RDB 随 pod 一起删除， 不执行备份。 关闭 webhook 时可能出现这种 redis。
+func (r *RedisReconciler) finalBackup(ctx context.Context, redis *myappv1.Redis, timeout *metav1.Duration) (bool, error) {
+	cond := meta.FindStatusCondition(redis.Status.Conditions, myappv1.ConditionFinalBackup)
+	if cond != nil && (cond.Status == metav1.ConditionTrue || cond.Reason == reasonBackupSkipped || cond.Reason == reasonBackupTimeout) {
+		return true, nil
+	}
+
+	if !redis.PersistentStorage() {
+		setRedisCondition(redis, myappv1.ConditionFinalBackup, false, reasonBackupSkipped,
+			"Skipped because spec.storage is not set, the backup would be deleted together with the pods")
+		return true, nil
+	}
+
+	if timeout != nil && time.Now().After(redis.DeletionTimestamp.Add(timeout.Duration)) {
+		message := "BGSAVE did not complete"
+		if cond != nil {
+			message = cond.Message
+		}
+		setRedisCondition(redis, myappv1.ConditionFinalBackup, false, reasonBackupTimeout,
+			fmt.Sprintf("Gave up after %s: %s", timeout.Duration, message))
+		return true, nil
+	}
+
+	setTerminating(redis, reasonFinalBackup, "Waiting for the final backup")
+
+	// condition 的 LastTransitionTime 在失败后重试时不会更新， 单独记录开始时间，
+	// 避免把重试之前的 LASTSAVE 当作最终备份
+	started := redis.Status.FinalBackupStartTime
+	if cond == nil || cond.Reason == reasonBackupFailed || started == nil {
+		now := metav1.Now()
+		redis.Status.FinalBackupStartTime = &now
+		if err := helper2.StartBackup2(ctx, r.Client, r.apiReader(), redis); err != nil {
+			setRedisCondition(redis, myappv1.ConditionFinalBackup, false, reasonBackupFailed, err.Error())
+			return false, fmt.Errorf("开始最终备份失败: %w", err)
+		}
+		setRedisCondition(redis, myappv1.ConditionFinalBackup, false, reasonInProgress, "BGSAVE started on all running pods")
+		return false, nil
+	}
+
+	done, err := helper2.BackupCompleted2(ctx, r.Client, r.apiReader(), redis, started.Time)
+	if err != nil {
+		setRedisCondition(redis, myappv1.ConditionFinalBackup, false, reasonBackupFailed, err.Error())
+		return false, fmt.Errorf("最终备份失败: %w", err)
+	}
+	if !done {
+		return false, nil
+	}
+
+	log.FromContext(ctx).Info("final backup completed")
+	setRedisCondition(redis, myappv1.ConditionFinalBackup, true, reasonCompleted, "BGSAVE completed on all running pods")
+	return true, nil
+}
+
+// drainClients 返回客户端是否已经断开， 超时后不再等待
+func (r *RedisReconciler) drainClients(ctx context.Context, redis *myappv1.Redis, drain *myappv1.DrainClients) bool {
+	if cond := meta.FindStatusCondition(redis.Status.Conditions, myappv1.ConditionClientsDrained); cond != nil &&
+		(cond.Status == metav1.ConditionTrue || cond.Reason == reasonDrainTimeout) {
+		return true
+	}
+
+	setTerminating(redis, reasonDrainClients, fmt.Sprintf("Waiting for client connections to drop to %d", drain.MaxClients))
+
+	clients, err := helper2.CountClients2(ctx, r.Client, r.apiReader(), redis)
+	message := fmt.Sprintf("%d client connections, waiting for at most %d", clients, drain.MaxClients)
+	if err != nil {
+		// 无法统计时继续等待， 直到超时
+		log.FromContext(ctx).Info("unable to count client connections", "error", err.Error())
+		message = fmt.Sprintf("Unable to count client connections: %v", err)
+	} else if clients <= drain.MaxClients {
+		setRedisCondition(redis, myappv1.ConditionClientsDrained, true, reasonDrained,
+			fmt.Sprintf("%d client connections, at most %d allowed", clients, drain.MaxClients))
+		return true
+	}
+
+	if drain.Timeout != nil && time.Now().After(redis.DeletionTimestamp.Add(drain.Timeout.Duration)) {
+		setRedisCondition(redis, myappv1.ConditionClientsDrained, false, reasonDrainTimeout,
+			fmt.Sprintf("Gave up after %s: %s", drain.Timeout.Duration, message))
+		return true
+	}
+
+	setRedisCondition(redis, myappv1.ConditionClientsDrained, false, reasonClientsPending, message)
+	return false
+}
+
+// apiReader 直接读取 apiserver 的 reader， 用于不经过 manager cache 的 secret 和 cache 中查不到的对象
+func (r *RedisReconciler) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// setTerminating 记录删除流程当前所处的步骤
+func setTerminating(redis *myappv1.Redis, reason, message string) {
+	setRedisCondition(redis, myappv1.ConditionTerminating, true, reason, message)
+}
@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/fakeclient"
+	"github.com/tangx/k8s-operator-demo/controllers/helper2"
+	"github.com/tangx/k8s-operator-demo/controllers/resp/resptest"
+)
+
+// 直接调用 helper2 的删除逻辑， 覆盖 pod 已经不存在、 redis 对象过期等异常路径。
+// 这些对象位于 default 命名空间， 不受 manager 中运行的 RedisReconciler 影响。
+var _ = Describe("Redis pod deletion", func() {
+
+	var (
+		ctx   context.Context
+		redis *myappv1.Redis
+	)
+
+	// newRedis 创建带有 pod finalizers 的 redis， 只创建 existing 中指定序号的 pod
+	newRedis := func(name string, replicas int, existing ...int) *myappv1.Redis {
+		r := &myappv1.Redis{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+			},
+			Spec: myappv1.RedisSpec{
+				Replicas: replicas,
+				Port:     6379,
+				Image:    "redis:5-alpine",
+			},
+		}
+		for i := 0; i < replicas; i++ {
+			r.Finalizers = append(r.Finalizers, fmt.Sprintf("%s-%d", name, i))
+		}
+		Expect(k8sClient.Create(ctx, r)).To(Succeed())
+
+		for _, i := range existing {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      fmt.Sprintf("%s-%d", name, i),
+					Namespace: "default",
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "redis", Image: "redis:5-alpine"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		}
+		return r
+	}
+
+	podExists := func(name string) bool {
+		err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, &corev1.Pod{})
+		if apierrors.IsNotFound(err) {
+			return false
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return true
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	Context("when a pod was removed manually", func() {
+		It("finishes deleting the redis", func() {
+			redis = newRedis("deletion-missing", 2, 1)
+			Expect(k8sClient.Delete(ctx, redis)).To(Succeed())
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(redis), redis)).To(Succeed())
+
+			Expect(helper2.DeleteRedis2(ctx, k8sClient, redis)).To(Succeed())
+
+			Expect(podExists("deletion-missing-1")).To(BeFalse())
+			err := k8sClient.Get(ctx, client.ObjectKeyFromObject(redis), &myappv1.Redis{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("finishes scaling down", func() {
+			redis = newRedis("scale-down-missing", 3, 0, 1)
+			redis.Spec.Replicas = 1
+			Expect(k8sClient.Update(ctx, redis)).To(Succeed())
+
+			Expect(helper2.DecreaseRedis2(ctx, k8sClient, redis)).To(Succeed())
+
+			Expect(podExists("scale-down-missing-0")).To(BeTrue())
+			Expect(podExists("scale-down-missing-1")).To(BeFalse())
+
+			latest := &myappv1.Redis{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(redis), latest)).To(Succeed())
+			Expect(latest.Finalizers).To(Equal([]string{"scale-down-missing-0"}))
+		})
+	})
+
+	Context("when the redis was modified concurrently", func() {
+		It("retries the finalizer update with a fresh read", func() {
+			redis = newRedis("deletion-conflict", 2, 0, 1)
+
+			// 其他客户端修改了 redis， 本地对象的 resourceVersion 过期
+			other := &myappv1.Redis{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(redis), other)).To(Succeed())
+			other.Labels = map[string]string{"touched": "true"}
+			Expect(k8sClient.Update(ctx, other)).To(Succeed())
+
+			Expect(helper2.DeleteRedis2(ctx, k8sClient, redis)).To(Succeed())
+
+			latest := &myappv1.Redis{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(redis), latest)).To(Succeed())
+			Expect(latest.Finalizers).To(BeEmpty())
+			Expect(latest.Labels).To(HaveKeyWithValue("touched", "true"))
+		})
+	})
+})
+
+// finalBackup 只连接 pod， 使用 fake client 和模拟的 redis server， 不依赖 manager
+var _ = Describe("finalBackup", func() {
+	It("does not count a save from before the retry as the final backup", func() {
+		ctx := context.Background()
+		lastSave := time.Now().Add(-30 * time.Minute).Unix()
+		server, err := resptest.NewServer(func(args []string) string {
+			switch args[0] {
+			case "BGSAVE":
+				return resptest.Bulk("Background saving started")
+			case "INFO":
+				return resptest.Bulk("# Persistence\r\n" +
+					"rdb_bgsave_in_progress:0\r\n" +
+					"rdb_last_bgsave_status:ok\r\n" +
+					"rdb_last_save_time:" + strconv.FormatInt(atomic.LoadInt64(&lastSave), 10) + "\r\n")
+			}
+			return resptest.Err("ERR unknown command")
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer server.Close()
+
+		// 一小时前的备份失败， LASTSAVE 在失败之后、 重试之前
+		failed := metav1.NewTime(time.Now().Add(-time.Hour))
+		redis := &myappv1.Redis{
+			ObjectMeta: metav1.ObjectMeta{Name: "backup-retry", Namespace: "default", UID: "backup-retry-uid"},
+			Spec: myappv1.RedisSpec{
+				Replicas: 1,
+				Port:     server.Port(),
+				Storage:  &myappv1.RedisStorage{Size: resource.MustParse("1Gi")},
+			},
+			Status: myappv1.RedisStatus{
+				FinalBackupStartTime: &failed,
+				Conditions: []metav1.Condition{{
+					Type:               myappv1.ConditionFinalBackup,
+					Status:             metav1.ConditionFalse,
+					Reason:             reasonBackupFailed,
+					LastTransitionTime: failed,
+				}},
+			},
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "backup-retry-0",
+				Namespace: "default",
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: myappv1.GroupVersion.String(),
+					Kind:       "Redis",
+					Name:       redis.Name,
+					UID:        redis.UID,
+				}},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "127.0.0.1"},
+		}
+		c := fakeclient.New(redis, pod)
+		Expect(helper2.SetupIndexes(ctx, c)).To(Succeed())
+		r := &RedisReconciler{Client: c, Scheme: c.Scheme()}
+
+		done, err := r.finalBackup(ctx, redis, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(done).To(BeFalse())
+		Expect(redis.Status.FinalBackupStartTime.After(failed.Time)).To(BeTrue())
+
+		done, err = r.finalBackup(ctx, redis, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(done).To(BeFalse())
+
+		atomic.StoreInt64(&lastSave, time.Now().Add(time.Second).Unix())
+		done, err = r.finalBackup(ctx, redis, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(done).To(BeTrue())
+	})
+})
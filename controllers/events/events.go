@@ -0,0 +1,141 @@
+package events
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// 事件 reason， 使用固定的 CamelCase 字符串， 便于 kubectl 和告警规则匹配
+const (
+	ReasonScalingUp       = "ScalingUp"
+	ReasonScalingDown     = "ScalingDown"
+	ReasonPodRecreated    = "PodRecreated"
+	ReasonDeletionStarted = "DeletionStarted"
+	ReasonReconcileFailed = "ReconcileFailed"
+	ReasonPaused          = "Paused"
+	ReasonResumed         = "Resumed"
+	ReasonPodAdopted      = "PodAdopted"
+	ReasonOrphanDeleted   = "OrphanDeleted"
+	ReasonPodsRetained    = "PodsRetained"
+	ReasonCertRenewed     = "CertificateRenewed"
+	ReasonPodRotated      = "PodRotated"
+	ReasonCertReloaded    = "CertificateReloaded"
+	ReasonPodUpgraded     = "PodUpgraded"
+	ReasonUpgraded        = "Upgraded"
+)
+
+// 支持的事件消息语言
+const (
+	LanguageEnglish = "en"
+	LanguageChinese = "zh"
+)
+
+// eventTypes 每个 reason 对应的事件类型， 只允许 Normal 和 Warning
+var eventTypes = map[string]string{
+	ReasonScalingUp:       corev1.EventTypeNormal,
+	ReasonScalingDown:     corev1.EventTypeWarning,
+	ReasonPodRecreated:    corev1.EventTypeWarning,
+	ReasonDeletionStarted: corev1.EventTypeNormal,
+	ReasonReconcileFailed: corev1.EventTypeWarning,
+	ReasonPaused:          corev1.EventTypeWarning,
+	ReasonResumed:         corev1.EventTypeNormal,
+	ReasonPodAdopted:      corev1.EventTypeNormal,
+	ReasonOrphanDeleted:   corev1.EventTypeWarning,
+	ReasonPodsRetained:    corev1.EventTypeWarning,
+	ReasonCertRenewed:     corev1.EventTypeNormal,
+	ReasonPodRotated:      corev1.EventTypeNormal,
+	ReasonCertReloaded:    corev1.EventTypeNormal,
+	ReasonPodUpgraded:     corev1.EventTypeNormal,
+	ReasonUpgraded:        corev1.EventTypeNormal,
+}
+
+// catalog 各语言的消息模板， 参数顺序在所有语言中保持一致
+var catalog = map[string]map[string]string{
+	LanguageEnglish: {
+		ReasonScalingUp:       "Scaling %s up to %d replicas",
+		ReasonScalingDown:     "Scaling %s down to %d replicas",
+		ReasonPodRecreated:    "Recreated missing pod %s",
+		ReasonDeletionStarted: "Deleting %s and its pods",
+		ReasonReconcileFailed: "Reconcile failed: %v",
+		ReasonPaused:          "Reconciliation of %s paused by annotation %s",
+		ReasonResumed:         "Reconciliation of %s resumed",
+		ReasonPodAdopted:      "Adopted pod %s previously owned by %s",
+		ReasonOrphanDeleted:   "Deleted orphaned pod %s: %s",
+		ReasonPodsRetained:    "Deleting %s and retaining pods %v",
+		ReasonCertRenewed:     "Certificate in secret %s renewed, expires at %s",
+		ReasonPodRotated:      "Recreating pod %s to apply the TLS change",
+		ReasonCertReloaded:    "Pod %s reloaded the renewed certificate",
+		ReasonPodUpgraded:     "Recreating pod %s with image %s",
+		ReasonUpgraded:        "All pods upgraded to version %s",
+	},
+	LanguageChinese: {
+		ReasonScalingUp:       "%s 副本数设置为 %d",
+		ReasonScalingDown:     "%s 副本数设置为 %d",
+		ReasonPodRecreated:    "重建被删除的 pod %s",
+		ReasonDeletionStarted: "删除 %s",
+		ReasonReconcileFailed: "调谐失败: %v",
+		ReasonPaused:          "%s 已通过注解 %s 暂停调谐",
+		ReasonResumed:         "%s 恢复调谐",
+		ReasonPodAdopted:      "收养 pod %s， 原所属 %s",
+		ReasonOrphanDeleted:   "删除孤儿 pod %s: %s",
+		ReasonPodsRetained:    "删除 %s， 保留 pod %v",
+		ReasonCertRenewed:     "secret %s 中的证书已更新， 过期时间 %s",
+		ReasonPodRotated:      "重建 pod %s 以应用 TLS 变化",
+		ReasonCertReloaded:    "pod %s 已重新加载新证书",
+		ReasonPodUpgraded:     "重建 pod %s， 使用镜像 %s",
+		ReasonUpgraded:        "全部 pod 已升级到版本 %s",
+	},
+}
+
+// Languages 返回支持的语言列表
+func Languages() []string {
+	langs := make([]string, 0, len(catalog))
+	for lang := range catalog {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Recorder 按 reason 记录事件， 事件类型和消息模板由 reason 决定
+type Recorder struct {
+	recorder  record.EventRecorder
+	templates map[string]string
+}
+
+// NewRecorder 创建指定语言的事件记录器
+func NewRecorder(recorder record.EventRecorder, language string) (*Recorder, error) {
+	templates, ok := catalog[language]
+	if !ok {
+		return nil, fmt.Errorf("不支持的事件语言 %q, 可选值: %v", language, Languages())
+	}
+
+	return &Recorder{
+		recorder:  recorder,
+		templates: templates,
+	}, nil
+}
+
+// Event 记录一个事件， args 按照 reason 的消息模板格式化
+func (r *Recorder) Event(obj runtime.Object, reason string, args ...interface{}) {
+	eventType, ok := eventTypes[reason]
+	if !ok {
+		// 未登记的 reason 属于代码错误， 以 Warning 记录， 避免丢失事件
+		eventType = corev1.EventTypeWarning
+	}
+
+	r.recorder.Event(obj, eventType, reason, r.Message(reason, args...))
+}
+
+// Message 返回格式化后的事件消息
+func (r *Recorder) Message(reason string, args ...interface{}) string {
+	template, ok := r.templates[reason]
+	if !ok {
+		return fmt.Sprint(args...)
+	}
+	return fmt.Sprintf(template, args...)
+}
@@ -0,0 +1,70 @@
+package events
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+// 每种语言都必须覆盖全部 reason， 避免切换语言后事件消息缺失
+func TestCatalogComplete(t *testing.T) {
+	for lang, templates := range catalog {
+		for reason := range eventTypes {
+			if _, ok := templates[reason]; !ok {
+				t.Errorf("language %s: missing template for reason %s", lang, reason)
+			}
+		}
+		for reason := range templates {
+			if _, ok := eventTypes[reason]; !ok {
+				t.Errorf("language %s: template for unknown reason %s", lang, reason)
+			}
+		}
+	}
+}
+
+func TestEventTypesAreStandard(t *testing.T) {
+	for reason, eventType := range eventTypes {
+		if eventType != corev1.EventTypeNormal && eventType != corev1.EventTypeWarning {
+			t.Errorf("reason %s: invalid event type %q", reason, eventType)
+		}
+	}
+}
+
+func TestNewRecorderRejectsUnknownLanguage(t *testing.T) {
+	if _, err := NewRecorder(record.NewFakeRecorder(1), "fr"); err == nil {
+		t.Fatal("expected error for unsupported language")
+	}
+}
+
+func TestRecorderEvent(t *testing.T) {
+	cases := []struct {
+		lang   string
+		reason string
+		args   []interface{}
+		want   string
+	}{
+		{LanguageEnglish, ReasonScalingUp, []interface{}{"cache", 3}, "Normal ScalingUp Scaling cache up to 3 replicas"},
+		{LanguageChinese, ReasonScalingDown, []interface{}{"cache", 1}, "Warning ScalingDown cache 副本数设置为 1"},
+		{LanguageEnglish, ReasonReconcileFailed, []interface{}{errors.New("boom")}, "Warning ReconcileFailed Reconcile failed: boom"},
+		{LanguageChinese, ReasonDeletionStarted, []interface{}{"cache"}, "Normal DeletionStarted 删除 cache"},
+	}
+
+	for _, c := range cases {
+		fake := record.NewFakeRecorder(1)
+		recorder, err := NewRecorder(fake, c.lang)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder.Event(&myappv1.Redis{}, c.reason, c.args...)
+
+		got := <-fake.Events
+		if got != c.want {
+			t.Errorf("%s/%s: got %q, want %q", c.lang, c.reason, got, c.want)
+		}
+	}
+}
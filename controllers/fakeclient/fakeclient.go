@@ -0,0 +1,282 @@
+// Package fakeclient 基于 controller-runtime fake client 的测试工具。
+// 可以让第 N 次 Create/Update/Delete/Patch/Apply 调用返回指定错误， 用于确定性地测试部分失败场景，
+// 例如 pod 已经创建但是 redis finalizer 更新失败。
+// 同时实现了 client.FieldIndexer， List 时像 manager cache 一样按注册的索引过滤 MatchingFields。
+// controller-runtime 的 fake client 不支持 server-side apply， 这里把 apply 近似为创建或整体更新，
+// 只按 uid 合并 ownerReferences， 不记录字段归属， 字段冲突需要通过 FailOn(Apply, ...) 注入。
+package fakeclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+// Verb 被拦截的客户端方法
+type Verb string
+
+const (
+	Get    Verb = "get"
+	Create Verb = "create"
+	Update Verb = "update"
+	Delete Verb = "delete"
+	Patch  Verb = "patch"
+	List   Verb = "list"
+	// Apply 类型为 server-side apply 的 Patch， 单独计数
+	Apply Verb = "apply"
+)
+
+// Scheme 注册了 client-go 内置类型和 redis 类型的 scheme
+func Scheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(myappv1.AddToScheme(scheme))
+	return scheme
+}
+
+// Client 可注入错误的 fake client
+type Client struct {
+	client.Client
+
+	mu      sync.Mutex
+	calls   map[Verb]int
+	faults  map[Verb]map[int]error
+	indexes map[indexKey]client.IndexerFunc
+}
+
+// indexKey 索引按对象类型和字段名区分
+type indexKey struct {
+	gvk   schema.GroupVersionKind
+	field string
+}
+
+// New 创建包含 objs 的 fake client
+func New(objs ...client.Object) *Client {
+	return Wrap(fake.NewClientBuilder().
+		WithScheme(Scheme()).
+		WithObjects(objs...).
+		Build())
+}
+
+// Wrap 包装已有的 client
+func Wrap(c client.Client) *Client {
+	return &Client{
+		Client:  c,
+		calls:   map[Verb]int{},
+		faults:  map[Verb]map[int]error{},
+		indexes: map[indexKey]client.IndexerFunc{},
+	}
+}
+
+// FailOn 让第 n 次 (从 1 开始计数) verb 调用返回 err， 调用不会到达底层 client
+func (c *Client) FailOn(verb Verb, n int, err error) *Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.faults[verb] == nil {
+		c.faults[verb] = map[int]error{}
+	}
+	c.faults[verb][n] = err
+	return c
+}
+
+// Calls 返回 verb 被调用的次数， 包括注入失败的调用
+func (c *Client) Calls(verb Verb) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.calls[verb]
+}
+
+// Reset 清空调用计数和注入的错误
+func (c *Client) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls = map[Verb]int{}
+	c.faults = map[Verb]map[int]error{}
+}
+
+// intercept 记录一次调用， 返回需要注入的错误
+func (c *Client) intercept(verb Verb) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls[verb]++
+	return c.faults[verb][c.calls[verb]]
+}
+
+func (c *Client) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if err := c.intercept(Get); err != nil {
+		return err
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c *Client) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.intercept(Create); err != nil {
+		return err
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *Client) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := c.intercept(Update); err != nil {
+		return err
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *Client) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := c.intercept(Delete); err != nil {
+		return err
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *Client) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() == types.ApplyPatchType {
+		if err := c.intercept(Apply); err != nil {
+			return err
+		}
+		return c.apply(ctx, obj)
+	}
+
+	if err := c.intercept(Patch); err != nil {
+		return err
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+// apply 对象不存在时创建， 存在时整体更新为 obj
+func (c *Client) apply(ctx context.Context, obj client.Object) error {
+	existing, ok := obj.DeepCopyObject().(client.Object)
+	if !ok {
+		return fmt.Errorf("%T is not a client.Object", obj)
+	}
+
+	err := c.Client.Get(ctx, client.ObjectKeyFromObject(obj), existing)
+	if apierrors.IsNotFound(err) {
+		return c.Client.Create(ctx, obj)
+	}
+	if err != nil {
+		return err
+	}
+
+	// 与 server-side apply 一致， ownerReferences 按 uid 合并， 保留其他 owner
+	owners := obj.GetOwnerReferences()
+	for _, owner := range existing.GetOwnerReferences() {
+		if !hasOwner(owners, owner.UID) {
+			owners = append(owners, owner)
+		}
+	}
+	obj.SetOwnerReferences(owners)
+
+	obj.SetResourceVersion(existing.GetResourceVersion())
+	return c.Client.Update(ctx, obj)
+}
+
+func hasOwner(owners []metav1.OwnerReference, uid types.UID) bool {
+	for _, owner := range owners {
+		if owner.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if err := c.intercept(List); err != nil {
+		return err
+	}
+
+	// fake client 忽略 field selector， 按索引自行过滤
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	fields := listOpts.FieldSelector
+	listOpts.FieldSelector = nil
+
+	if err := c.Client.List(ctx, list, listOpts); err != nil {
+		return err
+	}
+	if fields == nil || fields.Empty() {
+		return nil
+	}
+
+	gvk, err := apiutil.GVKForObject(list, c.Scheme())
+	if err != nil {
+		return err
+	}
+	gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+
+	var kept []runtime.Object
+	for _, item := range items {
+		ok, err := c.matchesFields(gvk, item.(client.Object), fields)
+		if err != nil {
+			return err
+		}
+		if ok {
+			kept = append(kept, item)
+		}
+	}
+	return meta.SetList(list, kept)
+}
+
+// IndexField 实现 client.FieldIndexer
+func (c *Client) IndexField(ctx context.Context, obj client.Object, field string, extractValue client.IndexerFunc) error {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.indexes[indexKey{gvk: gvk, field: field}] = extractValue
+	return nil
+}
+
+func (c *Client) matchesFields(gvk schema.GroupVersionKind, obj client.Object, selector fields.Selector) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, req := range selector.Requirements() {
+		extract, ok := c.indexes[indexKey{gvk: gvk, field: req.Field}]
+		if !ok {
+			return false, fmt.Errorf("index with name field:%s does not exist", req.Field)
+		}
+
+		found := false
+		for _, value := range extract(obj) {
+			if value == req.Value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -10,6 +10,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 // CreateRedis 创建 redis pod
@@ -50,7 +51,7 @@ func CreateRedisPod2(ctx context.Context, client client.Client, redis *appv1.Red
 	isUpdated := false
 	for i := 0; i < redis.Spec.Replicas; i++ {
 		name := fmt.Sprintf("%s-%d", redis.Name, i)
-		fmt.Println("创建 pod lo :", name)
+		log.FromContext(ctx).V(1).Info("creating pod", "pod", name)
 
 		// 如果在 k8s 中存在则跳过。 暂不考虑有人直接修改 redis 的 finalizers 的情况
 		if isPodExistInK8S(ctx, client, redis.Namespace, name) {
@@ -139,7 +140,7 @@ func isPodExistInFinalizers2(redis *appv1.Redis, name string) bool {
 
 func DeleteRedis2(ctx context.Context, client client.Client, redis *appv1.Redis) error {
 
-	fmt.Println("进入删除循环咯")
+	log.FromContext(ctx).V(1).Info("deleting redis pods", "pods", redis.Finalizers)
 
 	isUpdated := false
 	for _, name := range redis.Finalizers {
@@ -0,0 +1,124 @@
+package helper2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+// FieldManager operator 执行 server-side apply 时使用的 field manager
+const FieldManager = "redis-operator"
+
+// ApplyConflictError 其他 field manager 已经拥有 operator 要修改的字段。
+// operator 不强制接管这些字段， 由用户决定保留哪一方的修改。
+type ApplyConflictError struct {
+	// Object 发生冲突的对象， 例如 Service/cache
+	Object string
+	Err    error
+}
+
+func (e *ApplyConflictError) Error() string {
+	return fmt.Sprintf("%s 字段冲突: %v", e.Object, e.Err)
+}
+
+func (e *ApplyConflictError) Unwrap() error {
+	return e.Err
+}
+
+// IsApplyConflict 判断错误是否为 server-side apply 的字段冲突
+func IsApplyConflict(err error) bool {
+	var conflict *ApplyConflictError
+	return errors.As(err, &conflict)
+}
+
+// NotOwnedError 同名对象已经存在， 但 OwnerReference 中没有当前 redis。
+// operator 不接管其他对象， 由用户删除或改名后再次调谐。
+type NotOwnedError struct {
+	// Object 发生冲突的对象， 例如 Service/cache
+	Object string
+}
+
+func (e *NotOwnedError) Error() string {
+	return fmt.Sprintf("%s 已经存在且不属于当前 redis", e.Object)
+}
+
+// IsNotOwned 判断错误是否为同名对象不属于当前 redis
+func IsNotOwned(err error) bool {
+	var notOwned *NotOwnedError
+	return errors.As(err, &notOwned)
+}
+
+// checkOwner 在 apply 之前确认同名对象不存在或者属于 redis， 返回已经存在的对象， 不存在时返回 nil。
+// 依次从 readers 中读取， 前一个中没有时读取下一个：
+// 按分片选择器过滤的 cache 中查不到标签不匹配的对象， 需要再通过 APIReader 读取 apiserver；
+// secret 等不在 cache 中的对象只传入 APIReader， 避免为其启动 informer。
+func checkOwner(ctx context.Context, scheme *runtime.Scheme, obj client.Object, redis *appv1.Redis, readers ...client.Reader) (client.Object, error) {
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		return nil, err
+	}
+	// 读取到空对象中， 避免 obj 中期望的 OwnerReference 残留
+	var existing client.Object
+	if _, ok := obj.(*unstructured.Unstructured); ok {
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(gvk)
+		existing = u
+	} else {
+		newObj, err := scheme.New(gvk)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if existing, ok = newObj.(client.Object); !ok {
+			return nil, fmt.Errorf("%s is not a client.Object", gvk.Kind)
+		}
+	}
+
+	key := client.ObjectKeyFromObject(obj)
+	for _, reader := range readers {
+		err := reader.Get(ctx, key, existing)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !OwnedBy(existing, redis.UID) {
+			return existing, &NotOwnedError{Object: fmt.Sprintf("%s/%s", gvk.Kind, obj.GetName())}
+		}
+		return existing, nil
+	}
+	return nil, nil
+}
+
+// apply 通过 server-side apply 创建或更新对象， obj 中只包含 operator 管理的字段。
+// ownerReferences 按 uid 合并， 不会覆盖其他 owner； 调用前需要用 checkOwner 确认不会接管其他对象。
+// 成功后 obj 为 apiserver 返回的最新对象。
+func apply(ctx context.Context, c client.Client, obj client.Object, scheme *runtime.Scheme) error {
+
+	// typed 对象没有 apiVersion 和 kind， apply 请求中必须包含
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		return err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	obj.SetManagedFields(nil)
+	obj.SetResourceVersion("")
+
+	err = c.Patch(ctx, obj, client.Apply, client.FieldOwner(FieldManager))
+	if apierrors.IsConflict(err) {
+		return &ApplyConflictError{
+			Object: fmt.Sprintf("%s/%s", gvk.Kind, obj.GetName()),
+			Err:    err,
+		}
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+package helper2
+
+import (
+	"context"
+	"fmt"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ApplyBindingSecret2 通过 server-side apply 更新保存连接信息的 secret， 返回 secret 名称。
+// 密码和 CA 每次从 secret 中重新读取， 密码或证书更新后连接信息随之更新。
+// 同名 secret 不属于当前 redis 时返回 NotOwnedError， 不会覆盖用户的 secret。
+func ApplyBindingSecret2(ctx context.Context, c client.Client, secrets client.Reader, redis *appv1.Redis, scheme *runtime.Scheme) (string, error) {
+
+	password, err := Password(ctx, secrets, redis)
+	if err != nil {
+		return "", err
+	}
+
+	var ca []byte
+	if redis.TLSEnabled() {
+		tlsSecret := &corev1.Secret{}
+		key := client.ObjectKey{Namespace: redis.Namespace, Name: builder.TLSSecretName(redis)}
+		if err := secrets.Get(ctx, key, tlsSecret); err != nil {
+			return "", fmt.Errorf("获取证书 secret 失败: %w", err)
+		}
+		ca = tlsSecret.Data[builder.TLSCAKey]
+	}
+
+	secret, err := builder.BindingSecret(redis, password, ca, scheme)
+	if err != nil {
+		return "", err
+	}
+	if _, err := checkOwner(ctx, scheme, secret, redis, secrets); err != nil {
+		return "", err
+	}
+	return secret.Name, apply(ctx, c, secret, scheme)
+}
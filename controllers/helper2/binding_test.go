@@ -0,0 +1,96 @@
+package helper2
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+)
+
+func TestApplyBindingSecret2(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1)
+	redis.Spec.Auth = &appv1.RedisAuth{PasswordSecret: corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "cache-auth"},
+		Key:                  "password",
+	}}
+	auth := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache-auth", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("old")},
+	}
+	c := newClient(t, redis, auth)
+
+	name, err := ApplyBindingSecret2(ctx, c, c, redis, c.Scheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "cache-binding" {
+		t.Fatalf("name = %q", name)
+	}
+
+	// 密码更新后连接信息随之更新
+	auth.Data["password"] = []byte("new")
+	if err := c.Update(ctx, auth); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ApplyBindingSecret2(ctx, c, c, redis, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	secret := getSecret(t, c, "cache-binding")
+	if got := string(secret.Data[builder.BindingPassword]); got != "new" {
+		t.Fatalf("password = %q, want new", got)
+	}
+	if got := string(secret.Data[builder.BindingURI]); got != "redis://:new@cache.default.svc:6379" {
+		t.Fatalf("uri = %q", got)
+	}
+}
+
+// 同名 secret 属于其他对象时不覆盖
+func TestApplyBindingSecret2NotOwned(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1)
+	other := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache-binding", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("user")},
+	}
+	c := newClient(t, redis, other)
+
+	if _, err := ApplyBindingSecret2(ctx, c, c, redis, c.Scheme()); !IsNotOwned(err) {
+		t.Fatalf("err = %v, want NotOwnedError", err)
+	}
+	if got := string(getSecret(t, c, "cache-binding").Data["password"]); got != "user" {
+		t.Fatalf("password = %q, the secret was overwritten", got)
+	}
+}
+
+func TestRedisForSecret2(t *testing.T) {
+	ctx := context.Background()
+	withAuth := newRedis(1)
+	withAuth.Spec.Auth = &appv1.RedisAuth{PasswordSecret: corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "shared-auth"},
+	}}
+	withTLS := newTLSRedis(1)
+	withTLS.Name = "secure"
+	withTLS.UID = "secure-uid"
+	c := newClient(t, withAuth, withTLS)
+
+	list, err := RedisForSecret2(ctx, c, "default", "shared-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "cache" {
+		t.Fatalf("redis for shared-auth = %v", list)
+	}
+
+	list, err = RedisForSecret2(ctx, c, "default", "secure-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "secure" {
+		t.Fatalf("redis for secure-tls = %v", list)
+	}
+}
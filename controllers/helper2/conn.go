@@ -0,0 +1,103 @@
+package helper2
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/resp"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// redisTimeout operator 访问 redis 的连接和命令超时
+const redisTimeout = 5 * time.Second
+
+// Password 读取 redis 的访问密码， 没有开启密码认证时返回空字符串。
+// secret 不在 manager 的 cache 中， reader 通常是 manager 的 APIReader。
+func Password(ctx context.Context, reader client.Reader, redis *appv1.Redis) (string, error) {
+	if redis.Spec.Auth == nil {
+		return "", nil
+	}
+
+	return secretValue(ctx, reader, redis.Namespace, redis.Spec.Auth.PasswordSecret)
+}
+
+// secretValue 读取 secret 中的一个 key
+func secretValue(ctx context.Context, reader client.Reader, namespace string, ref corev1.SecretKeySelector) (string, error) {
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: namespace, Name: ref.Name}
+	if err := reader.Get(ctx, key, secret); err != nil {
+		return "", fmt.Errorf("获取密码 secret (%s) 失败: %w", ref.Name, err)
+	}
+
+	password, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("密码 secret (%s) 中没有 %s", ref.Name, ref.Key)
+	}
+	return string(password), nil
+}
+
+// runningPods 返回 redis 中正在运行、 可以连接的 pod
+func runningPods(ctx context.Context, c client.Client, redis *appv1.Redis) ([]corev1.Pod, error) {
+	pods, err := ListPods2(ctx, c, redis)
+	if err != nil {
+		return nil, err
+	}
+
+	var running []corev1.Pod
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodRunning && pod.Status.PodIP != "" && pod.DeletionTimestamp.IsZero() {
+			running = append(running, pod)
+		}
+	}
+	return running, nil
+}
+
+// dialPod 连接 pod 中的 redis
+func dialPod(ctx context.Context, redis *appv1.Redis, pod *corev1.Pod, password string, config *tls.Config) (*resp.Conn, error) {
+	addr := net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(redis.Spec.Port)))
+	conn, err := resp.DialTLS(ctx, addr, password, redisTimeout, config)
+	if err != nil {
+		return nil, fmt.Errorf("连接 pod (%s) 失败: %w", pod.Name, err)
+	}
+	return conn, nil
+}
+
+// forEachRunningPod 依次连接每个运行中的 pod 执行 fn
+func forEachRunningPod(ctx context.Context, c client.Client, secrets client.Reader, redis *appv1.Redis, fn func(pod *corev1.Pod, conn *resp.Conn) error) error {
+	pods, err := runningPods(ctx, c, redis)
+	if err != nil {
+		return err
+	}
+	if len(pods) == 0 {
+		return nil
+	}
+
+	password, err := Password(ctx, secrets, redis)
+	if err != nil {
+		return err
+	}
+	config, err := tlsConfig(ctx, secrets, redis)
+	if err != nil {
+		return err
+	}
+
+	for i := range pods {
+		pod := &pods[i]
+		conn, err := dialPod(ctx, redis, pod, password, config)
+		if err != nil {
+			return err
+		}
+		err = fn(pod, conn)
+		conn.Close()
+		if err != nil {
+			return fmt.Errorf("pod (%s): %w", pod.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package helper2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+	"github.com/tangx/k8s-operator-demo/controllers/resp"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// StartBackup2 在每个运行中的 pod 上执行 BGSAVE， 已经在备份的 pod 视为已开始
+func StartBackup2(ctx context.Context, c client.Client, secrets client.Reader, redis *appv1.Redis) error {
+	return forEachRunningPod(ctx, c, secrets, redis, func(pod *corev1.Pod, conn *resp.Conn) error {
+		_, err := conn.Do("BGSAVE")
+		var redisErr resp.Error
+		if errors.As(err, &redisErr) && strings.Contains(string(redisErr), "already in progress") {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("BGSAVE 失败: %w", err)
+		}
+		log.FromContext(ctx).Info("started final backup", "pod", pod.Name)
+		return nil
+	})
+}
+
+// BackupCompleted2 检查 since 之后开始的备份是否已经在全部运行中的 pod 上完成。
+// 备份失败时返回错误， 需要重新执行 StartBackup2。
+func BackupCompleted2(ctx context.Context, c client.Client, secrets client.Reader, redis *appv1.Redis, since time.Time) (bool, error) {
+	completed := true
+	err := forEachRunningPod(ctx, c, secrets, redis, func(pod *corev1.Pod, conn *resp.Conn) error {
+		info, err := conn.String("INFO", "persistence")
+		if err != nil {
+			return fmt.Errorf("INFO persistence 失败: %w", err)
+		}
+		fields := parseInfo(info)
+
+		if fields["rdb_bgsave_in_progress"] != "0" {
+			completed = false
+			return nil
+		}
+		if status := fields["rdb_last_bgsave_status"]; status != "ok" {
+			return fmt.Errorf("BGSAVE 失败: rdb_last_bgsave_status:%s", status)
+		}
+		saved, err := strconv.ParseInt(fields["rdb_last_save_time"], 10, 64)
+		if err != nil {
+			return fmt.Errorf("无法解析 rdb_last_save_time: %w", err)
+		}
+		// LASTSAVE 精确到秒
+		if saved < since.Unix() {
+			completed = false
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return completed, nil
+}
+
+// CountClients2 统计全部运行中的 pod 上的客户端连接数， 不包括主从复制连接和 operator 自己的连接
+func CountClients2(ctx context.Context, c client.Client, secrets client.Reader, redis *appv1.Redis) (int, error) {
+	total := 0
+	err := forEachRunningPod(ctx, c, secrets, redis, func(pod *corev1.Pod, conn *resp.Conn) error {
+		self, err := conn.Int("CLIENT", "ID")
+		if err != nil {
+			return fmt.Errorf("CLIENT ID 失败: %w", err)
+		}
+		list, err := conn.String("CLIENT", "LIST")
+		if err != nil {
+			return fmt.Errorf("CLIENT LIST 失败: %w", err)
+		}
+		total += countClients(list, self)
+		return nil
+	})
+	return total, err
+}
+
+// countClients 解析 CLIENT LIST 的输出
+func countClients(list string, self int64) int {
+	n := 0
+	for _, line := range strings.Split(list, "\n") {
+		fields := parseFields(strings.TrimSpace(line))
+		if len(fields) == 0 || fields["id"] == strconv.FormatInt(self, 10) {
+			continue
+		}
+		// S: 从节点连接， M: 主节点连接
+		if strings.ContainsAny(fields["flags"], "SM") {
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// parseFields 解析 key=value 形式、 空格分隔的一行
+func parseFields(line string) map[string]string {
+	fields := map[string]string{}
+	for _, kv := range strings.Fields(line) {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			fields[kv[:i]] = kv[i+1:]
+		}
+	}
+	return fields
+}
+
+// parseInfo 解析 INFO 的输出
+func parseInfo(info string) map[string]string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.IndexByte(line, ':'); i > 0 {
+			fields[line[:i]] = line[i+1:]
+		}
+	}
+	return fields
+}
+
+// RetainRedis2 删除 redis 但保留 pod， 返回保留的 pod 名称。
+// pod 去掉指向 redis 的 OwnerReference， 避免被垃圾回收；
+// 同时去掉 managed-by 标签， 避免被当作孤儿 pod 清理。
+func RetainRedis2(ctx context.Context, c client.Client, redis *appv1.Redis) ([]string, error) {
+
+	logger := log.FromContext(ctx)
+
+	names := append([]string(nil), redis.Finalizers...)
+
+	var retained []string
+	var retainErr error
+	for _, name := range names {
+		if err := retainPod(ctx, c, redis, name); err != nil {
+			retainErr = fmt.Errorf("保留 pod (%s) 失败: %w", name, err)
+			break
+		}
+		logger.Info("retained pod", "pod", name)
+		retained = append(retained, name)
+	}
+
+	if len(retained) > 0 {
+		err := updateFinalizers(ctx, c, redis, func(redis *appv1.Redis) bool {
+			updated := false
+			for _, name := range retained {
+				if controllerutil.ContainsFinalizer(redis, name) {
+					controllerutil.RemoveFinalizer(redis, name)
+					updated = true
+				}
+			}
+			return updated
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return retained, fmt.Errorf("移除 finalizer 失败: %w", err)
+		}
+	}
+
+	return retained, retainErr
+}
+
+func retainPod(ctx context.Context, c client.Client, redis *appv1.Redis, name string) error {
+	pod := &corev1.Pod{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: redis.Namespace, Name: name}, pod)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var refs []metav1.OwnerReference
+	for _, ref := range pod.OwnerReferences {
+		if ref.UID != redis.UID {
+			refs = append(refs, ref)
+		}
+	}
+
+	// ownerReferences 使用 merge patch 整体替换， 带上 resourceVersion 避免覆盖并发修改
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": pod.ResourceVersion,
+			"ownerReferences": refs,
+			"labels": map[string]interface{}{
+				builder.LabelManagedBy: nil,
+			},
+			"annotations": map[string]interface{}{
+				appv1.RetainedAnnotation: fmt.Sprintf("%s/%s", redis.Name, redis.UID),
+			},
+		},
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	return c.Patch(ctx, pod, client.RawPatch(types.MergePatchType, data))
+}
@@ -0,0 +1,153 @@
+package helper2
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+	"github.com/tangx/k8s-operator-demo/controllers/resp/resptest"
+)
+
+// newRunningPod 创建运行中、 可以通过 127.0.0.1 连接的 pod
+func newRunningPod(name string) *corev1.Pod {
+	pod := newPod(name)
+	pod.Status = corev1.PodStatus{
+		Phase: corev1.PodRunning,
+		PodIP: "127.0.0.1",
+	}
+	return pod
+}
+
+func newServer(t *testing.T, handler resptest.Handler) *resptest.Server {
+	t.Helper()
+
+	server, err := resptest.NewServer(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func TestCountClients2(t *testing.T) {
+	ctx := context.Background()
+	server := newServer(t, func(args []string) string {
+		switch args[0] + " " + args[1] {
+		case "CLIENT ID":
+			return resptest.Int(7)
+		case "CLIENT LIST":
+			return resptest.Bulk("" +
+				"id=3 addr=10.0.0.1:5000 flags=N cmd=get\n" +
+				"id=4 addr=10.0.0.2:5000 flags=S cmd=replconf\n" +
+				"id=5 addr=10.0.0.3:5000 flags=N cmd=subscribe\n" +
+				"id=7 addr=10.0.0.9:5000 flags=N cmd=client\n")
+		}
+		return resptest.Err("ERR unknown command")
+	})
+
+	redis := newRedis(2, "cache-0", "cache-1")
+	redis.Spec.Port = server.Port()
+	// cache-1 没有运行， 不统计
+	c := newClient(t, redis, newRunningPod("cache-0"), newPod("cache-1"))
+
+	clients, err := CountClients2(ctx, c, c, redis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clients != 2 {
+		t.Fatalf("clients = %d, want 2", clients)
+	}
+}
+
+func TestFinalBackup(t *testing.T) {
+	ctx := context.Background()
+	since := time.Now().Add(-time.Second)
+	saving := true
+	server := newServer(t, func(args []string) string {
+		switch args[0] {
+		case "AUTH":
+			return resptest.OK
+		case "BGSAVE":
+			return resptest.Bulk("Background saving started")
+		case "INFO":
+			if saving {
+				return resptest.Bulk("# Persistence\r\nrdb_bgsave_in_progress:1\r\n")
+			}
+			return resptest.Bulk("# Persistence\r\n" +
+				"rdb_bgsave_in_progress:0\r\n" +
+				"rdb_last_bgsave_status:ok\r\n" +
+				"rdb_last_save_time:" + strconv.FormatInt(time.Now().Unix(), 10) + "\r\n")
+		}
+		return resptest.Err("ERR unknown command")
+	})
+
+	redis := newRedis(1, "cache-0")
+	redis.Spec.Port = server.Port()
+	redis.Spec.Auth = &appv1.RedisAuth{PasswordSecret: corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "cache-auth"},
+		Key:                  "password",
+	}}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache-auth", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+	c := newClient(t, redis, secret, newRunningPod("cache-0"))
+
+	if err := StartBackup2(ctx, c, c, redis); err != nil {
+		t.Fatal(err)
+	}
+	if done, err := BackupCompleted2(ctx, c, c, redis, since); err != nil || done {
+		t.Fatalf("BackupCompleted2 = %v, %v; want false while saving", done, err)
+	}
+
+	saving = false
+	if done, err := BackupCompleted2(ctx, c, c, redis, since); err != nil || !done {
+		t.Fatalf("BackupCompleted2 = %v, %v; want true", done, err)
+	}
+
+	commands := server.Commands()
+	if len(commands) == 0 || commands[0][0] != "AUTH" || commands[0][1] != "secret" {
+		t.Fatalf("expected AUTH with the secret password, got %v", commands)
+	}
+}
+
+func TestRetainRedis2(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(2, "cache-0", "cache-1")
+	pod := newPod("cache-0")
+	pod.Labels = builder.Labels(redis)
+	c := newClient(t, redis, pod)
+
+	retained, err := RetainRedis2(ctx, c, redis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, "retained", retained, "cache-0", "cache-1")
+	assertStrings(t, "pods", podNames(t, c), "cache-0")
+	assertStrings(t, "finalizers", stored(t, c).Finalizers)
+
+	key := client.ObjectKeyFromObject(pod)
+	pod = &corev1.Pod{}
+	if err := c.Get(ctx, key, pod); err != nil {
+		t.Fatal(err)
+	}
+	if len(pod.OwnerReferences) != 0 {
+		t.Fatalf("owner references = %v", pod.OwnerReferences)
+	}
+	if _, ok := pod.Labels[builder.LabelManagedBy]; ok {
+		t.Fatalf("retained pod must not be managed by the operator, labels = %v", pod.Labels)
+	}
+	if pod.Labels[builder.LabelInstance] != "cache" {
+		t.Fatalf("labels = %v", pod.Labels)
+	}
+	if pod.Annotations[appv1.RetainedAnnotation] != "cache/redis-uid" {
+		t.Fatalf("annotations = %v", pod.Annotations)
+	}
+}
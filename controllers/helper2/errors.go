@@ -0,0 +1,76 @@
+package helper2
+
+import (
+	"context"
+	"time"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// IsTerminalError 判断错误是否为终止性错误。
+// 终止性错误 (权限不足、 对象不合法等) 重试也不会成功， 需要人工介入。
+// 其余错误 (超时、 限流、 冲突、 网络错误等) 均视为可重试。
+func IsTerminalError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch {
+	case apierrors.IsForbidden(err),
+		apierrors.IsUnauthorized(err),
+		apierrors.IsInvalid(err),
+		apierrors.IsBadRequest(err),
+		apierrors.IsMethodNotSupported(err),
+		apierrors.IsNotAcceptable(err),
+		apierrors.IsUnsupportedMediaType(err),
+		apierrors.IsRequestEntityTooLargeError(err):
+		return true
+	}
+
+	return false
+}
+
+// RetryDelay 返回 apiserver 建议的重试间隔， 例如 429 限流时的 Retry-After
+func RetryDelay(err error) (time.Duration, bool) {
+	seconds, ok := apierrors.SuggestsClientDelay(err)
+	if !ok || seconds <= 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// updateFinalizers 修改 redis 的 finalizers， 只把 finalizers 的变化 patch 到 k8s，
+// 不会覆盖其他客户端对 redis 其余字段的修改。
+// finalizers 是列表， merge patch 会整体替换， 因此 patch 带上 resourceVersion 做乐观锁；
+// 遇到冲突时重新获取最新的 redis， 在最新对象上重新执行 mutate 后再次 patch。
+// patch 在副本上执行， 只把 ObjectMeta 复制回 redis， 保留本次调谐中已经设置、 还没有保存的 status。
+// mutate 返回 false 表示不需要更新。
+func updateFinalizers(ctx context.Context, c client.Client, redis *appv1.Redis, mutate func(redis *appv1.Redis) bool) error {
+
+	fresh := false
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		if fresh {
+			// 读取到新对象中， 避免本地已修改的字段残留
+			latest := &appv1.Redis{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(redis), latest); err != nil {
+				return err
+			}
+			redis.ObjectMeta = latest.ObjectMeta
+		}
+		fresh = true
+
+		original := redis.DeepCopy()
+		patched := redis.DeepCopy()
+		if !mutate(patched) {
+			return nil
+		}
+		if err := c.Patch(ctx, patched, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{})); err != nil {
+			return err
+		}
+		redis.ObjectMeta = patched.ObjectMeta
+		return nil
+	})
+}
@@ -0,0 +1,52 @@
+package helper2
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestIsTerminalError(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+
+	cases := []struct {
+		name     string
+		err      error
+		terminal bool
+	}{
+		{"nil", nil, false},
+		{"forbidden", apierrors.NewForbidden(pods, "r-0", errors.New("rbac")), true},
+		{"unauthorized", apierrors.NewUnauthorized("token expired"), true},
+		{"invalid", apierrors.NewInvalid(schema.GroupKind{Kind: "Pod"}, "r-0", field.ErrorList{}), true},
+		{"bad request", apierrors.NewBadRequest("bad"), true},
+		{"wrapped forbidden", fmt.Errorf("删除 pod 失败: %w", apierrors.NewForbidden(pods, "r-0", errors.New("rbac"))), true},
+		{"conflict", apierrors.NewConflict(pods, "r-0", errors.New("modified")), false},
+		{"server timeout", apierrors.NewServerTimeout(pods, "delete", 1), false},
+		{"too many requests", apierrors.NewTooManyRequests("slow down", 3), false},
+		{"service unavailable", apierrors.NewServiceUnavailable("down"), false},
+		{"internal", apierrors.NewInternalError(errors.New("etcd")), false},
+		{"network", errors.New("connection refused"), false},
+	}
+
+	for _, c := range cases {
+		if got := IsTerminalError(c.err); got != c.terminal {
+			t.Errorf("%s: IsTerminalError = %v, want %v", c.name, got, c.terminal)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	delay, ok := RetryDelay(fmt.Errorf("wrapped: %w", apierrors.NewTooManyRequests("slow down", 3)))
+	if !ok || delay != 3*time.Second {
+		t.Fatalf("RetryDelay = %v, %v; want 3s, true", delay, ok)
+	}
+
+	if _, ok := RetryDelay(errors.New("connection refused")); ok {
+		t.Fatal("RetryDelay should not suggest a delay for plain errors")
+	}
+}
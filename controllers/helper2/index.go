@@ -0,0 +1,123 @@
+package helper2
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+)
+
+// cache 中 pod、 redis 和 RedisUser 的索引
+const (
+	// OwnerUIDIndex 按所属 redis 的 UID 索引 pod
+	OwnerUIDIndex = ".metadata.ownerReferences.redisUID"
+
+	// SecretIndex 按引用的 secret 名称索引 redis， 密码或证书更新时找到需要更新连接信息的 redis
+	SecretIndex = ".spec.secrets"
+
+	// UserRedisIndex 按所属 redis 名称索引 RedisUser
+	UserRedisIndex = ".spec.redisRef.name"
+	// UserSecretIndex 按密码 secret 名称索引 RedisUser
+	UserSecretIndex = ".spec.passwordSecret.name"
+)
+
+// SetupIndexes 向 manager cache 注册索引， 需要在 manager 启动前调用
+func SetupIndexes(ctx context.Context, indexer client.FieldIndexer) error {
+	if err := indexer.IndexField(ctx, &corev1.Pod{}, OwnerUIDIndex, redisOwnerUIDs); err != nil {
+		return err
+	}
+	return indexer.IndexField(ctx, &appv1.Redis{}, SecretIndex, referencedSecrets)
+}
+
+// redisOwnerUIDs 返回对象所属 redis 的 UID
+func redisOwnerUIDs(obj client.Object) []string {
+	var uids []string
+	for _, owner := range obj.GetOwnerReferences() {
+		if owner.APIVersion == appv1.GroupVersion.String() && owner.Kind == "Redis" {
+			uids = append(uids, string(owner.UID))
+		}
+	}
+	return uids
+}
+
+// OwnedBy 判断对象的 OwnerReference 中是否有 UID 为 uid 的 redis
+func OwnedBy(obj client.Object, uid types.UID) bool {
+	for _, owner := range redisOwnerUIDs(obj) {
+		if owner == string(uid) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetupUserIndexes 向 manager cache 注册 RedisUser 索引， 需要在 manager 启动前调用
+func SetupUserIndexes(ctx context.Context, indexer client.FieldIndexer) error {
+	err := indexer.IndexField(ctx, &appv1.RedisUser{}, UserRedisIndex, func(obj client.Object) []string {
+		return []string{obj.(*appv1.RedisUser).Spec.RedisRef.Name}
+	})
+	if err != nil {
+		return err
+	}
+	return indexer.IndexField(ctx, &appv1.RedisUser{}, UserSecretIndex, func(obj client.Object) []string {
+		return []string{obj.(*appv1.RedisUser).Spec.PasswordSecret.Name}
+	})
+}
+
+// referencedSecrets 返回 redis 引用的密码和证书 secret
+func referencedSecrets(obj client.Object) []string {
+	redis, ok := obj.(*appv1.Redis)
+	if !ok {
+		return nil
+	}
+	var names []string
+	if redis.Spec.Auth != nil {
+		names = append(names, redis.Spec.Auth.PasswordSecret.Name)
+	}
+	if redis.TLSEnabled() {
+		names = append(names, builder.TLSSecretName(redis))
+	}
+	return names
+}
+
+// ListPods2 通过 owner UID 索引一次取得 redis 拥有的全部 pod
+func ListPods2(ctx context.Context, c client.Client, redis *appv1.Redis) ([]corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	err := c.List(ctx, pods,
+		client.InNamespace(redis.Namespace),
+		client.MatchingFields{OwnerUIDIndex: string(redis.UID)},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+// RedisForSecret2 返回引用了 secret 的 redis
+func RedisForSecret2(ctx context.Context, c client.Client, namespace, name string) ([]appv1.Redis, error) {
+	list := &appv1.RedisList{}
+	err := c.List(ctx, list,
+		client.InNamespace(namespace),
+		client.MatchingFields{SecretIndex: name},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// ListUsers2 返回通过 field 索引找到的 RedisUser， 例如 UserRedisIndex 为 redis 名称
+func ListUsers2(ctx context.Context, c client.Client, namespace, field, value string) ([]appv1.RedisUser, error) {
+	list := &appv1.RedisUserList{}
+	err := c.List(ctx, list,
+		client.InNamespace(namespace),
+		client.MatchingFields{field: value},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
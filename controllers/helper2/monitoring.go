@@ -0,0 +1,44 @@
+package helper2
+
+import (
+	"context"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// IsServiceMonitorAvailable 通过 discovery 检测集群中是否安装了 ServiceMonitor CRD
+func IsServiceMonitorAvailable(dc discovery.DiscoveryInterface) (bool, error) {
+	resources, err := dc.ServerResourcesForGroupVersion(builder.ServiceMonitorGVK.GroupVersion().String())
+	if err != nil {
+		if discovery.IsGroupDiscoveryFailedError(err) || apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, r := range resources.APIResources {
+		if r.Kind == builder.ServiceMonitorGVK.Kind {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ApplyServiceMonitor2 通过 server-side apply 创建或更新 redis 的 ServiceMonitor。
+// 同名 ServiceMonitor 不属于当前 redis 时返回 NotOwnedError， 不会接管。
+func ApplyServiceMonitor2(ctx context.Context, client client.Client, redis *appv1.Redis, scheme *runtime.Scheme) error {
+
+	sm, err := builder.ServiceMonitor(redis, scheme)
+	if err != nil {
+		return err
+	}
+	if _, err := checkOwner(ctx, scheme, sm, redis, client); err != nil {
+		return err
+	}
+	return apply(ctx, client, sm, scheme)
+}
@@ -0,0 +1,45 @@
+package helper2
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+	"github.com/tangx/k8s-operator-demo/controllers/fakeclient"
+)
+
+// 同名 ServiceMonitor 属于其他对象时不接管
+func TestApplyServiceMonitor2NotOwned(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1)
+	redis.Spec.Monitoring = &appv1.RedisMonitoring{
+		Enabled:        true,
+		ServiceMonitor: &appv1.ServiceMonitorSpec{Enabled: true},
+	}
+	c := newClient(t, redis)
+
+	sm := &unstructured.Unstructured{}
+	sm.SetGroupVersionKind(builder.ServiceMonitorGVK)
+	sm.SetName("cache")
+	sm.SetNamespace("default")
+	if err := c.Create(ctx, sm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ApplyServiceMonitor2(ctx, c, redis, c.Scheme()); !IsNotOwned(err) {
+		t.Fatalf("err = %v, want NotOwnedError", err)
+	}
+	if c.Calls(fakeclient.Apply) != 0 {
+		t.Fatal("service monitor applied")
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(sm), sm); err != nil {
+		t.Fatal(err)
+	}
+	if len(sm.GetOwnerReferences()) != 0 {
+		t.Fatalf("service monitor was taken over: %v", sm.GetOwnerReferences())
+	}
+}
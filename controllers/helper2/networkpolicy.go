@@ -0,0 +1,52 @@
+package helper2
+
+import (
+	"context"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ApplyNetworkPolicy2 通过 server-side apply 创建或更新 redis 的 NetworkPolicy。
+// 同名 NetworkPolicy 不属于当前 redis 时返回 NotOwnedError， 不会接管。
+func ApplyNetworkPolicy2(ctx context.Context, client client.Client, reader client.Reader, redis *appv1.Redis, operatorNamespace string, scheme *runtime.Scheme) error {
+
+	np, err := builder.NetworkPolicy(redis, operatorNamespace, scheme)
+	if err != nil {
+		return err
+	}
+	if _, err := checkOwner(ctx, scheme, np, redis, client, reader); err != nil {
+		return err
+	}
+	return apply(ctx, client, np, scheme)
+}
+
+// DeleteNetworkPolicy2 关闭 spec.networkPolicy 后删除 operator 创建的 NetworkPolicy。
+// 只删除属于这个 redis 的 NetworkPolicy， 不影响用户自己创建的同名对象。
+func DeleteNetworkPolicy2(ctx context.Context, c client.Client, redis *appv1.Redis) error {
+
+	np := &networkingv1.NetworkPolicy{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: redis.Namespace, Name: redis.Name}, np)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !OwnedBy(np, redis.UID) {
+		return nil
+	}
+
+	err = c.Delete(ctx, np, client.Preconditions{UID: &np.UID})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	log.FromContext(ctx).Info("deleted network policy", "networkPolicy", np.Name)
+	return nil
+}
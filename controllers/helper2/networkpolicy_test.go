@@ -0,0 +1,76 @@
+package helper2
+
+import (
+	"context"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/fakeclient"
+)
+
+func TestNetworkPolicy2(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1)
+	redis.Spec.NetworkPolicy = &appv1.RedisNetworkPolicy{Enabled: true}
+	c := newClient(t, redis)
+	key := client.ObjectKey{Namespace: "default", Name: "cache"}
+
+	if err := ApplyNetworkPolicy2(ctx, c, c, redis, "redis-operator-system", c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	np := &networkingv1.NetworkPolicy{}
+	if err := c.Get(ctx, key, np); err != nil {
+		t.Fatal(err)
+	}
+	if len(np.Spec.Ingress) != 1 || len(np.Spec.Ingress[0].From) != 2 {
+		t.Fatalf("ingress = %+v, want redis pods and the operator", np.Spec.Ingress)
+	}
+	operator := np.Spec.Ingress[0].From[1].NamespaceSelector
+	if operator == nil || operator.MatchLabels["kubernetes.io/metadata.name"] != "redis-operator-system" {
+		t.Fatalf("operator peer = %+v", np.Spec.Ingress[0].From[1])
+	}
+
+	redis.Spec.NetworkPolicy.Enabled = false
+	if err := DeleteNetworkPolicy2(ctx, c, redis); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, key, &networkingv1.NetworkPolicy{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the network policy to be deleted, err = %v", err)
+	}
+}
+
+func TestDeleteNetworkPolicy2KeepsForeignPolicy(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1)
+	np := &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"}}
+	c := newClient(t, redis, np)
+
+	if err := DeleteNetworkPolicy2(ctx, c, redis); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(np), &networkingv1.NetworkPolicy{}); err != nil {
+		t.Fatalf("network policy not owned by the redis must be kept, err = %v", err)
+	}
+}
+
+// 同名 NetworkPolicy 属于其他对象时不接管
+func TestApplyNetworkPolicy2NotOwned(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1)
+	redis.Spec.NetworkPolicy = &appv1.RedisNetworkPolicy{Enabled: true}
+	np := &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"}}
+	c := newClient(t, redis, np)
+
+	err := ApplyNetworkPolicy2(ctx, c, c, redis, "redis-operator-system", c.Scheme())
+	if !IsNotOwned(err) {
+		t.Fatalf("err = %v, want NotOwnedError", err)
+	}
+	if c.Calls(fakeclient.Apply) != 0 {
+		t.Fatal("network policy applied")
+	}
+}
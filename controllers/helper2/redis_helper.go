@@ -5,147 +5,250 @@ import (
 	"fmt"
 
 	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+	"github.com/tangx/k8s-operator-demo/controllers/metrics"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-// CreateRedis 创建 redis pod
-func CreateRedisPod2(ctx context.Context, client client.Client, redis *appv1.Redis, scheme *runtime.Scheme) error {
+// CreateRedisPod2 通过 server-side apply 创建或更新 redis pod， 返回被外部删除后重建的 pod 名称
+// 同名 pod 不属于当前 redis 时跳过该 pod， 返回 NotOwnedError。
+// reader 读取不在 owner 索引中的同名 pod， 通常是 manager 的 APIReader； versions 用于解析 spec.version。
+func CreateRedisPod2(ctx context.Context, client client.Client, reader client.Reader, redis *appv1.Redis, versions appv1.VersionCatalog, scheme *runtime.Scheme) ([]string, error) {
 
-	isUpdated := false
+	logger := log.FromContext(ctx)
+
+	// 一次 List 取得已有的全部 pod， 代替逐个 Get
+	pods, err := ListPods2(ctx, client, redis)
+	if err != nil {
+		return nil, fmt.Errorf("获取 redis pod 失败: %w", err)
+	}
+	image, err := redis.RedisImage(versions)
+	if err != nil {
+		return nil, err
+	}
+	existing := map[string]*corev1.Pod{}
+	// 证书或镜像与期望不一致的 pod， 分别由 RotatePod2 和 UpgradePod2 处理
+	outdated := map[string]bool{}
+	for i := range pods {
+		pod := &pods[i]
+		existing[pod.Name] = pod
+		if pod.Annotations[appv1.TLSHashAnnotation] != redis.TLSCertificateHash() || imageOutdated(redis, pod, image) {
+			outdated[pod.Name] = true
+		}
+	}
+
+	var recreated []string
+	var added []string
+	var applyErr, notOwned error
 	for i := 0; i < redis.Spec.Replicas; i++ {
-		name := fmt.Sprintf("%s-%d", redis.Name, i)
-		fmt.Println("创建 pod lo :", name)
+		name := builder.PodName(redis, i)
 
-		// 如果在 k8s 中存在则跳过。 暂不考虑有人直接修改 redis 的 finalizers 的情况
-		if isPodExistInK8S(ctx, client, redis.Namespace, name) {
-			continue
+		// 已经存在的 pod 同样 apply， 修正被外部修改的标签等字段。
+		pod, err := builder.Pod(redis, name, versions, scheme)
+		if err != nil {
+			applyErr = err
+			break
+		}
+		// 不在索引中的同名 pod 先确认属于当前 redis。 属于当前 redis 但标签不匹配分片选择器的 pod 会补上标签，
+		// 其他对象的 pod 不接管， 也不添加 finalizer
+		if existing[name] == nil {
+			current, err := checkOwner(ctx, scheme, pod, redis, client, reader)
+			if IsNotOwned(err) {
+				logger.Info("pod not owned by redis, skipping", "pod", name)
+				if notOwned == nil {
+					notOwned = err
+				}
+				continue
+			}
+			if err != nil {
+				applyErr = fmt.Errorf("获取 pod (%s) 失败: %w", name, err)
+				break
+			}
+			if current != nil {
+				existing[name] = current.(*corev1.Pod)
+			}
+		}
+		// 新建的 pod 先创建数据目录使用的 PVC
+		if existing[name] == nil && redis.PersistentStorage() {
+			err := createClaim(ctx, client, reader, redis, name)
+			if IsNotOwned(err) {
+				logger.Info("persistent volume claim not owned by redis, skipping pod", "pod", name)
+				if notOwned == nil {
+					notOwned = err
+				}
+				continue
+			}
+			if err != nil {
+				applyErr = fmt.Errorf("创建 pod (%s) 的 PVC 失败: %w", name, err)
+				break
+			}
 		}
+		// 证书 hash 是可以修改的注解， apply 后就无法区分 pod 是否已经使用了新证书；
+		// 镜像可以原地修改， 但会跳过从节点优先的升级顺序
+		if outdated[name] {
+			logger.V(1).Info("pod outdated, waiting for rotation or upgrade", "pod", name)
+		} else if err := apply(ctx, client, pod, scheme); err != nil {
+			// pod spec 的大部分字段不可修改， 需要重建 pod 才能生效， 这里保留现有 pod
+			if existing[name] != nil && apierrors.IsInvalid(err) {
+				logger.Info("pod spec changed, recreate the pod to apply it", "pod", name, "reason", err.Error())
+			} else {
+				applyErr = fmt.Errorf("apply pod (%s) 失败: %w", name, err)
+				break
+			}
+		}
+
+		// 如果在 k8s 中已经存在。 暂不考虑有人直接修改 redis 的 finalizers 的情况
+		if current := existing[name]; current != nil {
+			logger.V(1).Info("pod already exists", "pod", name)
 
-		pod := getPod2(redis, name, scheme)
-		if err := client.Create(ctx, pod); err != nil {
-			return err
+			// 上次创建 pod 后 finalizer 更新失败， 补上 finalizer。
+			// 只为属于当前 redis 的 pod 添加， 否则删除 redis 时会去删除其他对象的 pod
+			if !controllerutil.ContainsFinalizer(redis, name) && OwnedBy(current, redis.UID) {
+				added = append(added, name)
+			}
+			continue
 		}
+		metrics.Default.PodCreated()
 
 		// 如果 pod.Name 在 finaliers 中， 则为删后重建。
-		if controllerutil.ContainsFinalizer(redis, pod.Name) {
+		if controllerutil.ContainsFinalizer(redis, name) {
+			logger.Info("recreated missing pod", "pod", name)
+			metrics.Default.DriftCorrected("pod")
+			recreated = append(recreated, name)
 			continue
 		}
+		logger.Info("created pod", "pod", name)
 
 		// 如果 pod.Name 不在 finalizers 中， 则为新增 pod。
 		// 使用 Finalizer 管理创建的 Pod。 当 pod 被删除完的时候，才能删除 redis
 		// redis.Finalizers = append(redis.Finalizers, pod.Name)
-		controllerutil.AddFinalizer(redis, name)
-		isUpdated = true
+		added = append(added, name)
 	}
 
-	// redis.Finalizers 的变更是在本地内存中， 使用 update 更新到 k8s 中
-	if isUpdated {
-		return client.Update(ctx, redis)
+	// redis.Finalizers 的变更是在本地内存中， 使用 patch 更新到 k8s 中
+	// 即使后续 pod 创建失败， 已经创建的 pod 也要记录到 finalizers 中
+	if len(added) > 0 {
+		err := updateFinalizers(ctx, client, redis, func(redis *appv1.Redis) bool {
+			updated := false
+			for _, name := range added {
+				if !controllerutil.ContainsFinalizer(redis, name) {
+					controllerutil.AddFinalizer(redis, name)
+					updated = true
+				}
+			}
+			return updated
+		})
+		if err != nil {
+			return recreated, fmt.Errorf("添加 finalizer 失败: %w", err)
+		}
 	}
-	return nil
-}
-
-func getPod2(redis *appv1.Redis, name string, scheme *runtime.Scheme) *corev1.Pod {
 
-	pod := &corev1.Pod{}
-	pod.Name = name
-	pod.Namespace = redis.Namespace
+	if applyErr == nil {
+		applyErr = notOwned
+	}
+	return recreated, applyErr
+}
 
-	// 创建 pod 时添加 OwnerReference
-	controllerutil.SetOwnerReference(redis, pod, scheme)
+func DeleteRedis2(ctx context.Context, client client.Client, redis *appv1.Redis) error {
 
-	// 增加 label 便于删除
-	pod.ObjectMeta.Labels = map[string]string{
-		"app": redis.Name,
-	}
+	logger := log.FromContext(ctx)
+	logger.V(1).Info("deleting redis pods", "pods", redis.Finalizers)
 
-	pod.Spec.Containers = []corev1.Container{
-		{
-			Name:            redis.Name,
-			Image:           redis.Spec.Image,
-			ImagePullPolicy: corev1.PullIfNotPresent,
-			Ports: []corev1.ContainerPort{
-				{
-					ContainerPort: redis.Spec.Port,
-				},
-			},
-		},
-	}
+	return deletePods(ctx, client, redis, redis.Finalizers)
+}
 
-	return pod
+func DecreaseRedis2(ctx context.Context, client client.Client, redis *appv1.Redis) error {
+	return deletePods(ctx, client, redis, redis.Finalizers[redis.Spec.Replicas:])
 }
 
-func DeleteRedis2(ctx context.Context, client client.Client, redis *appv1.Redis) error {
+// deletePods 删除 pod 并移除对应的 finalizer。
+// 删除失败时， 已经删除的 pod 的 finalizer 仍然会被移除。
+func deletePods(ctx context.Context, client client.Client, redis *appv1.Redis, names []string) error {
 
-	fmt.Println("进入删除循环咯")
+	// names 可能是 redis.Finalizers 的切片， 移除 finalizer 时会被修改， 先复制一份
+	names = append([]string(nil), names...)
 
-	isUpdated := false
-	for _, name := range redis.Finalizers {
-		pod, err := getPodFromK8s(ctx, client, redis.Namespace, name)
-		if err != nil {
-			return err
+	var deleted []string
+	var deleteErr error
+	for _, name := range names {
+		if err := deletePod(ctx, client, redis.Namespace, name); err != nil {
+			deleteErr = fmt.Errorf("删除 pod (%s) 失败: %w", name, err)
+			break
 		}
+		deleted = append(deleted, name)
+	}
 
-		if err := client.Delete(ctx, pod); err != nil {
-			return fmt.Errorf("删除 pod (%s) 失败: %v\n", name, err)
+	if len(deleted) > 0 {
+		err := updateFinalizers(ctx, client, redis, func(redis *appv1.Redis) bool {
+			updated := false
+			for _, name := range deleted {
+				if controllerutil.ContainsFinalizer(redis, name) {
+					controllerutil.RemoveFinalizer(redis, name)
+					updated = true
+				}
+			}
+			return updated
+		})
+
+		// finalizers 清空后 redis 可能已经被 k8s 删除
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("移除 finalizer 失败: %w", err)
 		}
-
-		controllerutil.RemoveFinalizer(redis, pod.Name)
-		isUpdated = true
 	}
 
-	if isUpdated {
-		return client.Update(ctx, redis)
-	}
-	return nil
+	return deleteErr
 }
 
-func DecreaseRedis2(ctx context.Context, client client.Client, redis *appv1.Redis) error {
-	isUpdated := false
-	for _, name := range redis.Finalizers[redis.Spec.Replicas:] {
-		pod, err := getPodFromK8s(ctx, client, redis.Namespace, name)
-		if err != nil {
-			return err
-		}
+// deletePod 删除 pod， pod 已经不存在时视为删除成功
+func deletePod(ctx context.Context, client client.Client, namespace string, name string) error {
 
-		if err := client.Delete(ctx, pod); err != nil {
-			return err
-		}
+	logger := log.FromContext(ctx)
 
-		controllerutil.RemoveFinalizer(redis, name)
-		isUpdated = true
-	}
+	pod := &corev1.Pod{}
+	pod.Name = name
+	pod.Namespace = namespace
 
-	if isUpdated {
-		return client.Update(ctx, redis)
+	err := client.Delete(ctx, pod)
+	if apierrors.IsNotFound(err) {
+		logger.V(1).Info("pod already deleted", "pod", name)
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
+	metrics.Default.PodDeleted()
+	logger.Info("deleted pod", "pod", name)
 	return nil
 }
 
-// isPodExistInK8S 检测 pod 是否在 k8s 中存在
-//   true 为存在
-func isPodExistInK8S(ctx context.Context, client client.Client, namespace string, name string) bool {
-
-	_, err := getPodFromK8s(ctx, client, namespace, name)
-	return err == nil
-}
-
-// getPodFromK8s 从 k8s 中获取 pod
-func getPodFromK8s(ctx context.Context, client client.Client, namespace string, name string) (*corev1.Pod, error) {
+// CountReadyPods2 统计 redis 已就绪的 pod 数量
+func CountReadyPods2(ctx context.Context, c client.Client, redis *appv1.Redis) (int, error) {
 
-	key := types.NamespacedName{
-		Namespace: namespace,
-		Name:      name,
+	pods, err := ListPods2(ctx, c, redis)
+	if err != nil {
+		return 0, err
 	}
 
-	pod := &corev1.Pod{}
+	ready := 0
+	for _, pod := range pods {
+		if isPodReady(&pod) {
+			ready++
+		}
+	}
+	return ready, nil
+}
 
-	// 这里偷懒， 没有进行错误内容检测。
-	err := client.Get(ctx, key, pod)
-	return pod, err
+func isPodReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
 }
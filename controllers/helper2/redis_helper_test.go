@@ -0,0 +1,472 @@
+package helper2
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+	"github.com/tangx/k8s-operator-demo/controllers/fakeclient"
+)
+
+var errInjected = errors.New("injected failure")
+
+func newRedis(replicas int, finalizers ...string) *appv1.Redis {
+	return &appv1.Redis{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: appv1.GroupVersion.String(),
+			Kind:       "Redis",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "cache",
+			Namespace:  "default",
+			UID:        "redis-uid",
+			Finalizers: finalizers,
+		},
+		Spec: appv1.RedisSpec{
+			Replicas: replicas,
+			Port:     6379,
+			Image:    "redis:5-alpine",
+		},
+	}
+}
+
+// newPod 创建属于 newRedis 的 pod
+func newPod(name string) *corev1.Pod {
+	pod := newOrphanPod(name)
+	pod.OwnerReferences = []metav1.OwnerReference{
+		{
+			APIVersion: appv1.GroupVersion.String(),
+			Kind:       "Redis",
+			Name:       "cache",
+			UID:        "redis-uid",
+		},
+	}
+	return pod
+}
+
+// newOrphanPod 创建没有 OwnerReference 的 pod
+func newOrphanPod(name string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+	}
+}
+
+// newClient 创建注册了 pod 索引的 fake client
+func newClient(t *testing.T, objs ...client.Object) *fakeclient.Client {
+	t.Helper()
+
+	c := fakeclient.New(objs...)
+	if err := SetupIndexes(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// stored 返回 fake client 中保存的 redis
+func stored(t *testing.T, c client.Client) *appv1.Redis {
+	t.Helper()
+
+	redis := &appv1.Redis{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "cache"}, redis); err != nil {
+		t.Fatal(err)
+	}
+	return redis
+}
+
+func podNames(t *testing.T, c client.Client) []string {
+	t.Helper()
+
+	pods := &corev1.PodList{}
+	if err := c.List(context.Background(), pods, client.InNamespace("default")); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, pod := range pods.Items {
+		names = append(names, pod.Name)
+	}
+	return names
+}
+
+func assertStrings(t *testing.T, what string, got []string, want ...string) {
+	t.Helper()
+
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s = %v, want %v", what, got, want)
+	}
+}
+
+func TestCreateRedisPod2(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(2)
+	c := newClient(t, redis)
+
+	recreated, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertStrings(t, "recreated", recreated)
+	assertStrings(t, "pods", podNames(t, c), "cache-0", "cache-1")
+	assertStrings(t, "finalizers", stored(t, c).Finalizers, "cache-0", "cache-1")
+}
+
+// 一次 List 取得全部 pod， 不再逐个 Get
+func TestCreateRedisPod2ListsOnce(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(3, "cache-0", "cache-1", "cache-2")
+	c := newClient(t, redis, newPod("cache-0"), newPod("cache-1"), newPod("cache-2"), newOrphanPod("other"))
+
+	if _, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.Calls(fakeclient.List); n != 1 {
+		t.Fatalf("expected a single list, got %d", n)
+	}
+	if n := c.Calls(fakeclient.Get); n != 0 {
+		t.Fatalf("expected no per pod reads, got %d", n)
+	}
+}
+
+func TestCreateRedisPod2RecreatesMissingPod(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(2, "cache-0", "cache-1")
+	c := newClient(t, redis, newPod("cache-0"))
+
+	recreated, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertStrings(t, "recreated", recreated, "cache-1")
+	assertStrings(t, "pods", podNames(t, c), "cache-0", "cache-1")
+	if n := c.Calls(fakeclient.Patch); n != 0 {
+		t.Fatalf("finalizers unchanged, expected no patch, got %d", n)
+	}
+}
+
+// 第二个 pod 创建失败时， 第一个 pod 的 finalizer 仍然要保存
+func TestCreateRedisPod2PartialCreateFailure(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(3)
+	c := newClient(t, redis).FailOn(fakeclient.Apply, 2, errInjected)
+
+	_, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme())
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+
+	assertStrings(t, "pods", podNames(t, c), "cache-0")
+	assertStrings(t, "finalizers", stored(t, c).Finalizers, "cache-0")
+
+	// 下一次调谐补齐剩余 pod
+	redis = stored(t, c)
+	if _, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, "pods", podNames(t, c), "cache-0", "cache-1", "cache-2")
+	assertStrings(t, "finalizers", stored(t, c).Finalizers, "cache-0", "cache-1", "cache-2")
+}
+
+// pod 已经创建， 但是 finalizer 更新失败， 下一次调谐时补上 finalizer
+func TestCreateRedisPod2FinalizerUpdateFailure(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(2)
+	c := newClient(t, redis).FailOn(fakeclient.Patch, 1, errInjected)
+
+	_, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme())
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	assertStrings(t, "pods", podNames(t, c), "cache-0", "cache-1")
+	assertStrings(t, "finalizers", stored(t, c).Finalizers)
+
+	redis = stored(t, c)
+	if _, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, "finalizers", stored(t, c).Finalizers, "cache-0", "cache-1")
+	assertStrings(t, "pods", podNames(t, c), "cache-0", "cache-1")
+}
+
+func TestCreateRedisPod2RetriesConflict(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1)
+	conflict := apierrors.NewConflict(schema.GroupResource{Group: "myapp.tangx.in", Resource: "redis"}, "cache", errInjected)
+	c := newClient(t, redis).FailOn(fakeclient.Patch, 1, conflict)
+
+	if _, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, "finalizers", stored(t, c).Finalizers, "cache-0")
+	if n := c.Calls(fakeclient.Patch); n != 2 {
+		t.Fatalf("expected patch to be retried once, got %d patches", n)
+	}
+}
+
+// 更新 finalizer 不丢失本次调谐中还没有保存的 status
+func TestCreateRedisPod2KeepsPendingStatus(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1)
+	conflict := apierrors.NewConflict(schema.GroupResource{Group: "myapp.tangx.in", Resource: "redis"}, "cache", errInjected)
+	c := newClient(t, redis).FailOn(fakeclient.Patch, 1, conflict)
+
+	redis.Status.Conditions = []metav1.Condition{{Type: appv1.ConditionPaused, Status: metav1.ConditionFalse, Reason: "Reconciling"}}
+	if _, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, "finalizers", redis.Finalizers, "cache-0")
+	if len(redis.Status.Conditions) != 1 || redis.Status.Conditions[0].Type != appv1.ConditionPaused {
+		t.Fatalf("conditions = %v, pending status was dropped", redis.Status.Conditions)
+	}
+}
+
+// finalizer 通过 patch 更新， 不覆盖其他客户端对 redis 的修改
+func TestCreateRedisPod2KeepsConcurrentChanges(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t, newRedis(1))
+
+	// 本地的 redis 在其他客户端修改之前读取， resourceVersion 已经过期
+	redis := stored(t, c)
+	other := stored(t, c)
+	other.Labels = map[string]string{"touched": "true"}
+	if err := c.Update(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	latest := stored(t, c)
+	assertStrings(t, "finalizers", latest.Finalizers, "cache-0")
+	if latest.Labels["touched"] != "true" {
+		t.Fatalf("labels = %v, concurrent change was overwritten", latest.Labels)
+	}
+}
+
+// 已存在的 pod 同样 apply， 修正被外部修改的标签
+func TestCreateRedisPod2CorrectsDrift(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1, "cache-0")
+	pod := newPod("cache-0")
+	pod.Labels = map[string]string{builder.LabelInstance: "other"}
+	c := newClient(t, redis, pod)
+
+	if _, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(pod), pod); err != nil {
+		t.Fatal(err)
+	}
+	if pod.Labels[builder.LabelInstance] != "cache" {
+		t.Fatalf("pod labels = %v", pod.Labels)
+	}
+}
+
+func TestCreateRedisPod2ApplyConflict(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1)
+	conflict := apierrors.NewApplyConflict(nil, "conflict with \"kubectl\"")
+	c := newClient(t, redis).FailOn(fakeclient.Apply, 1, conflict)
+
+	_, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme())
+	if !IsApplyConflict(err) {
+		t.Fatalf("expected apply conflict, got %v", err)
+	}
+	if !apierrors.IsConflict(err) {
+		t.Fatalf("apply conflict must unwrap to the api error, got %v", err)
+	}
+}
+
+// 已存在的 pod 修改不可变字段失败时保留现有 pod
+func TestCreateRedisPod2KeepsPodWithImmutableChange(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1, "cache-0")
+	invalid := apierrors.NewInvalid(schema.GroupKind{Kind: "Pod"}, "cache-0", nil)
+	c := newClient(t, redis, newPod("cache-0")).FailOn(fakeclient.Apply, 1, invalid)
+
+	if _, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, "pods", podNames(t, c), "cache-0")
+}
+
+// 属于 redis 的 pod 缺少分片标签时补上
+func TestCreateRedisPod2LabelsOwnedPod(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1)
+	redis.Labels = map[string]string{"shard": "a"}
+	c := newClient(t, redis, newPod("cache-0"))
+
+	if _, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "cache-0"}, pod); err != nil {
+		t.Fatal(err)
+	}
+	if pod.Labels["shard"] != "a" || pod.Labels[builder.LabelInstance] != "cache" {
+		t.Fatalf("pod labels = %v", pod.Labels)
+	}
+	assertStrings(t, "finalizers", stored(t, c).Finalizers, "cache-0")
+}
+
+// 同名 pod 不属于 redis 时跳过， 不接管也不添加 finalizer， 其余 pod 照常创建
+func TestCreateRedisPod2SkipsPodNotOwned(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(3)
+	stale := newPod("cache-0")
+	stale.OwnerReferences[0].UID = "old-redis-uid"
+	c := newClient(t, redis, stale, newOrphanPod("cache-1"))
+
+	_, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme())
+	if !IsNotOwned(err) {
+		t.Fatalf("err = %v, want NotOwnedError", err)
+	}
+	assertStrings(t, "pods", podNames(t, c), "cache-0", "cache-1", "cache-2")
+	for _, name := range []string{"cache-0", "cache-1"} {
+		pod := &corev1.Pod{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, pod); err != nil {
+			t.Fatal(err)
+		}
+		if OwnedBy(pod, redis.UID) {
+			t.Fatalf("pod %s adopted: %v", name, pod.OwnerReferences)
+		}
+	}
+	assertStrings(t, "finalizers", stored(t, c).Finalizers, "cache-2")
+}
+
+func TestDeleteRedis2(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(2, "cache-0", "cache-1")
+	c := newClient(t, redis, newPod("cache-0"), newPod("cache-1"))
+
+	if err := DeleteRedis2(ctx, c, redis); err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, "pods", podNames(t, c))
+	assertStrings(t, "finalizers", stored(t, c).Finalizers)
+}
+
+func TestDeleteRedis2MissingPod(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(2, "cache-0", "cache-1")
+	c := newClient(t, redis, newPod("cache-1"))
+
+	if err := DeleteRedis2(ctx, c, redis); err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, "pods", podNames(t, c))
+	assertStrings(t, "finalizers", stored(t, c).Finalizers)
+}
+
+// 第二个 pod 删除失败时， 第一个 pod 的 finalizer 仍然要移除
+func TestDeleteRedis2PartialFailure(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(2, "cache-0", "cache-1")
+	c := newClient(t, redis, newPod("cache-0"), newPod("cache-1")).
+		FailOn(fakeclient.Delete, 2, errInjected)
+
+	err := DeleteRedis2(ctx, c, redis)
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	assertStrings(t, "pods", podNames(t, c), "cache-1")
+	assertStrings(t, "finalizers", stored(t, c).Finalizers, "cache-1")
+}
+
+func TestDecreaseRedis2(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1, "cache-0", "cache-1", "cache-2")
+	c := newClient(t, redis, newPod("cache-0"), newPod("cache-2"))
+
+	if err := DecreaseRedis2(ctx, c, redis); err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, "pods", podNames(t, c), "cache-0")
+	assertStrings(t, "finalizers", stored(t, c).Finalizers, "cache-0")
+}
+
+func TestDecreaseRedis2FinalizerUpdateFailure(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1, "cache-0", "cache-1")
+	c := newClient(t, redis, newPod("cache-0"), newPod("cache-1")).
+		FailOn(fakeclient.Patch, 1, errInjected)
+
+	if err := DecreaseRedis2(ctx, c, redis); !errors.Is(err, errInjected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	assertStrings(t, "pods", podNames(t, c), "cache-0")
+	assertStrings(t, "finalizers", stored(t, c).Finalizers, "cache-0", "cache-1")
+
+	// 重试时 pod 已经不存在， 仍然可以移除 finalizer
+	redis = stored(t, c)
+	if err := DecreaseRedis2(ctx, c, redis); err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, "finalizers", stored(t, c).Finalizers, "cache-0")
+}
+
+// apply 只维护 redis 自己的 OwnerReference， 保留其他 owner
+func TestCreateRedisPod2KeepsOtherOwners(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1, "cache-0")
+	pod := newPod("cache-0")
+	pod.OwnerReferences = append(pod.OwnerReferences, metav1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Name:       "backup",
+		UID:        "backup-uid",
+	})
+	c := newClient(t, redis, pod)
+
+	if _, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &corev1.Pod{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "cache-0"}, got); err != nil {
+		t.Fatal(err)
+	}
+	var uids []string
+	for _, owner := range got.OwnerReferences {
+		uids = append(uids, string(owner.UID))
+	}
+	assertStrings(t, "owner uids", uids, "redis-uid", "backup-uid")
+}
+
+// 只有 OwnerReference 指向同一个 redis UID 的 pod 属于该 redis， 同名重建的 redis 不拥有旧 pod
+func TestOwnedBy(t *testing.T) {
+	other := newPod("cache-0")
+	other.OwnerReferences[0].UID = "old-redis-uid"
+
+	for _, tc := range []struct {
+		name string
+		pod  *corev1.Pod
+		want bool
+	}{
+		{name: "owned", pod: newPod("cache-0"), want: true},
+		{name: "other uid", pod: other, want: false},
+		{name: "no owner", pod: newOrphanPod("cache-0"), want: false},
+	} {
+		if got := OwnedBy(tc.pod, "redis-uid"); got != tc.want {
+			t.Errorf("%s: OwnedBy = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
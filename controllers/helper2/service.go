@@ -0,0 +1,35 @@
+package helper2
+
+import (
+	"context"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+	"github.com/tangx/k8s-operator-demo/controllers/metrics"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ApplyRedisService2 通过 server-side apply 创建或更新 redis service， 修正被外部修改的字段。
+// 同名 service 不属于当前 redis 时返回 NotOwnedError， 不会接管。
+// reader 读取 cache 中查不到的 service， 通常是 manager 的 APIReader。
+func ApplyRedisService2(ctx context.Context, client client.Client, reader client.Reader, redis *appv1.Redis, scheme *runtime.Scheme) error {
+
+	svc, err := builder.Service(redis, scheme)
+	if err != nil {
+		return err
+	}
+	current, err := checkOwner(ctx, scheme, svc, redis, client, reader)
+	if err != nil {
+		return err
+	}
+	if err := apply(ctx, client, svc, scheme); err != nil {
+		return err
+	}
+
+	// redis 已经创建过 pod， 说明 service 是被外部删除后重建的
+	if current == nil && len(redis.Finalizers) > 0 {
+		metrics.Default.DriftCorrected("service")
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package helper2
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tangx/k8s-operator-demo/controllers/fakeclient"
+)
+
+func getService(t *testing.T, c client.Client) *corev1.Service {
+	t.Helper()
+
+	svc := &corev1.Service{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "cache"}, svc); err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+func TestApplyRedisService2(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1)
+	c := newClient(t, redis)
+
+	if err := ApplyRedisService2(ctx, c, c, redis, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	if svc := getService(t, c); !OwnedBy(svc, redis.UID) {
+		t.Fatalf("service owner references = %v", svc.OwnerReferences)
+	}
+}
+
+// 同名 service 属于其他对象时不接管
+func TestApplyRedisService2NotOwned(t *testing.T) {
+	ctx := context.Background()
+	redis := newRedis(1)
+	other := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cache",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "other"},
+		},
+	}
+	c := newClient(t, redis, other)
+
+	err := ApplyRedisService2(ctx, c, c, redis, c.Scheme())
+	if !IsNotOwned(err) {
+		t.Fatalf("err = %v, want NotOwnedError", err)
+	}
+	svc := getService(t, c)
+	if len(svc.OwnerReferences) != 0 || svc.Labels["app"] != "other" {
+		t.Fatalf("service was modified: %+v", svc.ObjectMeta)
+	}
+	if c.Calls(fakeclient.Apply) != 0 {
+		t.Fatal("service applied")
+	}
+}
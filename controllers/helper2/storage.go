@@ -0,0 +1,37 @@
+package helper2
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+)
+
+// createClaim 创建 pod 数据目录使用的 PVC， 已经存在时保持不变， PVC 的大部分字段创建后不能修改。
+// PVC 没有 OwnerReference， 通过标签确认属于同名 redis， 属于其他对象时返回 NotOwnedError。
+// PVC 不在 manager 的 cache 中， reader 通常是 manager 的 APIReader。
+func createClaim(ctx context.Context, c client.Client, reader client.Reader, redis *appv1.Redis, podName string) error {
+	pvc := builder.PersistentVolumeClaim(redis, podName)
+
+	existing := &corev1.PersistentVolumeClaim{}
+	err := reader.Get(ctx, client.ObjectKeyFromObject(pvc), existing)
+	if err == nil {
+		if existing.Labels[builder.LabelInstance] != redis.Name || existing.Labels[builder.LabelManagedBy] != builder.ManagedBy {
+			return &NotOwnedError{Object: "PersistentVolumeClaim/" + pvc.Name}
+		}
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	err = c.Create(ctx, pvc)
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
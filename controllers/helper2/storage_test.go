@@ -0,0 +1,70 @@
+package helper2
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+func newStorageRedis(replicas int) *appv1.Redis {
+	redis := newRedis(replicas)
+	redis.Spec.Storage = &appv1.RedisStorage{Size: resource.MustParse("1Gi")}
+	return redis
+}
+
+func claimNames(t *testing.T, c client.Client) []string {
+	t.Helper()
+
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	if err := c.List(context.Background(), pvcs, client.InNamespace("default")); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pvc := range pvcs.Items {
+		names = append(names, pvc.Name)
+	}
+	return names
+}
+
+func TestCreateRedisPod2CreatesClaims(t *testing.T) {
+	ctx := context.Background()
+	redis := newStorageRedis(2)
+	c := newClient(t, redis)
+
+	if _, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, "claims", claimNames(t, c), "data-cache-0", "data-cache-1")
+	assertStrings(t, "pods", podNames(t, c), "cache-0", "cache-1")
+
+	pod := &corev1.Pod{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "cache-0"}, pod); err != nil {
+		t.Fatal(err)
+	}
+	if len(pod.Spec.Volumes) != 1 || pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName != "data-cache-0" {
+		t.Fatalf("pod volumes = %+v", pod.Spec.Volumes)
+	}
+}
+
+// 同名 PVC 属于其他对象时不创建对应的 pod
+func TestCreateRedisPod2SkipsClaimNotOwned(t *testing.T) {
+	ctx := context.Background()
+	redis := newStorageRedis(2)
+	other := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data-cache-0", Namespace: "default"},
+	}
+	c := newClient(t, redis, other)
+
+	_, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme())
+	if !IsNotOwned(err) {
+		t.Fatalf("err = %v, want NotOwnedError", err)
+	}
+	assertStrings(t, "pods", podNames(t, c), "cache-1")
+	assertStrings(t, "finalizers", stored(t, c).Finalizers, "cache-1")
+}
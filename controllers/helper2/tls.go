@@ -0,0 +1,343 @@
+package helper2
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+	"github.com/tangx/k8s-operator-demo/controllers/certs"
+	"github.com/tangx/k8s-operator-demo/controllers/resp"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// CASecretName operator 在每个 namespace 中使用的 CA secret。
+// CA 由同一个 namespace 中没有指定 issuerRef 的 redis 共用， 不属于任何 redis。
+const CASecretName = "redis-operator-ca"
+
+// EnsureTLS2 确保 redis 的证书已经签发， 返回当前证书的状态。
+// 使用 cert-manager 时证书由 cert-manager 异步签发， 还没有签发时返回 nil。
+// 同名 Certificate 或者 operator 签发证书使用的 secret 不属于当前 redis 时返回 NotOwnedError。
+// secret 不在 manager 的 cache 中， secrets 通常是 manager 的 APIReader。
+func EnsureTLS2(ctx context.Context, c client.Client, secrets client.Reader, redis *appv1.Redis, scheme *runtime.Scheme) (*appv1.TLSStatus, error) {
+
+	if redis.Spec.TLS.IssuerRef != nil {
+		cert, err := builder.Certificate(redis, scheme)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := checkOwner(ctx, scheme, cert, redis, c); err != nil {
+			return nil, err
+		}
+		if err := apply(ctx, c, cert, scheme); err != nil {
+			return nil, fmt.Errorf("apply Certificate 失败: %w", err)
+		}
+	} else if err := issueCertificate(ctx, c, secrets, redis, scheme); err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: redis.Namespace, Name: builder.TLSSecretName(redis)}
+	err := secrets.Get(ctx, key, secret)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取证书 secret 失败: %w", err)
+	}
+	certPEM := secret.Data[corev1.TLSCertKey]
+	if len(certPEM) == 0 || len(secret.Data[corev1.TLSPrivateKeyKey]) == 0 {
+		return nil, nil
+	}
+
+	status := &appv1.TLSStatus{
+		SecretName:      secret.Name,
+		CertificateHash: certs.Hash(certPEM),
+	}
+	if cert, err := certs.ParseCert(certPEM); err == nil {
+		notAfter := metav1.NewTime(cert.NotAfter)
+		status.NotAfter = &notAfter
+	}
+	return status, nil
+}
+
+// issueCertificate 使用 operator 的 CA 签发证书， 证书即将过期或 CA 变化时重新签发
+func issueCertificate(ctx context.Context, c client.Client, secrets client.Reader, redis *appv1.Redis, scheme *runtime.Scheme) error {
+
+	ca, err := ensureCA(ctx, c, secrets, redis.Namespace)
+	if err != nil {
+		return err
+	}
+
+	hosts := builder.TLSHosts(redis)
+	now := time.Now()
+
+	secret := &corev1.Secret{}
+	secret.Name = builder.TLSSecretName(redis)
+	secret.Namespace = redis.Namespace
+	// 不覆盖用户创建的同名 secret
+	existing, err := checkOwner(ctx, scheme, secret, redis, secrets)
+	if err != nil {
+		return fmt.Errorf("获取证书 secret 失败: %w", err)
+	}
+	if current, ok := existing.(*corev1.Secret); ok && string(current.Data[builder.TLSCAKey]) == string(ca.Cert) &&
+		!certs.NeedsRenewal(current.Data[corev1.TLSCertKey], hosts, now) {
+		return nil
+	}
+
+	pair, err := certs.Issue(ca, hosts, now)
+	if err != nil {
+		return err
+	}
+
+	secret.Labels = builder.SelectorLabels(redis)
+	secret.Type = corev1.SecretTypeTLS
+	secret.Data = map[string][]byte{
+		corev1.TLSCertKey:       pair.Cert,
+		corev1.TLSPrivateKeyKey: pair.Key,
+		builder.TLSCAKey:        ca.Cert,
+	}
+	if err := controllerutil.SetOwnerReference(redis, secret, scheme); err != nil {
+		return err
+	}
+	if err := apply(ctx, c, secret, scheme); err != nil {
+		return fmt.Errorf("apply 证书 secret 失败: %w", err)
+	}
+	log.FromContext(ctx).Info("issued certificate", "secret", secret.Name)
+	return nil
+}
+
+// ensureCA 读取 namespace 中的 CA， 不存在时生成
+func ensureCA(ctx context.Context, c client.Client, secrets client.Reader, namespace string) (*certs.KeyPair, error) {
+
+	key := client.ObjectKey{Namespace: namespace, Name: CASecretName}
+	secret := &corev1.Secret{}
+	err := secrets.Get(ctx, key, secret)
+	if apierrors.IsNotFound(err) {
+		ca, genErr := certs.NewCA(fmt.Sprintf("redis-operator-ca.%s", namespace), time.Now())
+		if genErr != nil {
+			return nil, genErr
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      CASecretName,
+				Namespace: namespace,
+				Labels:    map[string]string{builder.LabelManagedBy: builder.ManagedBy},
+			},
+			Type: corev1.SecretTypeTLS,
+			Data: map[string][]byte{
+				corev1.TLSCertKey:       ca.Cert,
+				corev1.TLSPrivateKeyKey: ca.Key,
+			},
+		}
+		// 多个 redis 同时调谐时只有一个能创建成功， 其余重新读取
+		err = c.Create(ctx, secret)
+		if err == nil {
+			log.FromContext(ctx).Info("created certificate authority", "secret", CASecretName)
+			return ca, nil
+		}
+		if !apierrors.IsAlreadyExists(err) {
+			return nil, fmt.Errorf("创建 CA secret 失败: %w", err)
+		}
+		err = secrets.Get(ctx, key, secret)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取 CA secret 失败: %w", err)
+	}
+
+	ca := &certs.KeyPair{
+		Cert: secret.Data[corev1.TLSCertKey],
+		Key:  secret.Data[corev1.TLSPrivateKeyKey],
+	}
+	if _, err := certs.ParseCert(ca.Cert); err != nil {
+		return nil, fmt.Errorf("CA secret (%s) 不合法: %w", CASecretName, err)
+	}
+	return ca, nil
+}
+
+// RotateStep RotatePod2 一次调用的结果
+type RotateStep struct {
+	// Outdated 还没有使用当前证书的 pod 数量
+	Outdated int
+	// Reloaded 本次原地重新加载了证书的 pod
+	Reloaded []string
+	// Deleted 为了应用 TLS 变化删除的 pod， 只有设置了 spec.tls.recreatePods 时才会删除
+	Deleted string
+	// Blocked 需要重建才能应用 TLS 变化、 但不允许重建的 pod
+	Blocked []string
+}
+
+// RotatePod2 让 pod 使用 redis 当前的证书。
+// 证书 secret 整体挂载， 证书更新后 kubelet 原地更新 volume 中的文件，
+// 通过 CONFIG SET tls-cert-file 让 redis 重新加载， 确认 pod 出示新证书后更新 pod 上的证书 hash。
+// kubelet 同步 volume 有延迟， 还没有加载到新证书的 pod 下次重试。
+// 开启或关闭 TLS 需要修改启动参数和 volume， 只有设置了 spec.tls.recreatePods 时才逐个删除 pod，
+// 其余 pod 全部就绪后才继续。
+func RotatePod2(ctx context.Context, c client.Client, secrets client.Reader, redis *appv1.Redis) (RotateStep, error) {
+	var step RotateStep
+
+	pods, err := ListPods2(ctx, c, redis)
+	if err != nil {
+		return step, err
+	}
+
+	hash := redis.TLSCertificateHash()
+	var recreate []*corev1.Pod
+	waiting := false
+	for i := range pods {
+		pod := &pods[i]
+		if !pod.DeletionTimestamp.IsZero() {
+			waiting = true
+			continue
+		}
+		current := pod.Annotations[appv1.TLSHashAnnotation]
+		if current == hash {
+			if !isPodReady(pod) {
+				waiting = true
+			}
+			continue
+		}
+
+		// 创建时没有开启 TLS， 或者已经关闭了 TLS 的 pod
+		if current == "" || hash == "" {
+			step.Outdated++
+			recreate = append(recreate, pod)
+			continue
+		}
+
+		reloaded, err := reloadCertificate(ctx, secrets, redis, pod)
+		if err != nil {
+			return step, fmt.Errorf("pod (%s) 重新加载证书失败: %w", pod.Name, err)
+		}
+		if !reloaded {
+			step.Outdated++
+			continue
+		}
+		patch := client.MergeFrom(pod.DeepCopy())
+		metav1.SetMetaDataAnnotation(&pod.ObjectMeta, appv1.TLSHashAnnotation, hash)
+		if err := c.Patch(ctx, pod, patch, client.FieldOwner(FieldManager)); err != nil {
+			return step, fmt.Errorf("更新 pod (%s) 的证书 hash 失败: %w", pod.Name, err)
+		}
+		log.FromContext(ctx).Info("reloaded certificate", "pod", pod.Name)
+		step.Reloaded = append(step.Reloaded, pod.Name)
+	}
+
+	if len(recreate) == 0 {
+		return step, nil
+	}
+	if redis.Spec.TLS == nil || !redis.Spec.TLS.RecreatePods {
+		for _, pod := range recreate {
+			step.Blocked = append(step.Blocked, pod.Name)
+		}
+		return step, nil
+	}
+	// 上一个 pod 还在删除或者没有就绪， 等待
+	if waiting {
+		return step, nil
+	}
+
+	// 带上 UID 避免删除同名的新 pod
+	outdated := recreate[0]
+	err = c.Delete(ctx, outdated, client.Preconditions{UID: &outdated.UID})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return step, fmt.Errorf("删除 pod (%s) 失败: %w", outdated.Name, err)
+	}
+	log.FromContext(ctx).Info("recreating pod for TLS change", "pod", outdated.Name)
+	step.Deleted = outdated.Name
+	return step, nil
+}
+
+// reloadCertificate 让 pod 重新加载证书 volume 中的文件， 返回 pod 是否已经出示 secret 中的证书。
+// pod 没有运行或者 volume 还没有更新时返回 false， 稍后重试。
+func reloadCertificate(ctx context.Context, secrets client.Reader, redis *appv1.Redis, pod *corev1.Pod) (bool, error) {
+	if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+		return false, nil
+	}
+
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: redis.Namespace, Name: builder.TLSSecretName(redis)}
+	if err := secrets.Get(ctx, key, secret); err != nil {
+		return false, fmt.Errorf("获取证书 secret 失败: %w", err)
+	}
+	leaf, err := certs.ParseCert(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return false, err
+	}
+	password, err := Password(ctx, secrets, redis)
+	if err != nil {
+		return false, err
+	}
+	config, err := tlsConfig(ctx, secrets, redis)
+	if err != nil {
+		return false, err
+	}
+
+	// servesCertificate 新建连接检查 pod 出示的证书
+	servesCertificate := func() (bool, error) {
+		conn, err := dialPod(ctx, redis, pod, password, config)
+		if err != nil {
+			return false, err
+		}
+		defer conn.Close()
+		peer := conn.PeerCertificates()
+		return len(peer) > 0 && bytes.Equal(peer[0].Raw, leaf.Raw), nil
+	}
+
+	// pod 重启后已经加载了新证书
+	if ok, err := servesCertificate(); err != nil || ok {
+		return ok, err
+	}
+
+	conn, err := dialPod(ctx, redis, pod, password, config)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	// tls-cert-file 不变， 设置后 redis 重新读取证书、 私钥和 CA 文件
+	_, err = conn.Do("CONFIG", "SET", "tls-cert-file", builder.TLSMountPath+"/"+corev1.TLSCertKey)
+	var rerr resp.Error
+	if errors.As(err, &rerr) {
+		// kubelet 更新 volume 的过程中证书和私钥可能不匹配
+		log.FromContext(ctx).V(1).Info("unable to reload certificate, will retry", "pod", pod.Name, "reason", rerr.Error())
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return servesCertificate()
+}
+
+// tlsConfig 返回 operator 连接 redis 使用的 TLS 配置， 没有开启 TLS 时返回 nil。
+// operator 通过 pod IP 连接， 使用 service 域名校验证书。
+func tlsConfig(ctx context.Context, secrets client.Reader, redis *appv1.Redis) (*tls.Config, error) {
+	if !redis.TLSEnabled() {
+		return nil, nil
+	}
+
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: redis.Namespace, Name: builder.TLSSecretName(redis)}
+	if err := secrets.Get(ctx, key, secret); err != nil {
+		return nil, fmt.Errorf("获取证书 secret 失败: %w", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(secret.Data[builder.TLSCAKey]) {
+		return nil, errors.New("证书 secret 中没有 CA 证书")
+	}
+	return &tls.Config{
+		RootCAs:    roots,
+		ServerName: builder.TLSHosts(redis)[0],
+	}, nil
+}
@@ -0,0 +1,348 @@
+package helper2
+
+import (
+	"context"
+	"crypto/tls"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+	"github.com/tangx/k8s-operator-demo/controllers/certs"
+	"github.com/tangx/k8s-operator-demo/controllers/resp/resptest"
+)
+
+func newTLSRedis(replicas int, finalizers ...string) *appv1.Redis {
+	redis := newRedis(replicas, finalizers...)
+	redis.Spec.Image = "redis:6-alpine"
+	redis.Spec.TLS = &appv1.RedisTLS{Enabled: true}
+	return redis
+}
+
+func getSecret(t *testing.T, c client.Client, name string) *corev1.Secret {
+	t.Helper()
+
+	secret := &corev1.Secret{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: name}, secret); err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+func TestEnsureTLS2OperatorCA(t *testing.T) {
+	ctx := context.Background()
+	redis := newTLSRedis(1)
+	c := newClient(t, redis)
+
+	status, err := EnsureTLS2(ctx, c, c, redis, c.Scheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || status.SecretName != "cache-tls" || status.CertificateHash == "" || status.NotAfter == nil {
+		t.Fatalf("status = %+v", status)
+	}
+
+	ca := getSecret(t, c, CASecretName)
+	secret := getSecret(t, c, "cache-tls")
+	if string(secret.Data[builder.TLSCAKey]) != string(ca.Data[corev1.TLSCertKey]) {
+		t.Fatal("certificate secret must contain the operator CA")
+	}
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].UID != redis.UID {
+		t.Fatalf("owner references = %v", secret.OwnerReferences)
+	}
+
+	// 证书没有过期时不重新签发
+	again, err := EnsureTLS2(ctx, c, c, redis, c.Scheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.CertificateHash != status.CertificateHash {
+		t.Fatal("certificate must not be reissued before renewal")
+	}
+
+	// 同一个 namespace 中的 redis 共用 CA
+	other := newTLSRedis(1)
+	other.Name = "other"
+	other.UID = "other-uid"
+	if _, err := EnsureTLS2(ctx, c, c, other, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	if string(getSecret(t, c, "other-tls").Data[builder.TLSCAKey]) != string(ca.Data[corev1.TLSCertKey]) {
+		t.Fatal("redis in the same namespace must share the operator CA")
+	}
+}
+
+func TestEnsureTLS2CertManager(t *testing.T) {
+	ctx := context.Background()
+	redis := newTLSRedis(1)
+	redis.Spec.TLS.IssuerRef = &appv1.IssuerReference{Name: "redis-ca", Kind: "ClusterIssuer"}
+	c := newClient(t, redis)
+
+	// cert-manager 还没有签发证书
+	status, err := EnsureTLS2(ctx, c, c, redis, c.Scheme())
+	if err != nil || status != nil {
+		t.Fatalf("EnsureTLS2 = %+v, %v; want nil while the certificate is pending", status, err)
+	}
+
+	cert := &unstructured.Unstructured{}
+	cert.SetGroupVersionKind(builder.CertificateGVK)
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "cache"}, cert); err != nil {
+		t.Fatal(err)
+	}
+	if name, _, _ := unstructured.NestedString(cert.Object, "spec", "secretName"); name != "cache-tls" {
+		t.Fatalf("secretName = %q", name)
+	}
+	if _, err := getSecretOrNil(c, CASecretName); !apierrors.IsNotFound(err) {
+		t.Fatalf("operator CA must not be created for cert-manager certificates, err = %v", err)
+	}
+
+	pair, _ := issue(t, "cache.default.svc")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache-tls", Namespace: "default"},
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       pair.Cert,
+			corev1.TLSPrivateKeyKey: pair.Key,
+		},
+	}
+	if err := c.Create(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	status, err = EnsureTLS2(ctx, c, c, redis, c.Scheme())
+	if err != nil || status == nil || status.CertificateHash != certs.Hash(pair.Cert) {
+		t.Fatalf("EnsureTLS2 = %+v, %v", status, err)
+	}
+}
+
+// 同名 Certificate 或证书 secret 属于其他对象时不覆盖
+func TestEnsureTLS2NotOwned(t *testing.T) {
+	ctx := context.Background()
+	redis := newTLSRedis(1)
+	userSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache-tls", Namespace: "default"},
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("user")},
+	}
+	c := newClient(t, redis, userSecret)
+
+	if _, err := EnsureTLS2(ctx, c, c, redis, c.Scheme()); !IsNotOwned(err) {
+		t.Fatalf("err = %v, want NotOwnedError", err)
+	}
+	if got := string(getSecret(t, c, "cache-tls").Data[corev1.TLSCertKey]); got != "user" {
+		t.Fatalf("certificate = %q, the secret was overwritten", got)
+	}
+
+	redis.Spec.TLS.IssuerRef = &appv1.IssuerReference{Name: "redis-ca", Kind: "ClusterIssuer"}
+	cert := &unstructured.Unstructured{}
+	cert.SetGroupVersionKind(builder.CertificateGVK)
+	cert.SetName("cache")
+	cert.SetNamespace("default")
+	if err := c.Create(ctx, cert); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EnsureTLS2(ctx, c, c, redis, c.Scheme()); !IsNotOwned(err) {
+		t.Fatalf("err = %v, want NotOwnedError", err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(cert), cert); err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.GetOwnerReferences()) != 0 {
+		t.Fatalf("certificate was taken over: %v", cert.GetOwnerReferences())
+	}
+}
+
+func getSecretOrNil(c client.Client, name string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: name}, secret)
+	return secret, err
+}
+
+// issue 使用新的 CA 签发证书， 返回证书和 CA
+func issue(t *testing.T, hosts ...string) (*certs.KeyPair, *certs.KeyPair) {
+	t.Helper()
+
+	ca, err := certs.NewCA("test", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := certs.Issue(ca, hosts, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair, ca
+}
+
+// newReadyPod 创建已就绪、 使用 hash 对应证书的 pod
+func newReadyPod(name, hash string) *corev1.Pod {
+	pod := newPod(name)
+	pod.Labels = map[string]string{builder.LabelManagedBy: builder.ManagedBy}
+	pod.Annotations = map[string]string{appv1.TLSHashAnnotation: hash}
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	return pod
+}
+
+func TestRotatePod2RecreatesOnlyWhenAllowed(t *testing.T) {
+	ctx := context.Background()
+	redis := newTLSRedis(3, "cache-0", "cache-1", "cache-2")
+	redis.Status.TLS = &appv1.TLSStatus{SecretName: "cache-tls", CertificateHash: "new"}
+	// cache-1 和 cache-2 创建时没有开启 TLS
+	c := newClient(t, redis, newReadyPod("cache-0", "new"), newReadyPod("cache-1", ""), newReadyPod("cache-2", ""))
+
+	step, err := RotatePod2(ctx, c, c, redis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step.Deleted != "" || step.Outdated != 2 {
+		t.Fatalf("step = %+v, want no pod deleted without spec.tls.recreatePods", step)
+	}
+	assertStrings(t, "blocked", step.Blocked, "cache-1", "cache-2")
+	assertStrings(t, "pods", podNames(t, c), "cache-0", "cache-1", "cache-2")
+
+	redis.Spec.TLS.RecreatePods = true
+	step, err = RotatePod2(ctx, c, c, redis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step.Deleted != "cache-1" || len(step.Blocked) != 0 {
+		t.Fatalf("step = %+v, want cache-1 deleted", step)
+	}
+	assertStrings(t, "pods", podNames(t, c), "cache-0", "cache-2")
+
+	// 重建的 pod 没有就绪前不继续重建
+	pending := newReadyPod("cache-1", "new")
+	pending.Status.Conditions = nil
+	if err := c.Create(ctx, pending); err != nil {
+		t.Fatal(err)
+	}
+	if step, err := RotatePod2(ctx, c, c, redis); err != nil || step.Deleted != "" || step.Outdated != 1 {
+		t.Fatalf("RotatePod2 = %+v, %v; want to wait for cache-1", step, err)
+	}
+}
+
+func TestRotatePod2ReloadsCertificate(t *testing.T) {
+	ctx := context.Background()
+	ca, err := certs.NewCA("test", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pairs [2]tls.Certificate
+	var renewed *certs.KeyPair
+	for i := range pairs {
+		renewed, err = certs.Issue(ca, []string{"cache.default.svc"}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pairs[i], err = tls.X509KeyPair(renewed.Cert, renewed.Key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 收到 CONFIG SET 之前出示旧证书
+	var reloaded int32
+	server, err := resptest.NewTLSServer(func(args []string) string {
+		if args[0] == "CONFIG" {
+			atomic.StoreInt32(&reloaded, 1)
+		}
+		return resptest.OK
+	}, &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return &pairs[atomic.LoadInt32(&reloaded)], nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	redis := newTLSRedis(1, "cache-0")
+	redis.Spec.Port = server.Port()
+	redis.Status.TLS = &appv1.TLSStatus{SecretName: "cache-tls", CertificateHash: certs.Hash(renewed.Cert)}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache-tls", Namespace: "default"},
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       renewed.Cert,
+			corev1.TLSPrivateKeyKey: renewed.Key,
+			builder.TLSCAKey:        ca.Cert,
+		},
+	}
+	pod := newReadyPod("cache-0", "old")
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.PodIP = "127.0.0.1"
+	c := newClient(t, redis, secret, pod)
+
+	step, err := RotatePod2(ctx, c, c, redis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step.Deleted != "" || step.Outdated != 0 {
+		t.Fatalf("step = %+v, want the certificate reloaded in place", step)
+	}
+	assertStrings(t, "reloaded", step.Reloaded, "cache-0")
+	if atomic.LoadInt32(&reloaded) != 1 {
+		t.Fatal("CONFIG SET tls-cert-file was not sent")
+	}
+
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "cache-0"}, pod); err != nil {
+		t.Fatal(err)
+	}
+	if hash := pod.Annotations[appv1.TLSHashAnnotation]; hash != redis.Status.TLS.CertificateHash {
+		t.Fatalf("certificate hash = %q, want the renewed certificate", hash)
+	}
+}
+
+func TestCreateRedisPod2SkipsOutdatedPod(t *testing.T) {
+	ctx := context.Background()
+	redis := newTLSRedis(1, "cache-0")
+	redis.Status.TLS = &appv1.TLSStatus{SecretName: "cache-tls", CertificateHash: "new"}
+	c := newClient(t, redis, newReadyPod("cache-0", "old"))
+
+	if _, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "cache-0"}, pod); err != nil {
+		t.Fatal(err)
+	}
+	if hash := pod.Annotations[appv1.TLSHashAnnotation]; hash != "old" {
+		t.Fatalf("outdated pod must keep its certificate hash until rotated, got %q", hash)
+	}
+}
+
+func TestCountClients2TLS(t *testing.T) {
+	ctx := context.Background()
+	pair, ca := issue(t, "cache.default.svc")
+	cert, err := tls.X509KeyPair(pair.Cert, pair.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := resptest.NewTLSServer(func(args []string) string {
+		if args[1] == "ID" {
+			return resptest.Int(1)
+		}
+		return resptest.Bulk("id=1 flags=N\nid=2 flags=N\n")
+	}, &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	redis := newTLSRedis(1, "cache-0")
+	redis.Spec.Port = server.Port()
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache-tls", Namespace: "default"},
+		Data:       map[string][]byte{builder.TLSCAKey: ca.Cert},
+	}
+	c := newClient(t, redis, secret, newRunningPod("cache-0"))
+
+	clients, err := CountClients2(ctx, c, c, redis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clients != 1 {
+		t.Fatalf("clients = %d, want 1", clients)
+	}
+}
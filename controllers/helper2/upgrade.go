@@ -0,0 +1,99 @@
+package helper2
+
+import (
+	"context"
+	"fmt"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// UpgradeStep UpgradePod2 的执行结果
+type UpgradeStep struct {
+	// Outdated 镜像与期望不一致、 还没有升级的 pod 数量
+	Outdated int
+
+	// Completed 全部 pod 都已经使用期望的镜像并且就绪
+	Completed bool
+
+	// Deleted 被删除、 下次调谐时使用新镜像重建的 pod
+	Deleted string
+
+	// Blocked 升级会丢失数据、 需要人工处理的原因
+	Blocked string
+}
+
+// UpgradePod2 逐个重建镜像与期望不一致的 pod。
+// operator 创建的 pod 之间没有配置主从复制， 重建的 pod 无法从其他 pod 同步数据，
+// 因此只有使用持久化存储时才重建就绪的 pod， 重建后从 PVC 中的 RDB 恢复数据；
+// 没有持久化存储时返回 Blocked， 由用户手动删除 pod。
+// 每次只处理一个 pod， 其余 pod 全部就绪后才继续。
+func UpgradePod2(ctx context.Context, c client.Client, redis *appv1.Redis, versions appv1.VersionCatalog) (UpgradeStep, error) {
+	step := UpgradeStep{}
+
+	image, err := redis.RedisImage(versions)
+	if err != nil {
+		return step, err
+	}
+	pods, err := ListPods2(ctx, c, redis)
+	if err != nil {
+		return step, err
+	}
+
+	// 上一个 pod 还在删除、 还没有重建或者没有就绪， 等待
+	waiting := len(pods) < redis.Spec.Replicas
+	var outdated []*corev1.Pod
+	for i := range pods {
+		pod := &pods[i]
+		switch {
+		case !pod.DeletionTimestamp.IsZero():
+			waiting = true
+		case imageOutdated(redis, pod, image):
+			outdated = append(outdated, pod)
+		default:
+			waiting = waiting || !isPodReady(pod)
+		}
+	}
+	step.Outdated = len(outdated)
+	step.Completed = len(outdated) == 0 && !waiting
+	if waiting || len(outdated) == 0 {
+		return step, nil
+	}
+
+	var blocked []string
+	for _, pod := range outdated {
+		// 没有就绪的 pod 不提供服务， 直接升级
+		if !isPodReady(pod) || redis.PersistentStorage() {
+			return step, deleteOutdated(ctx, c, pod, &step)
+		}
+		blocked = append(blocked, pod.Name)
+	}
+	step.Blocked = fmt.Sprintf("Pods %v have no persistent storage, recreating them would lose their data; "+
+		"delete them manually to upgrade anyway", blocked)
+	return step, nil
+}
+
+// deleteOutdated 删除 pod， 由 CreateRedisPod2 使用新镜像重建
+func deleteOutdated(ctx context.Context, c client.Client, pod *corev1.Pod, step *UpgradeStep) error {
+	// 带上 UID 避免删除同名的新 pod
+	err := c.Delete(ctx, pod, client.Preconditions{UID: &pod.UID})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("删除 pod (%s) 失败: %w", pod.Name, err)
+	}
+	log.FromContext(ctx).Info("deleted pod for upgrade", "pod", pod.Name)
+	step.Deleted = pod.Name
+	return nil
+}
+
+// imageOutdated 判断 pod 中 redis 容器的镜像是否与期望不一致
+func imageOutdated(redis *appv1.Redis, pod *corev1.Pod, image string) bool {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == redis.Name {
+			return container.Image != image
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package helper2
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+const (
+	oldImage = "redis:6.2-alpine"
+	newImage = "redis:7.0-alpine"
+)
+
+// newUpgradeRedis 创建正在从 6.2 升级到 7.0 的 redis
+func newUpgradeRedis(replicas int, port int32, finalizers ...string) *appv1.Redis {
+	redis := newRedis(replicas, finalizers...)
+	redis.Spec.Image = ""
+	redis.Spec.Version = "7.0"
+	redis.Spec.Port = port
+	return redis
+}
+
+// newImagePod 创建运行指定镜像、 已经就绪的 pod
+func newImagePod(name, image string) *corev1.Pod {
+	pod := newReadyPod(name, "")
+	pod.Spec.Containers = []corev1.Container{{Name: "cache", Image: image}}
+	pod.Status.Phase = corev1.PodRunning
+	return pod
+}
+
+func exists(t *testing.T, c client.Client, name string) bool {
+	t.Helper()
+
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: name}, &corev1.Pod{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+// 没有主从复制， 就绪的 pod 只有使用持久化存储时才重建
+func TestUpgradePod2RequiresStorage(t *testing.T) {
+	ctx := context.Background()
+	redis := newUpgradeRedis(2, 6379, "cache-0", "cache-1")
+	notReady := newImagePod("cache-1", oldImage)
+	notReady.Status.Conditions = nil
+	c := newClient(t, redis, newImagePod("cache-0", oldImage), notReady)
+
+	// 没有就绪的 pod 不提供服务， 直接重建
+	step, err := UpgradePod2(ctx, c, redis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step.Deleted != "cache-1" || step.Blocked != "" {
+		t.Fatalf("step = %+v, want cache-1 recreated", step)
+	}
+
+	// 没有持久化存储时重建会丢失数据
+	c = newClient(t, redis, newImagePod("cache-0", oldImage), newImagePod("cache-1", newImage))
+	step, err = UpgradePod2(ctx, c, redis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step.Deleted != "" || step.Blocked == "" {
+		t.Fatalf("step = %+v, want the upgrade blocked", step)
+	}
+	if !exists(t, c, "cache-0") {
+		t.Fatal("a ready pod without persistent storage must not be deleted")
+	}
+
+	redis.Spec.Storage = &appv1.RedisStorage{Size: resource.MustParse("1Gi")}
+	step, err = UpgradePod2(ctx, c, redis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step.Deleted != "cache-0" || step.Blocked != "" {
+		t.Fatalf("step = %+v, want cache-0 recreated on its persistent volume", step)
+	}
+	if exists(t, c, "cache-0") {
+		t.Fatal("cache-0 must be deleted")
+	}
+}
+
+func TestUpgradePod2Completed(t *testing.T) {
+	ctx := context.Background()
+	redis := newUpgradeRedis(2, 6379, "cache-0", "cache-1")
+	notReady := newImagePod("cache-1", newImage)
+	notReady.Status.Conditions = nil
+	c := newClient(t, redis, newImagePod("cache-0", oldImage), notReady)
+
+	// 已经升级的 pod 没有就绪， 不继续升级
+	step, err := UpgradePod2(ctx, c, redis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step.Deleted != "" || step.Outdated != 1 || step.Completed {
+		t.Fatalf("step = %+v, want to wait for cache-1", step)
+	}
+
+	c = newClient(t, redis, newImagePod("cache-0", newImage), newImagePod("cache-1", newImage))
+	step, err = UpgradePod2(ctx, c, redis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !step.Completed || step.Outdated != 0 {
+		t.Fatalf("step = %+v, want completed", step)
+	}
+}
+
+func TestCreateRedisPod2SkipsOutdatedImage(t *testing.T) {
+	ctx := context.Background()
+	redis := newUpgradeRedis(1, 6379, "cache-0")
+	c := newClient(t, redis, newImagePod("cache-0", oldImage))
+
+	if _, err := CreateRedisPod2(ctx, c, c, redis, nil, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	pod := &corev1.Pod{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "cache-0"}, pod); err != nil {
+		t.Fatal(err)
+	}
+	if image := pod.Spec.Containers[0].Image; image != oldImage {
+		t.Fatalf("image = %q, the pod must keep its image until upgraded", image)
+	}
+}
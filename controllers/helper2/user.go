@@ -0,0 +1,204 @@
+package helper2
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/resp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// aclVersion 支持 ACL 的最低 redis 版本
+	aclVersion = "6.0"
+	// aclChannelVersion 支持 &channel 规则的最低 redis 版本
+	aclChannelVersion = "6.2"
+)
+
+// UnsupportedError pod 上的 redis 版本不支持 RedisUser 中的规则， 升级 redis 后才能同步
+type UnsupportedError struct {
+	// Pod 版本过低的 pod
+	Pod string
+	// Version pod 上的 redis 版本
+	Version string
+	// Required 需要的最低版本
+	Required string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("pod %s 的 redis 版本 %s 不支持， 需要 %s 及以上版本", e.Pod, e.Version, e.Required)
+}
+
+// IsUnsupported 判断错误是否为 redis 版本不支持
+func IsUnsupported(err error) bool {
+	var unsupported *UnsupportedError
+	return errors.As(err, &unsupported)
+}
+
+// SyncUser2 在每个运行中的 pod 上执行 ACL SETUSER， 返回每个 pod 的同步状态。
+// ACL 不会在主从之间复制， 也没有持久化到文件， pod 重建或重启后需要重新同步。
+// 单个 pod 同步失败记录在状态中， 不影响其他 pod；
+// pod 上的 redis 版本不支持 ACL 或 channel 规则时同时返回 UnsupportedError。
+func SyncUser2(ctx context.Context, c client.Client, secrets client.Reader, redis *appv1.Redis, user *appv1.RedisUser) ([]appv1.RedisUserPodStatus, error) {
+
+	password, err := secretValue(ctx, secrets, user.Namespace, user.Spec.PasswordSecret)
+	if err != nil {
+		return nil, err
+	}
+	args := aclSetUserArgs(user, password)
+	required := aclVersion
+	if len(user.Spec.Channels) > 0 {
+		required = aclChannelVersion
+	}
+
+	var unsupported error
+	pods, err := eachRunningPod(ctx, c, secrets, redis, func(pod *corev1.Pod, conn *resp.Conn) error {
+		version, err := serverVersion(conn)
+		if err != nil {
+			return err
+		}
+		if !versionAtLeast(version, required) {
+			err := &UnsupportedError{Pod: pod.Name, Version: version, Required: required}
+			if unsupported == nil {
+				unsupported = err
+			}
+			return err
+		}
+		_, err = conn.Do(args...)
+		return err
+	})
+	if err != nil {
+		return pods, err
+	}
+	return pods, unsupported
+}
+
+// DeleteUser2 在每个运行中的 pod 上执行 ACL DELUSER， 用户不存在时视为删除成功。
+// 不支持 ACL 的 redis 上不会有这个用户， 同样视为删除成功。
+func DeleteUser2(ctx context.Context, c client.Client, secrets client.Reader, redis *appv1.Redis, username string) ([]appv1.RedisUserPodStatus, error) {
+
+	return eachRunningPod(ctx, c, secrets, redis, func(pod *corev1.Pod, conn *resp.Conn) error {
+		version, err := serverVersion(conn)
+		if err != nil {
+			return err
+		}
+		if !versionAtLeast(version, aclVersion) {
+			return nil
+		}
+		_, err = conn.Do("ACL", "DELUSER", username)
+		return err
+	})
+}
+
+// serverVersion 返回 INFO server 中的 redis_version
+func serverVersion(conn *resp.Conn) (string, error) {
+	info, err := conn.String("INFO", "server")
+	if err != nil {
+		return "", fmt.Errorf("INFO server 失败: %w", err)
+	}
+	version := parseInfo(info)["redis_version"]
+	if version == "" {
+		return "", errors.New("INFO server 中没有 redis_version")
+	}
+	return version, nil
+}
+
+// versionAtLeast 比较 major.minor， 无法解析的版本视为不满足
+func versionAtLeast(version, min string) bool {
+	v, ok := majorMinor(version)
+	if !ok {
+		return false
+	}
+	m, _ := majorMinor(min)
+	if v[0] != m[0] {
+		return v[0] > m[0]
+	}
+	return v[1] >= m[1]
+}
+
+func majorMinor(version string) ([2]int, bool) {
+	var out [2]int
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return out, false
+	}
+	for i := range out {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return out, false
+		}
+		out[i] = n
+	}
+	return out, true
+}
+
+// aclSetUserArgs 返回 ACL SETUSER 的参数。
+// reset 清除用户原有的全部规则， 保证 redis 中的用户与 spec 完全一致；
+// 密码使用 sha256 传递， 不以明文出现在 ACL LIST 中。
+func aclSetUserArgs(user *appv1.RedisUser, password string) []string {
+	sum := sha256.Sum256([]byte(password))
+	args := []string{"ACL", "SETUSER", user.ACLUsername(), "reset", "on", "#" + hex.EncodeToString(sum[:])}
+	for _, key := range user.Spec.Keys {
+		args = append(args, "~"+key)
+	}
+	for _, channel := range user.Spec.Channels {
+		args = append(args, "&"+channel)
+	}
+	args = append(args, user.Spec.Commands...)
+	return args
+}
+
+// eachRunningPod 依次连接每个运行中的 pod 执行 fn， 记录每个 pod 的结果
+func eachRunningPod(ctx context.Context, c client.Client, secrets client.Reader, redis *appv1.Redis, fn func(pod *corev1.Pod, conn *resp.Conn) error) ([]appv1.RedisUserPodStatus, error) {
+	pods, err := runningPods(ctx, c, redis)
+	if err != nil {
+		return nil, err
+	}
+	if len(pods) == 0 {
+		return nil, nil
+	}
+
+	password, err := Password(ctx, secrets, redis)
+	if err != nil {
+		return nil, err
+	}
+	config, err := tlsConfig(ctx, secrets, redis)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]appv1.RedisUserPodStatus, 0, len(pods))
+	for i := range pods {
+		statuses = append(statuses, runOnPod(ctx, redis, &pods[i], password, config, fn))
+	}
+	return statuses, nil
+}
+
+func runOnPod(ctx context.Context, redis *appv1.Redis, pod *corev1.Pod, password string, config *tls.Config, fn func(pod *corev1.Pod, conn *resp.Conn) error) appv1.RedisUserPodStatus {
+	status := appv1.RedisUserPodStatus{Name: pod.Name}
+
+	conn, err := dialPod(ctx, redis, pod, password, config)
+	if err != nil {
+		status.Message = err.Error()
+		return status
+	}
+	defer conn.Close()
+
+	if err := fn(pod, conn); err != nil {
+		status.Message = err.Error()
+		return status
+	}
+	now := metav1.Now()
+	status.Synced = true
+	status.LastSyncTime = &now
+	return status
+}
@@ -0,0 +1,185 @@
+package helper2
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/resp/resptest"
+)
+
+func newRedisUser() *appv1.RedisUser {
+	return &appv1.RedisUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: appv1.RedisUserSpec{
+			RedisRef: corev1.LocalObjectReference{Name: "cache"},
+			PasswordSecret: corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "app-password"},
+				Key:                  "password",
+			},
+			Commands: []string{"+@read", "-@dangerous"},
+			Keys:     []string{"app:*"},
+			Channels: []string{"events.*"},
+		},
+	}
+}
+
+func TestACLSetUserArgs(t *testing.T) {
+	got := aclSetUserArgs(newRedisUser(), "secret")
+	want := []string{
+		"ACL", "SETUSER", "app", "reset", "on",
+		// sha256("secret")
+		"#2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b",
+		"~app:*", "&events.*", "+@read", "-@dangerous",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("args = %v\nwant %v", got, want)
+	}
+}
+
+func TestSyncUser2(t *testing.T) {
+	ctx := context.Background()
+	fail := false
+	server := newServer(t, func(args []string) string {
+		if args[0] == "INFO" {
+			return resptest.Bulk("# Server\r\nredis_version:6.2.14\r\n")
+		}
+		if fail {
+			return resptest.Err("ERR Error in ACL SETUSER modifier '&events.*': Syntax error")
+		}
+		return resptest.OK
+	})
+
+	redis := newRedis(2, "cache-0", "cache-1")
+	redis.Spec.Port = server.Port()
+	password := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-password", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+	// cache-1 没有运行， 不同步
+	c := newClient(t, redis, password, newRunningPod("cache-0"), newPod("cache-1"))
+
+	pods, err := SyncUser2(ctx, c, c, redis, newRedisUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 1 || pods[0].Name != "cache-0" || !pods[0].Synced || pods[0].LastSyncTime == nil {
+		t.Fatalf("pods = %+v", pods)
+	}
+	if commands := server.Commands(); len(commands) != 2 || commands[1][1] != "SETUSER" {
+		t.Fatalf("commands = %v", commands)
+	}
+
+	fail = true
+	pods, err = SyncUser2(ctx, c, c, redis, newRedisUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pods[0].Synced || pods[0].Message == "" {
+		t.Fatalf("expected the pod to report the redis error, got %+v", pods[0])
+	}
+}
+
+func TestSyncUser2Unsupported(t *testing.T) {
+	ctx := context.Background()
+	version := "5.0.14"
+	server := newServer(t, func(args []string) string {
+		if args[0] == "INFO" {
+			return resptest.Bulk("# Server\r\nredis_version:" + version + "\r\n")
+		}
+		return resptest.OK
+	})
+
+	redis := newRedis(1, "cache-0")
+	redis.Spec.Port = server.Port()
+	password := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-password", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+	c := newClient(t, redis, password, newRunningPod("cache-0"))
+
+	for _, tc := range []struct {
+		version  string
+		channels []string
+		required string
+	}{
+		// redis 5 不支持 ACL
+		{version: "5.0.14", required: "6.0"},
+		// &channel 规则需要 6.2
+		{version: "6.0.20", channels: []string{"events.*"}, required: "6.2"},
+	} {
+		version = tc.version
+		user := newRedisUser()
+		user.Spec.Channels = tc.channels
+
+		pods, err := SyncUser2(ctx, c, c, redis, user)
+		var unsupported *UnsupportedError
+		if !errors.As(err, &unsupported) || unsupported.Pod != "cache-0" || unsupported.Required != tc.required {
+			t.Fatalf("redis %s: err = %v, want unsupported before %s", tc.version, err, tc.required)
+		}
+		if len(pods) != 1 || pods[0].Synced {
+			t.Fatalf("redis %s: pods = %+v", tc.version, pods)
+		}
+	}
+	for _, command := range server.Commands() {
+		if command[0] == "ACL" {
+			t.Fatalf("ACL must not be sent to an unsupported redis, got %v", command)
+		}
+	}
+
+	// 不支持 ACL 的 redis 上没有用户， 删除直接成功
+	version = "5.0.14"
+	pods, err := DeleteUser2(ctx, c, c, redis, "app")
+	if err != nil || len(pods) != 1 || !pods[0].Synced {
+		t.Fatalf("DeleteUser2 = %+v, %v", pods, err)
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	for _, tc := range []struct {
+		version, min string
+		want         bool
+	}{
+		{"5.0.14", "6.0", false},
+		{"6.0.20", "6.0", true},
+		{"6.0.20", "6.2", false},
+		{"6.2.14", "6.2", true},
+		{"7.0.15", "6.2", true},
+		{"6.10.0", "6.2", true},
+		{"unknown", "6.0", false},
+	} {
+		if got := versionAtLeast(tc.version, tc.min); got != tc.want {
+			t.Errorf("versionAtLeast(%q, %q) = %v, want %v", tc.version, tc.min, got, tc.want)
+		}
+	}
+}
+
+func TestDeleteUser2(t *testing.T) {
+	ctx := context.Background()
+	server := newServer(t, func(args []string) string {
+		if args[0] == "INFO" {
+			return resptest.Bulk("# Server\r\nredis_version:6.2.14\r\n")
+		}
+		return resptest.Int(0)
+	})
+
+	redis := newRedis(1, "cache-0")
+	redis.Spec.Port = server.Port()
+	c := newClient(t, redis, newRunningPod("cache-0"))
+
+	pods, err := DeleteUser2(ctx, c, c, redis, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 1 || !pods[0].Synced {
+		t.Fatalf("pods = %+v", pods)
+	}
+	if commands := server.Commands(); !reflect.DeepEqual(commands, [][]string{{"INFO", "server"}, {"ACL", "DELUSER", "app"}}) {
+		t.Fatalf("commands = %v", commands)
+	}
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/go-logr/logr"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+const (
+	// 日志级别， 数值越大越详细， 通过 --zap-log-level 控制输出
+	logLevelDebug = 1
+	logLevelTrace = 2
+
+	redactedValue = "<redacted>"
+)
+
+// sensitiveAnnotationKeys annotation key 中包含这些关键字时， 值会被隐去
+var sensitiveAnnotationKeys = []string{
+	"password",
+	"secret",
+	"token",
+	// kubectl apply 记录的完整对象， 可能包含任意内容
+	"last-applied-configuration",
+}
+
+// logRedis 在 trace 级别输出完整的 redis 对象
+func (r *RedisReconciler) logRedis(logger logr.Logger, redis *myappv1.Redis) {
+	obj := redis
+	if r.RedactSecrets {
+		obj = redactRedis(redis)
+	}
+	logger.V(logLevelTrace).Info("fetched redis object", "object", obj)
+}
+
+// redactRedis 返回隐去敏感字段后的 redis 副本， 不修改原对象
+func redactRedis(redis *myappv1.Redis) *myappv1.Redis {
+	obj := redis.DeepCopy()
+
+	for k := range obj.Annotations {
+		if isSensitiveKey(k) {
+			obj.Annotations[k] = redactedValue
+		}
+	}
+
+	// secret 的名称和 key 也属于敏感信息
+	if obj.Spec.Auth != nil {
+		obj.Spec.Auth.PasswordSecret.Name = redactedValue
+		obj.Spec.Auth.PasswordSecret.Key = redactedValue
+	}
+
+	// managedFields 冗长且对排查问题无帮助
+	obj.ManagedFields = nil
+
+	return obj
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveAnnotationKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	namespace = "redis_operator"
+
+	// 调谐阶段
+	PhaseCreate    = "create"
+	PhaseScaleUp   = "scale-up"
+	PhaseScaleDown = "scale-down"
+	PhaseDelete    = "delete"
+	PhaseSync      = "sync"
+	PhasePaused    = "paused"
+
+	// 调谐结果
+	ResultSuccess = "success"
+	ResultRequeue = "requeue"
+	ResultError   = "error"
+)
+
+// Metrics operator 自定义指标。
+// 除 controller-runtime 默认指标外， 记录调谐结果、 pod 变更、 就绪耗时等业务指标。
+type Metrics struct {
+	ReconcileTotal    *prometheus.CounterVec
+	PodsCreatedTotal  prometheus.Counter
+	PodsDeletedTotal  prometheus.Counter
+	TimeToReady       *prometheus.GaugeVec
+	DriftCorrections  *prometheus.CounterVec
+	WebhookRejections *prometheus.CounterVec
+	ManagedRedis      *prometheus.GaugeVec
+	ReconcileRetries  *prometheus.GaugeVec
+	BudgetExhausted   prometheus.Counter
+
+	mu      sync.Mutex
+	managed map[types.NamespacedName]struct{}
+}
+
+// New 创建一组未注册的指标
+func New() *Metrics {
+	return &Metrics{
+		ReconcileTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconcile_total",
+			Help:      "Total number of Redis reconciliations by phase and result.",
+		}, []string{"phase", "result"}),
+		PodsCreatedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "pods_created_total",
+			Help:      "Total number of Redis pods created by the operator.",
+		}),
+		PodsDeletedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "pods_deleted_total",
+			Help:      "Total number of Redis pods deleted by the operator.",
+		}),
+		TimeToReady: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "time_to_ready_seconds",
+			Help:      "Seconds the last not ready to ready transition of a Redis took.",
+		}, []string{"namespace", "redis"}),
+		DriftCorrections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "drift_corrections_total",
+			Help:      "Total number of owned objects recreated after being removed out of band.",
+		}, []string{"kind"}),
+		WebhookRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "webhook_rejections_total",
+			Help:      "Total number of Redis admission requests rejected by validation rule.",
+		}, []string{"rule"}),
+		ManagedRedis: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "managed_redis",
+			Help:      "Number of Redis objects managed by the operator per namespace.",
+		}, []string{"namespace"}),
+
+		ReconcileRetries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "reconcile_retries",
+			Help:      "Number of consecutive rate limited retries of a Redis. Queue depth is reported by workqueue_depth{name=\"redis\"}.",
+		}, []string{"namespace", "redis"}),
+		BudgetExhausted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requeue_budget_exhausted_total",
+			Help:      "Total number of retries delayed because a Redis exceeded its requeue budget.",
+		}),
+
+		managed: map[types.NamespacedName]struct{}{},
+	}
+}
+
+// Collectors 返回全部指标， 用于注册到 registry
+func (m *Metrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.ReconcileTotal,
+		m.PodsCreatedTotal,
+		m.PodsDeletedTotal,
+		m.TimeToReady,
+		m.DriftCorrections,
+		m.WebhookRejections,
+		m.ManagedRedis,
+		m.ReconcileRetries,
+		m.BudgetExhausted,
+	}
+}
+
+// ObserveReconcile 记录一次调谐的阶段和结果
+func (m *Metrics) ObserveReconcile(phase string, requeue bool, err error) {
+	result := ResultSuccess
+	switch {
+	case err != nil:
+		result = ResultError
+	case requeue:
+		result = ResultRequeue
+	}
+	m.ReconcileTotal.WithLabelValues(phase, result).Inc()
+}
+
+// PodCreated 记录 operator 创建了一个 pod
+func (m *Metrics) PodCreated() {
+	m.PodsCreatedTotal.Inc()
+}
+
+// PodDeleted 记录 operator 删除了一个 pod
+func (m *Metrics) PodDeleted() {
+	m.PodsDeletedTotal.Inc()
+}
+
+// DriftCorrected 记录一次漂移修正， kind 为被重建对象的类型
+func (m *Metrics) DriftCorrected(kind string) {
+	m.DriftCorrections.WithLabelValues(kind).Inc()
+}
+
+// WebhookRejected 记录一次 webhook 拒绝， rule 为触发的校验规则
+func (m *Metrics) WebhookRejected(rule string) {
+	m.WebhookRejections.WithLabelValues(rule).Inc()
+}
+
+// ObserveRetries 记录 redis 连续重试的次数， 为 0 时清理指标
+func (m *Metrics) ObserveRetries(key types.NamespacedName, retries int) {
+	if retries == 0 {
+		m.ReconcileRetries.DeleteLabelValues(key.Namespace, key.Name)
+		return
+	}
+	m.ReconcileRetries.WithLabelValues(key.Namespace, key.Name).Set(float64(retries))
+}
+
+// RequeueBudgetExhausted 记录一次因超过重试次数上限而延后的重试
+func (m *Metrics) RequeueBudgetExhausted() {
+	m.BudgetExhausted.Inc()
+}
+
+// ObserveReady 记录 redis 从未就绪到全部 pod 就绪的耗时， 只在 Ready condition 由 False 变为 True 时调用
+func (m *Metrics) ObserveReady(key types.NamespacedName, d time.Duration) {
+	m.TimeToReady.WithLabelValues(key.Namespace, key.Name).Set(d.Seconds())
+}
+
+// TrackRedis 记录 redis 被 operator 管理
+func (m *Metrics) TrackRedis(key types.NamespacedName) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.managed[key]; ok {
+		return
+	}
+	m.managed[key] = struct{}{}
+	m.ManagedRedis.WithLabelValues(key.Namespace).Inc()
+}
+
+// ForgetRedis redis 被删除后清理相关指标
+func (m *Metrics) ForgetRedis(key types.NamespacedName) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.TimeToReady.DeleteLabelValues(key.Namespace, key.Name)
+	m.ReconcileRetries.DeleteLabelValues(key.Namespace, key.Name)
+
+	if _, ok := m.managed[key]; !ok {
+		return
+	}
+	delete(m.managed, key)
+	m.ManagedRedis.WithLabelValues(key.Namespace).Dec()
+}
+
+// Default 注册到 manager metrics registry 上的指标
+var Default = New()
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(Default.Collectors()...)
+}
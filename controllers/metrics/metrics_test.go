@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// newRegistered 创建一组注册到本地 registry 上的指标， 避免测试之间互相影响
+func newRegistered(t *testing.T) (*Metrics, *prometheus.Registry) {
+	t.Helper()
+
+	m := New()
+	reg := prometheus.NewPedanticRegistry()
+	for _, c := range m.Collectors() {
+		if err := reg.Register(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m, reg
+}
+
+func TestObserveReconcile(t *testing.T) {
+	m, reg := newRegistered(t)
+
+	m.ObserveReconcile(PhaseCreate, false, nil)
+	m.ObserveReconcile(PhaseCreate, true, nil)
+	m.ObserveReconcile(PhaseScaleDown, false, errors.New("boom"))
+	m.ObserveReconcile(PhaseDelete, true, errors.New("boom"))
+
+	expected := `
+# HELP redis_operator_reconcile_total Total number of Redis reconciliations by phase and result.
+# TYPE redis_operator_reconcile_total counter
+redis_operator_reconcile_total{phase="create",result="requeue"} 1
+redis_operator_reconcile_total{phase="create",result="success"} 1
+redis_operator_reconcile_total{phase="delete",result="error"} 1
+redis_operator_reconcile_total{phase="scale-down",result="error"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "redis_operator_reconcile_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPodCounters(t *testing.T) {
+	m, reg := newRegistered(t)
+
+	m.PodCreated()
+	m.PodCreated()
+	m.PodDeleted()
+
+	expected := `
+# HELP redis_operator_pods_created_total Total number of Redis pods created by the operator.
+# TYPE redis_operator_pods_created_total counter
+redis_operator_pods_created_total 2
+# HELP redis_operator_pods_deleted_total Total number of Redis pods deleted by the operator.
+# TYPE redis_operator_pods_deleted_total counter
+redis_operator_pods_deleted_total 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"redis_operator_pods_created_total", "redis_operator_pods_deleted_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestObserveReady(t *testing.T) {
+	m, reg := newRegistered(t)
+
+	key := types.NamespacedName{Namespace: "default", Name: "my-redis"}
+	m.ObserveReady(key, 30*time.Second)
+	// 再次从未就绪恢复时记录最近一次的耗时
+	m.ObserveReady(key, 90*time.Second)
+
+	expected := `
+# HELP redis_operator_time_to_ready_seconds Seconds the last not ready to ready transition of a Redis took.
+# TYPE redis_operator_time_to_ready_seconds gauge
+redis_operator_time_to_ready_seconds{namespace="default",redis="my-redis"} 90
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "redis_operator_time_to_ready_seconds"); err != nil {
+		t.Fatal(err)
+	}
+
+	m.ForgetRedis(key)
+	if n := testutil.CollectAndCount(m.TimeToReady); n != 0 {
+		t.Fatalf("time to ready series = %d, want 0", n)
+	}
+}
+
+func TestDriftCorrections(t *testing.T) {
+	m, reg := newRegistered(t)
+
+	m.DriftCorrected("pod")
+	m.DriftCorrected("pod")
+	m.DriftCorrected("service")
+
+	expected := `
+# HELP redis_operator_drift_corrections_total Total number of owned objects recreated after being removed out of band.
+# TYPE redis_operator_drift_corrections_total counter
+redis_operator_drift_corrections_total{kind="pod"} 2
+redis_operator_drift_corrections_total{kind="service"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "redis_operator_drift_corrections_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookRejections(t *testing.T) {
+	m, reg := newRegistered(t)
+
+	m.WebhookRejected("port-range")
+	m.WebhookRejected("reserved-name")
+	m.WebhookRejected("port-range")
+
+	expected := `
+# HELP redis_operator_webhook_rejections_total Total number of Redis admission requests rejected by validation rule.
+# TYPE redis_operator_webhook_rejections_total counter
+redis_operator_webhook_rejections_total{rule="port-range"} 2
+redis_operator_webhook_rejections_total{rule="reserved-name"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "redis_operator_webhook_rejections_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManagedRedisPerNamespace(t *testing.T) {
+	m, reg := newRegistered(t)
+
+	a := types.NamespacedName{Namespace: "team-a", Name: "cache"}
+	b := types.NamespacedName{Namespace: "team-a", Name: "session"}
+	c := types.NamespacedName{Namespace: "team-b", Name: "cache"}
+
+	m.TrackRedis(a)
+	m.TrackRedis(a) // 重复调谐不重复计数
+	m.TrackRedis(b)
+	m.TrackRedis(c)
+	m.ForgetRedis(c)
+	m.ForgetRedis(c) // 重复删除不会减为负数
+
+	expected := `
+# HELP redis_operator_managed_redis Number of Redis objects managed by the operator per namespace.
+# TYPE redis_operator_managed_redis gauge
+redis_operator_managed_redis{namespace="team-a"} 2
+redis_operator_managed_redis{namespace="team-b"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "redis_operator_managed_redis"); err != nil {
+		t.Fatal(err)
+	}
+}
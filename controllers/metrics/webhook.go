@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+// RecordRejections 包装 webhook， 请求被拒绝时按响应中的校验规则记录到 Default
+func RecordRejections(wh *admission.Webhook) *admission.Webhook {
+	wh.Handler = &rejectionRecorder{handler: wh.Handler, metrics: Default}
+	return wh
+}
+
+type rejectionRecorder struct {
+	handler admission.Handler
+	metrics *Metrics
+}
+
+func (r *rejectionRecorder) Handle(ctx context.Context, req admission.Request) admission.Response {
+	resp := r.handler.Handle(ctx, req)
+	if rule := rejectedRule(resp); rule != "" {
+		r.metrics.WebhookRejected(rule)
+	}
+	return resp
+}
+
+// InjectDecoder 把 decoder 传给被包装的 handler
+func (r *rejectionRecorder) InjectDecoder(d *admission.Decoder) error {
+	_, err := admission.InjectDecoderInto(d, r.handler)
+	return err
+}
+
+// InjectFunc 把依赖注入到被包装的 handler
+func (r *rejectionRecorder) InjectFunc(f inject.Func) error {
+	return f(r.handler)
+}
+
+// rejectedRule 返回拒绝请求的校验规则， 请求被允许或者没有记录规则时返回空字符串
+func rejectedRule(resp admission.Response) string {
+	if resp.Allowed || resp.Result == nil || resp.Result.Details == nil {
+		return ""
+	}
+	for _, cause := range resp.Result.Details.Causes {
+		if cause.Type == myappv1.CauseTypeValidationRule {
+			return cause.Message
+		}
+	}
+	return ""
+}
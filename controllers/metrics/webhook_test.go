@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+func TestRecordRejections(t *testing.T) {
+	m, reg := newRegistered(t)
+
+	scheme := runtime.NewScheme()
+	if err := myappv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &rejectionRecorder{handler: (&myappv1.RedisWebhook{}).ValidatingWebhook().Handler, metrics: m}
+	if err := recorder.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(port int32) admission.Response {
+		redis := &myappv1.Redis{
+			TypeMeta:   metav1.TypeMeta{APIVersion: myappv1.GroupVersion.String(), Kind: "Redis"},
+			ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default"},
+			Spec:       myappv1.RedisSpec{Replicas: 1, Port: port, Image: "redis:6"},
+		}
+		raw, err := json.Marshal(redis)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return recorder.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}})
+	}
+
+	if resp := create(6379); !resp.Allowed {
+		t.Fatalf("valid redis rejected: %v", resp.Result)
+	}
+	if resp := create(80); resp.Allowed || resp.Result.Code != 403 {
+		t.Fatalf("response = %+v, want a 403 rejection", resp.Result)
+	}
+
+	expected := `
+# HELP redis_operator_webhook_rejections_total Total number of Redis admission requests rejected by validation rule.
+# TYPE redis_operator_webhook_rejections_total counter
+redis_operator_webhook_rejections_total{rule="port-range"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "redis_operator_webhook_rejections_total"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,207 @@
+// Package orphan 定期清理 operator 标签的孤儿 pod。
+// 孤儿 pod 指所属 redis 已经删除， 或者 OwnerReference 指向其他 redis (例如删除后重建的同名 redis) 的 pod。
+// 这些 pod 不在 owner 索引中， redis 的调谐看不到它们。
+package orphan
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	configv1alpha1 "github.com/tangx/k8s-operator-demo/api/config/v1alpha1"
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+	"github.com/tangx/k8s-operator-demo/controllers/events"
+	"github.com/tangx/k8s-operator-demo/controllers/helper2"
+	"github.com/tangx/k8s-operator-demo/controllers/metrics"
+)
+
+// Sweeper 按策略收养或删除孤儿 pod， 作为 manager 的 Runnable 只在 leader 上运行
+type Sweeper struct {
+	// Client 读取 pod 和 redis， 通常是 manager 的 cache client
+	Client client.Client
+	// Reader 删除 pod 之前从 apiserver 确认 redis 已经不存在， 避免 cache 滞后导致误删。
+	// 为 nil 时只依据 Client 的结果。
+	Reader   client.Reader
+	Scheme   *runtime.Scheme
+	Recorder *events.Recorder
+
+	Policy   configv1alpha1.OrphanPolicy
+	Interval time.Duration
+}
+
+// Start 实现 manager.Runnable， 每隔 Interval 检查一次
+func (s *Sweeper) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("orphan-sweeper")
+	ctx = log.IntoContext(ctx, logger)
+
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := s.Sweep(ctx); err != nil {
+			// 下一个周期重试
+			logger.Error(err, "unable to sweep orphaned pods")
+		}
+	}, s.Interval)
+	return nil
+}
+
+// NeedLeaderElection 实现 manager.LeaderElectionRunnable
+func (s *Sweeper) NeedLeaderElection() bool {
+	return true
+}
+
+// Sweep 检查一次全部 operator 标签的 pod
+func (s *Sweeper) Sweep(ctx context.Context) error {
+	if s.Policy == configv1alpha1.OrphanPolicyIgnore {
+		return nil
+	}
+
+	pods := &corev1.PodList{}
+	if err := s.Client.List(ctx, pods, client.MatchingLabels{builder.LabelManagedBy: builder.ManagedBy}); err != nil {
+		return fmt.Errorf("获取 pod 失败: %w", err)
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if err := s.sweepPod(ctx, pod); err != nil {
+			return fmt.Errorf("处理孤儿 pod (%s/%s) 失败: %w", pod.Namespace, pod.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Sweeper) sweepPod(ctx context.Context, pod *corev1.Pod) error {
+	key := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Labels[builder.LabelInstance]}
+	if key.Name == "" {
+		return nil
+	}
+
+	redis, err := s.getRedis(ctx, key)
+	if err != nil {
+		return err
+	}
+	if redis == nil {
+		return s.delete(ctx, pod, pod, fmt.Sprintf("Redis %s no longer exists", key.Name))
+	}
+
+	if helper2.OwnedBy(pod, redis.UID) || !redis.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	if s.Policy == configv1alpha1.OrphanPolicyAdopt && desired(redis, pod.Name) {
+		return s.adopt(ctx, redis, pod)
+	}
+	return s.delete(ctx, redis, pod, fmt.Sprintf("pod is owned by another Redis %s", owners(pod)))
+}
+
+// getRedis 返回 pod 所属的 redis， redis 不存在时返回 nil
+func (s *Sweeper) getRedis(ctx context.Context, key types.NamespacedName) (*appv1.Redis, error) {
+	redis := &appv1.Redis{}
+	err := s.Client.Get(ctx, key, redis)
+	if err == nil {
+		return redis, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	// cache 中没有时从 apiserver 确认
+	reader := s.Reader
+	if reader == nil {
+		return nil, nil
+	}
+	err = reader.Get(ctx, key, redis)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return redis, nil
+}
+
+// adopt 把 pod 的 OwnerReference 改为当前的 redis， 下一次调谐会补上 finalizer
+func (s *Sweeper) adopt(ctx context.Context, redis *appv1.Redis, pod *corev1.Pod) error {
+	previous := owners(pod)
+	patch := client.MergeFrom(pod.DeepCopy())
+
+	// 去掉指向其他 redis 的 OwnerReference
+	var refs []metav1.OwnerReference
+	for _, ref := range pod.OwnerReferences {
+		if !isRedisOwner(ref) {
+			refs = append(refs, ref)
+		}
+	}
+	pod.OwnerReferences = refs
+	if err := controllerutil.SetOwnerReference(redis, pod, s.Scheme); err != nil {
+		return err
+	}
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	for k, v := range builder.Labels(redis) {
+		pod.Labels[k] = v
+	}
+
+	if err := s.Client.Patch(ctx, pod, patch); err != nil {
+		return err
+	}
+
+	log.FromContext(ctx).Info("adopted orphaned pod", "pod", client.ObjectKeyFromObject(pod), "redis", redis.Name, "previousOwner", previous)
+	s.Recorder.Event(redis, events.ReasonPodAdopted, pod.Name, previous)
+	return nil
+}
+
+// delete 删除孤儿 pod， 事件记录在 involved 上
+func (s *Sweeper) delete(ctx context.Context, involved runtime.Object, pod *corev1.Pod, reason string) error {
+	// 只删除检查时的 pod， 避免误删刚刚重建的同名 pod
+	precondition := client.Preconditions{UID: &pod.UID}
+	err := s.Client.Delete(ctx, pod, precondition)
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	metrics.Default.PodDeleted()
+	log.FromContext(ctx).Info("deleted orphaned pod", "pod", client.ObjectKeyFromObject(pod), "reason", reason)
+	s.Recorder.Event(involved, events.ReasonOrphanDeleted, pod.Name, reason)
+	return nil
+}
+
+// desired 判断 pod 名称是否为 redis 当前需要的 pod
+func desired(redis *appv1.Redis, name string) bool {
+	for i := 0; i < redis.Spec.Replicas; i++ {
+		if builder.PodName(redis, i) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isRedisOwner(ref metav1.OwnerReference) bool {
+	return ref.APIVersion == appv1.GroupVersion.String() && ref.Kind == "Redis"
+}
+
+// owners 描述 pod 当前所属的 redis， 用于事件和日志
+func owners(pod *corev1.Pod) string {
+	for _, ref := range pod.OwnerReferences {
+		if isRedisOwner(ref) {
+			return fmt.Sprintf("%s (uid %s)", ref.Name, ref.UID)
+		}
+	}
+	return "none"
+}
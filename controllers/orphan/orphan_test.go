@@ -0,0 +1,189 @@
+package orphan
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	configv1alpha1 "github.com/tangx/k8s-operator-demo/api/config/v1alpha1"
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+	"github.com/tangx/k8s-operator-demo/controllers/events"
+	"github.com/tangx/k8s-operator-demo/controllers/fakeclient"
+)
+
+func newRedis() *appv1.Redis {
+	return &appv1.Redis{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cache",
+			Namespace: "default",
+			UID:       "new-uid",
+		},
+		Spec: appv1.RedisSpec{Replicas: 2, Port: 6379},
+	}
+}
+
+// newPod 创建 instance 标签为 instance、 属于 uid 的 pod
+func newPod(name, instance string, uid types.UID) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID(name + "-uid"),
+			Labels: map[string]string{
+				builder.LabelInstance:  instance,
+				builder.LabelManagedBy: builder.ManagedBy,
+			},
+		},
+	}
+	if uid != "" {
+		pod.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: appv1.GroupVersion.String(),
+			Kind:       "Redis",
+			Name:       instance,
+			UID:        uid,
+		}}
+	}
+	return pod
+}
+
+func newSweeper(t *testing.T, policy configv1alpha1.OrphanPolicy, objs ...client.Object) (*Sweeper, *record.FakeRecorder) {
+	t.Helper()
+
+	fake := record.NewFakeRecorder(10)
+	recorder, err := events.NewRecorder(fake, events.LanguageEnglish)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := fakeclient.New(objs...)
+	return &Sweeper{
+		Client:   c,
+		Scheme:   c.Scheme(),
+		Recorder: recorder,
+		Policy:   policy,
+	}, fake
+}
+
+func podNames(t *testing.T, c client.Client) []string {
+	t.Helper()
+
+	pods := &corev1.PodList{}
+	if err := c.List(context.Background(), pods); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pod := range pods.Items {
+		names = append(names, pod.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reasons 返回已记录事件的 reason
+func reasons(fake *record.FakeRecorder) []string {
+	var got []string
+	for {
+		select {
+		case e := <-fake.Events:
+			// 格式为 "<type> <reason> <message>"
+			got = append(got, strings.Fields(e)[1])
+		default:
+			sort.Strings(got)
+			return got
+		}
+	}
+}
+
+func TestSweepAdopt(t *testing.T) {
+	ctx := context.Background()
+	s, fake := newSweeper(t, configv1alpha1.OrphanPolicyAdopt,
+		newRedis(),
+		newPod("cache-0", "cache", "new-uid"),
+		// 同名 redis 删除重建前留下的 pod
+		newPod("cache-1", "cache", "old-uid"),
+		newPod("cache-5", "cache", "old-uid"),
+		// redis 已经删除
+		newPod("gone-0", "gone", "gone-uid"),
+		// 不是 operator 管理的 pod
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", Labels: map[string]string{"app": "cache"}}},
+	)
+
+	if err := s.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := podNames(t, s.Client), []string{"cache-0", "cache-1", "other"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("pods = %v, want %v", got, want)
+	}
+
+	adopted := &corev1.Pod{}
+	if err := s.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "cache-1"}, adopted); err != nil {
+		t.Fatal(err)
+	}
+	if len(adopted.OwnerReferences) != 1 || adopted.OwnerReferences[0].UID != "new-uid" {
+		t.Fatalf("owner references = %v", adopted.OwnerReferences)
+	}
+
+	want := []string{events.ReasonOrphanDeleted, events.ReasonOrphanDeleted, events.ReasonPodAdopted}
+	if got := reasons(fake); !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+}
+
+func TestSweepDelete(t *testing.T) {
+	s, fake := newSweeper(t, configv1alpha1.OrphanPolicyDelete,
+		newRedis(),
+		newPod("cache-0", "cache", "new-uid"),
+		newPod("cache-1", "cache", "old-uid"),
+		newPod("cache-2", "cache", ""),
+	)
+
+	if err := s.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := podNames(t, s.Client), []string{"cache-0"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("pods = %v, want %v", got, want)
+	}
+	if got := reasons(fake); len(got) != 2 {
+		t.Fatalf("expected an event per deleted pod, got %v", got)
+	}
+}
+
+func TestSweepIgnore(t *testing.T) {
+	s, fake := newSweeper(t, configv1alpha1.OrphanPolicyIgnore,
+		newPod("gone-0", "gone", "gone-uid"),
+	)
+
+	if err := s.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := podNames(t, s.Client); len(got) != 1 {
+		t.Fatalf("pods = %v, nothing should be deleted", got)
+	}
+	if got := reasons(fake); len(got) != 0 {
+		t.Fatalf("events = %v", got)
+	}
+}
+
+// cache 中没有 redis 时， 从 apiserver 确认后才删除
+func TestSweepConfirmsWithReader(t *testing.T) {
+	redis := newRedis()
+	s, _ := newSweeper(t, configv1alpha1.OrphanPolicyAdopt, newPod("cache-0", "cache", "new-uid"))
+	s.Reader = fakeclient.New(redis)
+
+	if err := s.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := podNames(t, s.Client); len(got) != 1 {
+		t.Fatalf("pods = %v, pod of an existing redis must be kept", got)
+	}
+}
@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/events"
+)
+
+// Paused condition 的 reason
+const (
+	reasonPausedByAnnotation = "PausedByAnnotation"
+	reasonReconciling        = "Reconciling"
+)
+
+// pausedReconcile 暂停期间不创建、 不删除任何对象， 包括 redis 的删除流程。
+// 只更新 Paused 状态， 移除注解后由下一次调谐补齐期间产生的差异。
+func (r *RedisReconciler) pausedReconcile(ctx context.Context, redis *myappv1.Redis) (ctrl.Result, error) {
+	if setPausedCondition(redis, true) {
+		r.EventRecord.Event(redis, events.ReasonPaused, redis.Name, myappv1.PausedAnnotation)
+	}
+
+	log.FromContext(ctx).Info("reconciliation paused, skipping", "annotation", myappv1.PausedAnnotation)
+	return ctrl.Result{}, nil
+}
+
+// resumeReconcile 从暂停中恢复时记录事件
+func (r *RedisReconciler) resumeReconcile(redis *myappv1.Redis) {
+	if setPausedCondition(redis, false) {
+		r.EventRecord.Event(redis, events.ReasonResumed, redis.Name)
+	}
+}
+
+// setPausedCondition 设置 Paused condition， 返回暂停状态是否发生了变化
+func setPausedCondition(redis *myappv1.Redis, paused bool) bool {
+	was := meta.IsStatusConditionTrue(redis.Status.Conditions, myappv1.ConditionPaused)
+
+	if paused {
+		setRedisCondition(redis, myappv1.ConditionPaused, true, reasonPausedByAnnotation,
+			"Reconciliation is paused by annotation "+myappv1.PausedAnnotation)
+	} else {
+		setRedisCondition(redis, myappv1.ConditionPaused, false, reasonReconciling, "Reconciliation is active")
+	}
+
+	return was != paused
+}
@@ -0,0 +1,81 @@
+// Package predicates 过滤触发 redis 调谐的事件， 避免无关的更新导致重复调谐
+package predicates
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// Redis 只在 spec、 注解 (暂停) 或标签 (分片) 变化时调谐。
+// 调谐本身会更新 status 和 finalizers， 这些更新不改变 generation， 不再触发调谐。
+// 删除 redis 时 apiserver 设置 deletionTimestamp 并增加 generation。
+func Redis() predicate.Predicate {
+	return predicate.Or(
+		predicate.GenerationChangedPredicate{},
+		predicate.AnnotationChangedPredicate{},
+		predicate.LabelChangedPredicate{},
+	)
+}
+
+// Pod 在 pod 的阶段、 就绪状态、 spec、 标签、 OwnerReference 变化或开始删除时触发调谐。
+// 容器崩溃重启会改变就绪状态， 收养孤儿 pod 会改变 OwnerReference。
+func Pod() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldPod, ok := e.ObjectOld.(*corev1.Pod)
+			if !ok {
+				return true
+			}
+			newPod, ok := e.ObjectNew.(*corev1.Pod)
+			if !ok {
+				return true
+			}
+
+			return oldPod.Status.Phase != newPod.Status.Phase ||
+				isReady(oldPod) != isReady(newPod) ||
+				oldPod.DeletionTimestamp.IsZero() != newPod.DeletionTimestamp.IsZero() ||
+				!equality.Semantic.DeepEqual(oldPod.Labels, newPod.Labels) ||
+				!equality.Semantic.DeepEqual(oldPod.OwnerReferences, newPod.OwnerReferences) ||
+				!equality.Semantic.DeepEqual(oldPod.Spec, newPod.Spec)
+		},
+	}
+}
+
+// Service 在 service 的 spec 或标签变化时触发调谐
+func Service() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldSvc, ok := e.ObjectOld.(*corev1.Service)
+			if !ok {
+				return true
+			}
+			newSvc, ok := e.ObjectNew.(*corev1.Service)
+			if !ok {
+				return true
+			}
+
+			return !equality.Semantic.DeepEqual(oldSvc.Labels, newSvc.Labels) ||
+				!equality.Semantic.DeepEqual(oldSvc.Spec, newSvc.Spec)
+		},
+	}
+}
+
+// NetworkPolicy 在 NetworkPolicy 的 spec 或标签变化时触发调谐。
+// NetworkPolicy 的 spec 变化会增加 generation。
+func NetworkPolicy() predicate.Predicate {
+	return predicate.Or(
+		predicate.GenerationChangedPredicate{},
+		predicate.LabelChangedPredicate{},
+	)
+}
+
+func isReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
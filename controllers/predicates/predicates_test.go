@@ -0,0 +1,119 @@
+package predicates
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+func update(old, new client.Object) event.UpdateEvent {
+	return event.UpdateEvent{ObjectOld: old, ObjectNew: new}
+}
+
+func TestRedis(t *testing.T) {
+	p := Redis()
+	old := &appv1.Redis{ObjectMeta: metav1.ObjectMeta{Name: "cache", Generation: 1}}
+
+	status := old.DeepCopy()
+	status.Status.Replicas = 2
+	status.Finalizers = []string{"cache-0"}
+	if p.Update(update(old, status)) {
+		t.Error("status and finalizer updates must not trigger reconcile")
+	}
+
+	spec := old.DeepCopy()
+	spec.Generation = 2
+	if !p.Update(update(old, spec)) {
+		t.Error("spec change must trigger reconcile")
+	}
+
+	paused := old.DeepCopy()
+	paused.Annotations = map[string]string{appv1.PausedAnnotation: "true"}
+	if !p.Update(update(old, paused)) {
+		t.Error("annotation change must trigger reconcile")
+	}
+}
+
+func newPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache-0", Labels: map[string]string{"app.kubernetes.io/instance": "cache"}},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+}
+
+func TestPod(t *testing.T) {
+	p := Pod()
+	old := newPod()
+
+	cases := []struct {
+		name   string
+		mutate func(*corev1.Pod)
+		want   bool
+	}{
+		{"resource version only", func(pod *corev1.Pod) { pod.ResourceVersion = "2" }, false},
+		{"annotation", func(pod *corev1.Pod) { pod.Annotations = map[string]string{"a": "b"} }, false},
+		{"phase", func(pod *corev1.Pod) { pod.Status.Phase = corev1.PodFailed }, true},
+		{"readiness", func(pod *corev1.Pod) { pod.Status.Conditions[0].Status = corev1.ConditionFalse }, true},
+		{"labels", func(pod *corev1.Pod) { pod.Labels = nil }, true},
+		{"owner", func(pod *corev1.Pod) { pod.OwnerReferences = []metav1.OwnerReference{{Kind: "Redis", Name: "cache"}} }, true},
+		{"spec", func(pod *corev1.Pod) { pod.Spec.NodeName = "node-1" }, true},
+		{"deleting", func(pod *corev1.Pod) { now := metav1.Now(); pod.DeletionTimestamp = &now }, true},
+	}
+
+	for _, c := range cases {
+		updated := old.DeepCopy()
+		c.mutate(updated)
+		if got := p.Update(update(old, updated)); got != c.want {
+			t.Errorf("%s: update = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	if !p.Delete(event.DeleteEvent{Object: old}) {
+		t.Error("pod deletion must trigger reconcile")
+	}
+}
+
+func TestService(t *testing.T) {
+	p := Service()
+	old := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "cache"}}
+
+	annotated := old.DeepCopy()
+	annotated.Annotations = map[string]string{"a": "b"}
+	if p.Update(update(old, annotated)) {
+		t.Error("annotation change must not trigger reconcile")
+	}
+
+	changed := old.DeepCopy()
+	changed.Spec.Selector = map[string]string{"app": "other"}
+	if !p.Update(update(old, changed)) {
+		t.Error("spec change must trigger reconcile")
+	}
+}
+
+func TestNetworkPolicy(t *testing.T) {
+	p := NetworkPolicy()
+	old := &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "cache", Generation: 1}}
+
+	status := old.DeepCopy()
+	status.ResourceVersion = "2"
+	if p.Update(update(old, status)) {
+		t.Error("resource version change must not trigger reconcile")
+	}
+
+	spec := old.DeepCopy()
+	spec.Generation = 2
+	if !p.Update(update(old, spec)) {
+		t.Error("spec change must trigger reconcile")
+	}
+}
@@ -0,0 +1,62 @@
+// Package ratelimit 构造 redis 调谐队列使用的限速器
+package ratelimit
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	configv1alpha1 "github.com/tangx/k8s-operator-demo/api/config/v1alpha1"
+	"github.com/tangx/k8s-operator-demo/controllers/metrics"
+)
+
+// New 根据配置创建限速器。
+// 单个 redis 指数退避， 整个队列令牌桶限速， 超过重试次数上限的 redis 至少间隔 BudgetBackoff 重试。
+func New(spec configv1alpha1.RateLimiterSpec, m *metrics.Metrics) workqueue.RateLimiter {
+	limiter := workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(spec.BaseDelay.Duration, spec.MaxDelay.Duration),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(spec.QPS), spec.Burst)},
+	)
+
+	return &budgetRateLimiter{
+		RateLimiter: limiter,
+		budget:      spec.RequeueBudget,
+		backoff:     spec.BudgetBackoff.Duration,
+		metrics:     m,
+	}
+}
+
+// budgetRateLimiter 限制单个 redis 连续重试的次数， 并记录每个 redis 的重试次数
+type budgetRateLimiter struct {
+	workqueue.RateLimiter
+
+	budget  int
+	backoff time.Duration
+	metrics *metrics.Metrics
+}
+
+func (b *budgetRateLimiter) When(item interface{}) time.Duration {
+	delay := b.RateLimiter.When(item)
+	retries := b.RateLimiter.NumRequeues(item)
+
+	req, ok := item.(reconcile.Request)
+	if ok {
+		b.metrics.ObserveRetries(req.NamespacedName, retries)
+	}
+
+	if b.budget > 0 && retries > b.budget && delay < b.backoff {
+		b.metrics.RequeueBudgetExhausted()
+		return b.backoff
+	}
+	return delay
+}
+
+func (b *budgetRateLimiter) Forget(item interface{}) {
+	b.RateLimiter.Forget(item)
+
+	if req, ok := item.(reconcile.Request); ok {
+		b.metrics.ObserveRetries(req.NamespacedName, 0)
+	}
+}
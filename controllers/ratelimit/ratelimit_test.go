@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	configv1alpha1 "github.com/tangx/k8s-operator-demo/api/config/v1alpha1"
+	"github.com/tangx/k8s-operator-demo/controllers/metrics"
+)
+
+func newSpec(budget int) configv1alpha1.RateLimiterSpec {
+	return configv1alpha1.RateLimiterSpec{
+		BaseDelay:     metav1.Duration{Duration: time.Millisecond},
+		MaxDelay:      metav1.Duration{Duration: time.Second},
+		QPS:           1000,
+		Burst:         1000,
+		RequeueBudget: budget,
+		BudgetBackoff: metav1.Duration{Duration: time.Minute},
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	limiter := New(newSpec(0), metrics.New())
+	item := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "cache"}}
+
+	want := []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond}
+	for i, w := range want {
+		if got := limiter.When(item); got != w {
+			t.Fatalf("retry %d: delay = %s, want %s", i+1, got, w)
+		}
+	}
+
+	// 不同 redis 的退避互不影响
+	other := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "other"}}
+	if got := limiter.When(other); got != time.Millisecond {
+		t.Fatalf("other redis delay = %s, want 1ms", got)
+	}
+}
+
+func TestRequeueBudget(t *testing.T) {
+	m := metrics.New()
+	limiter := New(newSpec(2), m)
+	key := types.NamespacedName{Namespace: "default", Name: "cache"}
+	item := reconcile.Request{NamespacedName: key}
+
+	limiter.When(item)
+	limiter.When(item)
+	if got := limiter.When(item); got != time.Minute {
+		t.Fatalf("delay after budget = %s, want 1m", got)
+	}
+	if got := testutil.ToFloat64(m.BudgetExhausted); got != 1 {
+		t.Fatalf("budget exhausted = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.ReconcileRetries.WithLabelValues("default", "cache")); got != 3 {
+		t.Fatalf("retries = %v, want 3", got)
+	}
+
+	// 调谐成功后重新计数， 并清理重试指标
+	limiter.Forget(item)
+	if got := limiter.When(item); got != time.Millisecond {
+		t.Fatalf("delay after forget = %s, want 1ms", got)
+	}
+	limiter.Forget(item)
+	if n := testutil.CollectAndCount(m.ReconcileRetries); n != 0 {
+		t.Fatalf("expected retries metric to be cleared, got %d series", n)
+	}
+}
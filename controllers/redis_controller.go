@@ -18,25 +18,36 @@ package controllers
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
-	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/tools/record"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/events"
 	"github.com/tangx/k8s-operator-demo/controllers/helper2"
+	"github.com/tangx/k8s-operator-demo/controllers/metrics"
+	"github.com/tangx/k8s-operator-demo/controllers/predicates"
+)
+
+const (
+	// terminalErrorBackoff 终止性错误的重试间隔
+	terminalErrorBackoff = 5 * time.Minute
 )
 
 // RedisReconciler reconciles a Redis object
@@ -45,7 +56,28 @@ type RedisReconciler struct {
 	Scheme *runtime.Scheme
 
 	// 添加事件
-	EventRecord record.EventRecorder
+	EventRecord *events.Recorder
+
+	// ServiceMonitorAvailable 集群中是否安装了 ServiceMonitor CRD， 启动时通过 discovery 检测
+	ServiceMonitorAvailable bool
+
+	// OperatorNamespace operator 所在的 namespace， NetworkPolicy 需要允许 operator 访问 redis
+	OperatorNamespace string
+
+	// Versions operator 配置中的版本目录， 为空时使用内置目录
+	Versions myappv1.VersionCatalog
+
+	// RedactSecrets 输出对象日志时隐去敏感字段
+	RedactSecrets bool
+
+	// MaxConcurrentReconciles 同时调谐的 redis 数量， 为 0 时使用 manager 的默认值
+	MaxConcurrentReconciles int
+
+	// RateLimiter 调谐失败后重新入队的限速器， 为 nil 时使用 controller-runtime 的默认值
+	RateLimiter workqueue.RateLimiter
+
+	// APIReader 直接读取 apiserver， 用于读取不在 cache 中的 secret。 为 nil 时使用 Client
+	APIReader client.Reader
 }
 
 //+kubebuilder:rbac:groups=myapp.tangx.in,resources=redis,verbs=get;list;watch;create;update;patch;delete
@@ -53,6 +85,12 @@ type RedisReconciler struct {
 //+kubebuilder:rbac:groups=myapp.tangx.in,resources=redis/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;create
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -63,146 +101,312 @@ type RedisReconciler struct {
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.10.0/pkg/reconcile
-func (r *RedisReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	fmt.Println("进入 redis Reconcile, 检查调谐状态")
-	defer fmt.Println("退出 redis Reconcile 调谐状态")
-
-	_ = log.FromContext(ctx)
+func (r *RedisReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	// controller-runtime 已经在 logger 中添加了 name 和 namespace
+	logger := log.FromContext(ctx).WithValues(
+		"redis", req.Name,
+		"reconcileID", uuid.NewUUID(),
+	)
+	ctx = log.IntoContext(ctx, logger)
 
-	// TODO(user): your logic here
+	logger.V(logLevelDebug).Info("reconciling redis")
+	defer func() {
+		logger.V(logLevelDebug).Info("reconcile finished", "requeueAfter", result.RequeueAfter, "error", err)
+	}()
 
 	redis := &myappv1.Redis{}
 	defer func() {
 		// 状态赋值
 		redis.Status.Replicas = len(redis.Finalizers)
-		// 状态更新，偷懒忽略错误判断
-		_ = r.Status().Update(ctx, redis)
+		// 状态更新失败不影响本次调谐结果， 下次调谐会再次更新
+		if err := r.Status().Update(ctx, redis); err != nil {
+			logger.V(logLevelDebug).Info("unable to update redis status", "error", err.Error())
+		}
 	}()
 
-	err := r.Get(ctx, req.NamespacedName, redis)
+	err = r.Get(ctx, req.NamespacedName, redis)
 	if err != nil {
 		// 如果 err !=nil , k8s 调谐会不断重试。 因此找不到资源， 则直接返回 err=nil
 		// return ctrl.Result{}, fmt.Errorf("Reconcile 获取 redis 失败: %v", err)
 
 		// 找不到返回 nil，成功处理， 退出循环。
+		logger.V(logLevelDebug).Info("redis not found, skipping", "reason", err.Error())
+		metrics.Default.ForgetRedis(req.NamespacedName)
 		return ctrl.Result{}, nil
 	}
+	metrics.Default.TrackRedis(req.NamespacedName)
 
 	// 打印 redis 对象
-	output(redis)
+	r.logRedis(logger, redis)
+
+	// 记录调谐阶段及结果
+	phase := reconcilePhase(redis)
+	if redis.IsPaused() {
+		phase = metrics.PhasePaused
+	}
+	logger = logger.WithValues("phase", phase)
+	ctx = log.IntoContext(ctx, logger)
+	logger.Info("reconciling phase", "replicas", redis.Spec.Replicas, "pods", len(redis.Finalizers))
+
+	defer func() {
+		metrics.Default.ObserveReconcile(phase, result.Requeue || result.RequeueAfter > 0, err)
+		if err != nil {
+			r.EventRecord.Event(redis, events.ReasonReconcileFailed, err)
+		}
+		result, err = r.backoff(ctx, result, err)
+	}()
+
+	if phase == metrics.PhasePaused {
+		return r.pausedReconcile(ctx, redis)
+	}
+	r.resumeReconcile(redis)
 
+	switch phase {
+	case metrics.PhaseDelete:
+		return r.deleteReconcile(ctx, redis)
+	case metrics.PhaseScaleDown:
+		return r.decreaseReconcile(ctx, redis)
+	default:
+		return r.increaseReconcile(ctx, redis, phase)
+	}
+}
+
+// backoff 根据错误类型决定重试策略。
+// 终止性错误不立即重试， 避免权限不足等问题导致队列空转；
+// apiserver 建议了重试间隔时按照建议间隔重试； 其余错误交给 workqueue 指数退避。
+func (r *RedisReconciler) backoff(ctx context.Context, result ctrl.Result, err error) (ctrl.Result, error) {
+	if err == nil {
+		return result, nil
+	}
+
+	logger := log.FromContext(ctx)
+
+	if helper2.IsTerminalError(err) {
+		logger.Error(err, "terminal error, retrying later", "retryAfter", terminalErrorBackoff)
+		return ctrl.Result{RequeueAfter: terminalErrorBackoff}, nil
+	}
+
+	if delay, ok := helper2.RetryDelay(err); ok {
+		logger.V(logLevelDebug).Info("apiserver requested retry delay", "retryAfter", delay, "error", err.Error())
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+
+	return result, err
+}
+
+// reconcilePhase 根据 redis 当前状态判断本次调谐所处的阶段
+func reconcilePhase(redis *myappv1.Redis) string {
 	// 删除 逻辑
 	// IsZero 标识这个字段为 nil 或者 零值， 即非删除状态
 	// 删除状态则 取反
 	if !redis.DeletionTimestamp.IsZero() {
-		return r.deleteReconcile(ctx, redis)
+		return metrics.PhaseDelete
 	}
 
-	// 缩容
-	if len(redis.Finalizers) > redis.Spec.Replicas {
-		return r.decreaseReconcile(ctx, redis)
+	switch n := len(redis.Finalizers); {
+	case n > redis.Spec.Replicas:
+		// 缩容
+		return metrics.PhaseScaleDown
+	case n == 0:
+		return metrics.PhaseCreate
+	case n < redis.Spec.Replicas:
+		return metrics.PhaseScaleUp
 	}
 
-	return r.increaseReconcile(ctx, redis)
-
+	return metrics.PhaseSync
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *RedisReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// 按 owner UID 索引 pod， 调谐时一次从 cache 中取出全部所属 pod
+	if err := helper2.SetupIndexes(context.Background(), mgr.GetFieldIndexer()); err != nil {
+		return err
+	}
+
+	// pod 和 service 通过 OwnerReference 找到所属的 redis。
+	// operator 创建的 OwnerReference 不是 controller 引用， 因此不使用 Owns()。
+	owner := &handler.EnqueueRequestForOwner{
+		OwnerType:    &myappv1.Redis{},
+		IsController: false,
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&myappv1.Redis{}).
-		// 监听 pod 事件
+		For(&myappv1.Redis{}, builder.WithPredicates(predicates.Redis())).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+			RateLimiter:             r.RateLimiter,
+		}).
+		// 监听 pod 事件， 包括 pod 删除、 崩溃和就绪状态变化
+		Watches(
+			&source.Kind{Type: &corev1.Pod{}},
+			owner,
+			builder.WithPredicates(predicates.Pod()),
+		).
+		Watches(
+			&source.Kind{Type: &corev1.Service{}},
+			owner,
+			builder.WithPredicates(predicates.Service()),
+		).
+		// 只缓存 secret 的元数据， 密码或证书更新时更新连接信息
 		Watches(
-			&source.Kind{
-				Type: &corev1.Pod{},
-			},
-			handler.Funcs{
-				DeleteFunc: r.podDeleteHandler,
-			},
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.redisForSecret),
+			builder.OnlyMetadata,
 		).
 		Watches(
-			&source.Kind{
-				Type: &v1.Service{},
-			},
-			handler.Funcs{
-				DeleteFunc: r.podDeleteHandler,
-			},
+			&source.Kind{Type: &networkingv1.NetworkPolicy{}},
+			owner,
+			builder.WithPredicates(predicates.NetworkPolicy()),
 		).
 		Complete(r)
 }
 
-func (r *RedisReconciler) podDeleteHandler(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
-
-	ns := e.Object.GetNamespace()
-
-	for _, owner := range e.Object.GetOwnerReferences() {
-		// 非法 owner 不引起调谐
-		if owner.APIVersion != "myapp.tangx.in/v1" || owner.Kind != "Redis" {
-			continue
+// redisForSecret 找到 secret 所属或引用了 secret 的 redis
+func (r *RedisReconciler) redisForSecret(obj client.Object) []reconcile.Request {
+	var requests []reconcile.Request
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.APIVersion == myappv1.GroupVersion.String() && ref.Kind == "Redis" {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: ref.Name},
+			})
 		}
+	}
 
-		// 入队， 通知 redis operator 变更， 进行重新 调谐。
-		q.Add(
-			reconcile.Request{
-				NamespacedName: types.NamespacedName{
-					Namespace: ns,
-					Name:      owner.Name,
-				},
-			},
-		)
+	list, err := helper2.RedisForSecret2(context.Background(), r.Client, obj.GetNamespace(), obj.GetName())
+	if err != nil {
+		log.Log.Error(err, "unable to list redis referencing secret", "secret", client.ObjectKeyFromObject(obj))
+		return requests
+	}
+	for _, redis := range list {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&redis)})
 	}
+	return requests
 }
 
-func (r *RedisReconciler) increaseReconcile(ctx context.Context, redis *myappv1.Redis) (ctrl.Result, error) {
+func (r *RedisReconciler) increaseReconcile(ctx context.Context, redis *myappv1.Redis, phase string) (result ctrl.Result, err error) {
 
-	// 添加事件日志
-	r.EventRecord.Event(redis,
-		corev1.EventTypeNormal, "扩容",
-		fmt.Sprintf("%s 副本数设置为 %d", redis.Name, redis.Spec.Replicas),
-	)
+	// 字段冲突记录到 status 中， 由用户决定保留哪一方的修改
+	defer func() {
+		setConflictCondition(redis, err)
+	}()
+
+	// 添加事件日志， 副本数没有变化时不重复记录
+	if phase != metrics.PhaseSync {
+		r.EventRecord.Event(redis, events.ReasonScalingUp, redis.Name, redis.Spec.Replicas)
+	}
+
+	// 创建 service
+	if err := helper2.ApplyRedisService2(ctx, r.Client, r.apiReader(), redis, r.Scheme); err != nil {
+		return ctrl.Result{}, fmt.Errorf("apply redis service 失败: %w", err)
+	}
+
+	// 关闭 spec.networkPolicy 后删除之前创建的 NetworkPolicy
+	if redis.NetworkPolicyEnabled() {
+		if err := helper2.ApplyNetworkPolicy2(ctx, r.Client, r.apiReader(), redis, r.OperatorNamespace, r.Scheme); err != nil {
+			return ctrl.Result{}, fmt.Errorf("apply redis NetworkPolicy 失败: %w", err)
+		}
+	} else if err := helper2.DeleteNetworkPolicy2(ctx, r.Client, redis); err != nil {
+		return ctrl.Result{}, fmt.Errorf("删除 redis NetworkPolicy 失败: %w", err)
+	}
+
+	// 创建 ServiceMonitor, 集群中没有 CRD 时跳过
+	if redis.ServiceMonitorEnabled() && r.ServiceMonitorAvailable {
+		if err := helper2.ApplyServiceMonitor2(ctx, r.Client, redis, r.Scheme); err != nil {
+			return ctrl.Result{}, fmt.Errorf("apply redis ServiceMonitor 失败: %w", err)
+		}
+	}
+
+	// 开启 TLS 时证书签发后才创建 pod
+	if ready, err := r.reconcileTLS(ctx, redis); err != nil || !ready {
+		return ctrl.Result{RequeueAfter: tlsPendingInterval}, err
+	}
+	rotating, err := r.rotatePods(ctx, redis)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// 修改 spec.version 或 spec.image 后先升级从节点， 最后切换并升级主节点
+	upgrading, err := r.upgradePods(ctx, redis)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
 
 	// 创建 逻辑
-	err := helper2.CreateRedisPod2(ctx, r.Client, redis, r.Scheme)
+	recreated, err := helper2.CreateRedisPod2(ctx, r.Client, r.apiReader(), redis, r.Versions, r.Scheme)
+	for _, name := range recreated {
+		r.EventRecord.Event(redis, events.ReasonPodRecreated, name)
+	}
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("创建 redis pod 失败: %w", err)
+	}
+
+	// 发布应用使用的连接信息
+	binding, err := helper2.ApplyBindingSecret2(ctx, r.Client, r.apiReader(), redis, r.Scheme)
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("创建 redis pod 失败: %v", err)
+		return ctrl.Result{}, fmt.Errorf("apply 连接信息 secret 失败: %w", err)
 	}
+	redis.Status.Binding = &corev1.LocalObjectReference{Name: binding}
 
+	// pod 就绪状态变化会触发调谐， 不需要定时重新检查
+	ready, err := helper2.CountReadyPods2(ctx, r.Client, redis)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("获取 redis pod 状态失败: %w", err)
+	}
+	if d, ok := setReadyCondition(redis, ready, time.Now()); ok {
+		metrics.Default.ObserveReady(client.ObjectKeyFromObject(redis), d)
+	}
+	if upgrading {
+		return ctrl.Result{RequeueAfter: upgradeInterval}, nil
+	}
+	if rotating {
+		return ctrl.Result{RequeueAfter: tlsReloadInterval}, nil
+	}
+	if redis.TLSEnabled() && redis.Spec.TLS.IssuerRef == nil {
+		return ctrl.Result{RequeueAfter: tlsRecheckInterval}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
 func (r *RedisReconciler) decreaseReconcile(ctx context.Context, redis *myappv1.Redis) (ctrl.Result, error) {
 
-	r.EventRecord.Event(redis,
-		corev1.EventTypeWarning, "缩容",
-		fmt.Sprintf("%s 副本数设置为 %d", redis.Name, redis.Spec.Replicas),
-	)
+	r.EventRecord.Event(redis, events.ReasonScalingDown, redis.Name, redis.Spec.Replicas)
 
 	err := helper2.DecreaseRedis2(ctx, r.Client, redis)
 
 	return ctrl.Result{}, err
 }
 
-func (r *RedisReconciler) deleteReconcile(ctx context.Context, redis *myappv1.Redis) (ctrl.Result, error) {
-
-	r.EventRecord.Event(redis,
-		"Deleting", "删除",
-		fmt.Sprintf("删除 %s", redis.Name),
-	)
+// Ready condition 的 reason
+const (
+	reasonPodsReady    = "PodsReady"
+	reasonPodsNotReady = "PodsNotReady"
+)
 
-	err := helper2.DeleteRedis2(ctx, r.Client, redis)
-	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("删除 redis 失败:%v", err)
+// setReadyCondition 根据就绪 pod 数量更新 Ready condition。
+// 由未就绪变为就绪时返回未就绪持续的时间， 没有 Ready condition 的旧 redis 第一次就绪不计时。
+func setReadyCondition(redis *myappv1.Redis, ready int, now time.Time) (time.Duration, bool) {
+	prev := meta.FindStatusCondition(redis.Status.Conditions, myappv1.ConditionReady)
+
+	cond := metav1.Condition{
+		Type:               myappv1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: redis.Generation,
+		LastTransitionTime: metav1.NewTime(now),
+		Reason:             reasonPodsNotReady,
+		Message:            fmt.Sprintf("%d/%d pods ready", ready, redis.Spec.Replicas),
+	}
+	if ready >= redis.Spec.Replicas {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = reasonPodsReady
 	}
 
-	return ctrl.Result{}, nil
-}
-
-func output(v interface{}) {
-	data, err := json.Marshal(v)
-
-	if err != nil {
-		return
+	var since time.Time
+	if prev != nil && prev.Status == metav1.ConditionFalse {
+		since = prev.LastTransitionTime.Time
 	}
+	meta.SetStatusCondition(&redis.Status.Conditions, cond)
 
-	fmt.Printf("得到crd redis 对象: %s \n\n", data)
+	if since.IsZero() || cond.Status != metav1.ConditionTrue {
+		return 0, false
+	}
+	return now.Sub(since), true
 }
@@ -0,0 +1,525 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+	"github.com/tangx/k8s-operator-demo/controllers/events"
+	"github.com/tangx/k8s-operator-demo/controllers/helper2"
+)
+
+const (
+	timeout  = 20 * time.Second
+	interval = 250 * time.Millisecond
+)
+
+// 启动 RedisReconciler， 验证创建、 扩缩容、 pod 删除恢复以及 redis 删除的完整流程
+var _ = Describe("RedisReconciler", func() {
+
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	key := func(name string) types.NamespacedName {
+		return types.NamespacedName{Namespace: integrationNamespace, Name: name}
+	}
+
+	createRedis := func(name string, replicas int) *myappv1.Redis {
+		redis := &myappv1.Redis{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: integrationNamespace,
+			},
+			Spec: myappv1.RedisSpec{
+				Replicas: replicas,
+				Port:     6379,
+				Image:    "redis:5-alpine",
+			},
+		}
+		Expect(k8sClient.Create(ctx, redis)).To(Succeed())
+		return redis
+	}
+
+	getRedis := func(name string) *myappv1.Redis {
+		redis := &myappv1.Redis{}
+		Expect(k8sClient.Get(ctx, key(name), redis)).To(Succeed())
+		return redis
+	}
+
+	setReplicas := func(name string, replicas int) {
+		Eventually(func() error {
+			redis := getRedis(name)
+			redis.Spec.Replicas = replicas
+			return k8sClient.Update(ctx, redis)
+		}, timeout, interval).Should(Succeed())
+	}
+
+	// podNames 返回 redis 当前拥有的 pod 名称
+	podNames := func(name string) func() []string {
+		return func() []string {
+			pods := &corev1.PodList{}
+			Expect(k8sClient.List(ctx, pods,
+				client.InNamespace(integrationNamespace),
+				client.MatchingLabels{builder.LabelInstance: name},
+			)).To(Succeed())
+
+			var names []string
+			for _, pod := range pods.Items {
+				if pod.DeletionTimestamp.IsZero() {
+					names = append(names, pod.Name)
+				}
+			}
+			return names
+		}
+	}
+
+	finalizers := func(name string) func() []string {
+		return func() []string {
+			return getRedis(name).Finalizers
+		}
+	}
+
+	statusReplicas := func(name string) func() int {
+		return func() int {
+			return getRedis(name).Status.Replicas
+		}
+	}
+
+	// eventReasons 返回记录在 redis 上的事件 reason
+	eventReasons := func(name string) func() []string {
+		return func() []string {
+			list := &corev1.EventList{}
+			Expect(k8sClient.List(ctx, list,
+				client.InNamespace(integrationNamespace),
+				client.MatchingFields{"involvedObject.name": name},
+			)).To(Succeed())
+
+			var reasons []string
+			for _, e := range list.Items {
+				reasons = append(reasons, e.Reason)
+			}
+			return reasons
+		}
+	}
+
+	podName := func(name string, i int) string {
+		return fmt.Sprintf("%s-%d", name, i)
+	}
+
+	Context("when a redis is created", func() {
+		It("creates pods, a service, finalizers and status", func() {
+			name := "create"
+			createRedis(name, 2)
+
+			Eventually(podNames(name), timeout, interval).Should(ConsistOf(podName(name, 0), podName(name, 1)))
+			Eventually(finalizers(name), timeout, interval).Should(ConsistOf(podName(name, 0), podName(name, 1)))
+			Eventually(statusReplicas(name), timeout, interval).Should(Equal(2))
+
+			svc := &corev1.Service{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, key(name), svc)
+			}, timeout, interval).Should(Succeed())
+			Expect(svc.Spec.Selector).To(HaveKeyWithValue(builder.LabelInstance, name))
+			Expect(svc.OwnerReferences).To(HaveLen(1))
+			Expect(svc.OwnerReferences[0].Name).To(Equal(name))
+
+			pod := &corev1.Pod{}
+			Expect(k8sClient.Get(ctx, key(podName(name, 0)), pod)).To(Succeed())
+			Expect(pod.OwnerReferences).To(HaveLen(1))
+			Expect(pod.OwnerReferences[0].Kind).To(Equal("Redis"))
+			Expect(pod.Spec.Containers[0].Image).To(Equal("redis:5-alpine"))
+
+			// envtest 没有 kubelet， pod 不会就绪
+			Eventually(func() string {
+				redis := &myappv1.Redis{}
+				if err := k8sClient.Get(ctx, key(name), redis); err != nil {
+					return err.Error()
+				}
+				cond := meta.FindStatusCondition(redis.Status.Conditions, myappv1.ConditionReady)
+				if cond == nil {
+					return ""
+				}
+				return cond.Reason
+			}, timeout, interval).Should(Equal("PodsNotReady"))
+
+			Eventually(eventReasons(name), timeout, interval).Should(ContainElement(events.ReasonScalingUp))
+		})
+	})
+
+	Context("when replicas change", func() {
+		It("scales up", func() {
+			name := "scale-up"
+			createRedis(name, 1)
+			Eventually(podNames(name), timeout, interval).Should(HaveLen(1))
+
+			setReplicas(name, 3)
+
+			Eventually(podNames(name), timeout, interval).Should(ConsistOf(podName(name, 0), podName(name, 1), podName(name, 2)))
+			Eventually(finalizers(name), timeout, interval).Should(HaveLen(3))
+			Eventually(statusReplicas(name), timeout, interval).Should(Equal(3))
+		})
+
+		It("scales down", func() {
+			name := "scale-down"
+			createRedis(name, 3)
+			Eventually(finalizers(name), timeout, interval).Should(HaveLen(3))
+
+			setReplicas(name, 1)
+
+			Eventually(podNames(name), timeout, interval).Should(ConsistOf(podName(name, 0)))
+			Eventually(finalizers(name), timeout, interval).Should(ConsistOf(podName(name, 0)))
+			Eventually(statusReplicas(name), timeout, interval).Should(Equal(1))
+			Eventually(eventReasons(name), timeout, interval).Should(ContainElement(events.ReasonScalingDown))
+		})
+	})
+
+	Context("when a pod is deleted out of band", func() {
+		It("recreates the pod", func() {
+			name := "recover"
+			createRedis(name, 2)
+			Eventually(finalizers(name), timeout, interval).Should(HaveLen(2))
+
+			pod := &corev1.Pod{}
+			Expect(k8sClient.Get(ctx, key(podName(name, 1)), pod)).To(Succeed())
+			oldUID := pod.UID
+			Expect(k8sClient.Delete(ctx, pod)).To(Succeed())
+
+			Eventually(func() types.UID {
+				recreated := &corev1.Pod{}
+				if err := k8sClient.Get(ctx, key(podName(name, 1)), recreated); err != nil {
+					return oldUID
+				}
+				return recreated.UID
+			}, timeout, interval).ShouldNot(Equal(oldUID))
+
+			Expect(finalizers(name)()).To(HaveLen(2))
+			Eventually(eventReasons(name), timeout, interval).Should(ContainElement(events.ReasonPodRecreated))
+		})
+	})
+
+	Context("when a redis is paused", func() {
+		It("stops correcting drift until resumed", func() {
+			name := "paused"
+			createRedis(name, 2)
+			Eventually(finalizers(name), timeout, interval).Should(HaveLen(2))
+
+			setPaused := func(paused bool) {
+				Eventually(func() error {
+					redis := getRedis(name)
+					if paused {
+						redis.Annotations = map[string]string{myappv1.PausedAnnotation: "true"}
+					} else {
+						delete(redis.Annotations, myappv1.PausedAnnotation)
+					}
+					return k8sClient.Update(ctx, redis)
+				}, timeout, interval).Should(Succeed())
+			}
+			paused := func() bool {
+				return meta.IsStatusConditionTrue(getRedis(name).Status.Conditions, myappv1.ConditionPaused)
+			}
+
+			setPaused(true)
+			Eventually(paused, timeout, interval).Should(BeTrue())
+			Eventually(eventReasons(name), timeout, interval).Should(ContainElement(events.ReasonPaused))
+
+			// 暂停期间被删除的 pod 不会重建
+			pod := &corev1.Pod{}
+			Expect(k8sClient.Get(ctx, key(podName(name, 1)), pod)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, pod)).To(Succeed())
+			Eventually(podNames(name), timeout, interval).Should(ConsistOf(podName(name, 0)))
+			Consistently(podNames(name), 2*time.Second, interval).Should(ConsistOf(podName(name, 0)))
+
+			// 恢复后补齐缺失的 pod
+			setPaused(false)
+			Eventually(paused, timeout, interval).Should(BeFalse())
+			Eventually(podNames(name), timeout, interval).Should(ConsistOf(podName(name, 0), podName(name, 1)))
+			Eventually(eventReasons(name), timeout, interval).Should(ContainElement(events.ReasonResumed))
+		})
+	})
+
+	Context("when another client changes an owned field", func() {
+		It("reports the apply conflict in status", func() {
+			name := "conflict"
+			createRedis(name, 1)
+			Eventually(finalizers(name), timeout, interval).Should(HaveLen(1))
+
+			svc := &corev1.Service{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, key(name), svc)
+			}, timeout, interval).Should(Succeed())
+			Expect(svc.ManagedFields).NotTo(BeEmpty())
+			Expect(svc.ManagedFields[0].Manager).To(Equal(helper2.FieldManager))
+
+			// 其他 field manager 修改 operator 拥有的字段
+			svc.Spec.Ports[0].Port = 16379
+			Expect(k8sClient.Update(ctx, svc, client.FieldOwner("someone-else"))).To(Succeed())
+
+			Eventually(func() bool {
+				return meta.IsStatusConditionTrue(getRedis(name).Status.Conditions, myappv1.ConditionApplyConflict)
+			}, timeout, interval).Should(BeTrue())
+		})
+	})
+
+	Context("when a redis is ready for clients", func() {
+		It("publishes a Service Binding secret and updates it with the password", func() {
+			name := "binding"
+			auth := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: name + "-auth", Namespace: integrationNamespace},
+				Data:       map[string][]byte{"password": []byte("old")},
+			}
+			Expect(k8sClient.Create(ctx, auth)).To(Succeed())
+
+			redis := &myappv1.Redis{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: integrationNamespace},
+				Spec: myappv1.RedisSpec{
+					Replicas: 1,
+					Port:     6379,
+					Image:    "redis:5-alpine",
+					Auth: &myappv1.RedisAuth{PasswordSecret: corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: auth.Name},
+						Key:                  "password",
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, redis)).To(Succeed())
+
+			Eventually(func() *corev1.LocalObjectReference {
+				return getRedis(name).Status.Binding
+			}, timeout, interval).Should(Equal(&corev1.LocalObjectReference{Name: builder.BindingSecretName(redis)}))
+
+			password := func() string {
+				secret := &corev1.Secret{}
+				if err := k8sClient.Get(ctx, key(builder.BindingSecretName(redis)), secret); err != nil {
+					return ""
+				}
+				return string(secret.Data[builder.BindingPassword])
+			}
+			Eventually(password, timeout, interval).Should(Equal("old"))
+
+			auth.Data["password"] = []byte("new")
+			Expect(k8sClient.Update(ctx, auth)).To(Succeed())
+			Eventually(password, timeout, interval).Should(Equal("new"))
+		})
+	})
+
+	Context("when a network policy is enabled", func() {
+		It("owns a NetworkPolicy that follows the spec", func() {
+			name := "netpol"
+			createRedis(name, 1)
+			Eventually(finalizers(name), timeout, interval).Should(HaveLen(1))
+
+			Eventually(func() error {
+				redis := getRedis(name)
+				redis.Spec.NetworkPolicy = &myappv1.RedisNetworkPolicy{Enabled: true}
+				return k8sClient.Update(ctx, redis)
+			}, timeout, interval).Should(Succeed())
+
+			np := &networkingv1.NetworkPolicy{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, key(name), np)
+			}, timeout, interval).Should(Succeed())
+			Expect(np.Spec.PodSelector.MatchLabels).To(HaveKeyWithValue(builder.LabelInstance, name))
+			Expect(*np.Spec.Ingress[0].Ports[0].Port).To(Equal(intstr.FromInt(6379)))
+
+			Eventually(func() error {
+				redis := getRedis(name)
+				redis.Spec.NetworkPolicy.Enabled = false
+				return k8sClient.Update(ctx, redis)
+			}, timeout, interval).Should(Succeed())
+			Eventually(func() bool {
+				return apierrors.IsNotFound(k8sClient.Get(ctx, key(name), &networkingv1.NetworkPolicy{}))
+			}, timeout, interval).Should(BeTrue())
+		})
+	})
+
+	Context("when TLS is enabled", func() {
+		It("issues a certificate from the operator CA and mounts it", func() {
+			name := "tls"
+			redis := &myappv1.Redis{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: integrationNamespace,
+				},
+				Spec: myappv1.RedisSpec{
+					Replicas: 1,
+					Port:     6379,
+					Image:    "redis:6-alpine",
+					TLS:      &myappv1.RedisTLS{Enabled: true},
+				},
+			}
+			Expect(k8sClient.Create(ctx, redis)).To(Succeed())
+			Eventually(podNames(name), timeout, interval).Should(HaveLen(1))
+
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, key(builder.TLSSecretName(redis)), secret)).To(Succeed())
+			Expect(secret.Data).To(HaveKey(builder.TLSCAKey))
+			Expect(k8sClient.Get(ctx, key(helper2.CASecretName), &corev1.Secret{})).To(Succeed())
+
+			Eventually(func() bool {
+				return meta.IsStatusConditionTrue(getRedis(name).Status.Conditions, myappv1.ConditionTLSReady)
+			}, timeout, interval).Should(BeTrue())
+			status := getRedis(name).Status.TLS
+			Expect(status).NotTo(BeNil())
+
+			pod := &corev1.Pod{}
+			Expect(k8sClient.Get(ctx, key(podName(name, 0)), pod)).To(Succeed())
+			Expect(pod.Annotations).To(HaveKeyWithValue(myappv1.TLSHashAnnotation, status.CertificateHash))
+			Expect(pod.Spec.Containers[0].Args).To(ContainElement("--tls-port"))
+			Expect(pod.Spec.Volumes[0].Secret.SecretName).To(Equal(builder.TLSSecretName(redis)))
+		})
+
+		It("resolves spec.version through the catalog and upgrades the pods", func() {
+			name := "version"
+			redis := &myappv1.Redis{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: integrationNamespace,
+				},
+				Spec: myappv1.RedisSpec{Replicas: 1, Port: 6379, Version: "6.2"},
+			}
+			Expect(k8sClient.Create(ctx, redis)).To(Succeed())
+
+			image := func() string {
+				pod := &corev1.Pod{}
+				if err := k8sClient.Get(ctx, key(podName(name, 0)), pod); err != nil {
+					return ""
+				}
+				return pod.Spec.Containers[0].Image
+			}
+			Eventually(image, timeout, interval).Should(Equal("redis:6.2-alpine"))
+			Eventually(func() *myappv1.VersionStatus {
+				return getRedis(name).Status.Version
+			}, timeout, interval).Should(Equal(&myappv1.VersionStatus{Target: "6.2"}))
+
+			// envtest 中 pod 不会就绪， 没有就绪的 pod 直接使用新镜像重建
+			Eventually(func() error {
+				redis := getRedis(name)
+				redis.Spec.Version = "7.0"
+				return k8sClient.Update(ctx, redis)
+			}, timeout, interval).Should(Succeed())
+			Eventually(image, timeout, interval).Should(Equal("redis:7.0-alpine"))
+			Eventually(eventReasons(name), timeout, interval).Should(ContainElement(events.ReasonPodUpgraded))
+			Eventually(func() bool {
+				return meta.IsStatusConditionTrue(getRedis(name).Status.Conditions, myappv1.ConditionUpgrading)
+			}, timeout, interval).Should(BeTrue())
+		})
+	})
+
+	Context("when a redis is deleted", func() {
+		It("deletes its pods and removes the finalizers", func() {
+			name := "delete"
+			redis := createRedis(name, 2)
+			Eventually(finalizers(name), timeout, interval).Should(HaveLen(2))
+
+			Expect(k8sClient.Delete(ctx, redis)).To(Succeed())
+
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, key(name), &myappv1.Redis{})
+				return apierrors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+			Eventually(podNames(name), timeout, interval).Should(BeEmpty())
+			Eventually(eventReasons(name), timeout, interval).Should(ContainElement(events.ReasonDeletionStarted))
+		})
+	})
+
+	Context("when a redis with a deletion policy is deleted", func() {
+		setPolicy := func(name string, policy *myappv1.DeletionPolicy) {
+			Eventually(func() error {
+				redis := getRedis(name)
+				redis.Spec.DeletionPolicy = policy
+				return k8sClient.Update(ctx, redis)
+			}, timeout, interval).Should(Succeed())
+		}
+
+		It("waits for the grace period before deleting pods", func() {
+			name := "grace"
+			createRedis(name, 1)
+			Eventually(finalizers(name), timeout, interval).Should(HaveLen(1))
+			setPolicy(name, &myappv1.DeletionPolicy{GracePeriod: &metav1.Duration{Duration: 3 * time.Second}})
+
+			Expect(k8sClient.Delete(ctx, getRedis(name))).To(Succeed())
+
+			Eventually(func() string {
+				cond := meta.FindStatusCondition(getRedis(name).Status.Conditions, myappv1.ConditionTerminating)
+				if cond == nil {
+					return ""
+				}
+				return cond.Reason
+			}, timeout, interval).Should(Equal("GracePeriod"))
+			Expect(podNames(name)()).To(HaveLen(1))
+
+			Eventually(func() bool {
+				return apierrors.IsNotFound(k8sClient.Get(ctx, key(name), &myappv1.Redis{}))
+			}, timeout, interval).Should(BeTrue())
+		})
+
+		It("retains pods in Retain mode", func() {
+			name := "retain"
+			createRedis(name, 2)
+			Eventually(finalizers(name), timeout, interval).Should(HaveLen(2))
+			setPolicy(name, &myappv1.DeletionPolicy{Mode: myappv1.DeletionModeRetain})
+
+			Expect(k8sClient.Delete(ctx, getRedis(name))).To(Succeed())
+
+			Eventually(func() bool {
+				return apierrors.IsNotFound(k8sClient.Get(ctx, key(name), &myappv1.Redis{}))
+			}, timeout, interval).Should(BeTrue())
+			Consistently(podNames(name), 2*time.Second, interval).Should(HaveLen(2))
+
+			pod := &corev1.Pod{}
+			Expect(k8sClient.Get(ctx, key(podName(name, 0)), pod)).To(Succeed())
+			Expect(pod.OwnerReferences).To(BeEmpty())
+			Expect(pod.Annotations).To(HaveKey(myappv1.RetainedAnnotation))
+			Eventually(eventReasons(name), timeout, interval).Should(ContainElement(events.ReasonPodsRetained))
+		})
+	})
+})
+
+var _ = Describe("setReadyCondition", func() {
+	It("measures only not ready to ready transitions", func() {
+		redis := &myappv1.Redis{Spec: myappv1.RedisSpec{Replicas: 2}}
+		start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+
+		_, ok := setReadyCondition(redis, 0, start)
+		Expect(ok).To(BeFalse())
+		_, ok = setReadyCondition(redis, 1, start.Add(10*time.Second))
+		Expect(ok).To(BeFalse())
+
+		// 从第一次未就绪开始计时
+		d, ok := setReadyCondition(redis, 2, start.Add(30*time.Second))
+		Expect(ok).To(BeTrue())
+		Expect(d).To(Equal(30 * time.Second))
+
+		// 保持就绪不再记录
+		_, ok = setReadyCondition(redis, 2, start.Add(60*time.Second))
+		Expect(ok).To(BeFalse())
+
+		setReadyCondition(redis, 1, start.Add(100*time.Second))
+		d, ok = setReadyCondition(redis, 2, start.Add(120*time.Second))
+		Expect(ok).To(BeTrue())
+		Expect(d).To(Equal(20 * time.Second))
+	})
+
+	It("does not measure a redis without a Ready condition", func() {
+		redis := &myappv1.Redis{Spec: myappv1.RedisSpec{Replicas: 1}}
+		_, ok := setReadyCondition(redis, 1, time.Now())
+		Expect(ok).To(BeFalse())
+		Expect(meta.IsStatusConditionTrue(redis.Status.Conditions, myappv1.ConditionReady)).To(BeTrue())
+	})
+})
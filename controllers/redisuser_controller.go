@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	redisbuilder "github.com/tangx/k8s-operator-demo/controllers/builder"
+	"github.com/tangx/k8s-operator-demo/controllers/helper2"
+	"github.com/tangx/k8s-operator-demo/controllers/predicates"
+)
+
+const (
+	// userRetryInterval 部分 pod 同步失败或删除失败时的重试间隔
+	userRetryInterval = 30 * time.Second
+	// userResyncInterval 定期重新同步， 修正在 redis 中被直接修改的用户
+	userResyncInterval = 5 * time.Minute
+)
+
+// Synced condition 的 reason
+const (
+	reasonUserSynced     = "Synced"
+	reasonUserSyncFailed = "SyncFailed"
+	reasonRedisNotFound  = "RedisNotFound"
+	reasonNoRunningPods  = "NoRunningPods"
+	reasonUnsupported    = "Unsupported"
+	reasonReservedUser   = "ReservedUsername"
+)
+
+// RedisUserReconciler 把 RedisUser 同步为 redis 中的 ACL 用户
+type RedisUserReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// APIReader 直接读取 apiserver， 用于读取不在 cache 中的 secret 和其他分片的 redis。 为 nil 时使用 Client
+	APIReader client.Reader
+}
+
+//+kubebuilder:rbac:groups=myapp.tangx.in,resources=redisusers,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=myapp.tangx.in,resources=redisusers/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=myapp.tangx.in,resources=redisusers/finalizers,verbs=update
+
+// Reconcile 在 redis 的每个运行中的 pod 上创建或更新用户， RedisUser 删除时删除用户
+func (r *RedisUserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("redisUser", req.Name)
+	ctx = log.IntoContext(ctx, logger)
+
+	user := &myappv1.RedisUser{}
+	if err := r.Get(ctx, req.NamespacedName, user); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	redisKey := types.NamespacedName{Namespace: user.Namespace, Name: user.Spec.RedisRef.Name}
+	redis := &myappv1.Redis{}
+	err := r.Get(ctx, redisKey, redis)
+	switch {
+	case apierrors.IsNotFound(err):
+		// 按分片选择器过滤的 cache 中查不到其他分片的 redis， 从 apiserver 确认 redis 确实不存在。
+		// redis 属于其他分片时由那个分片同步和删除用户， 这里不修改 finalizer 和 status
+		if err := r.apiReader().Get(ctx, redisKey, &myappv1.Redis{}); !apierrors.IsNotFound(err) {
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			logger.V(logLevelDebug).Info("redis not in this shard, skipping", "redis", redisKey.Name)
+			return ctrl.Result{}, nil
+		}
+		redis = nil
+	case err != nil:
+		return ctrl.Result{}, err
+	case !redis.DeletionTimestamp.IsZero():
+		redis = nil
+	}
+	// 关闭 webhook 时 default 用户也可能被创建， 修改或删除它会让 operator 无法访问 redis
+	reserved := user.ACLUsername() == myappv1.ReservedUsername
+	if reserved {
+		redis = nil
+	}
+
+	if !user.DeletionTimestamp.IsZero() {
+		return r.deleteUser(ctx, user, redis)
+	}
+
+	if !controllerutil.ContainsFinalizer(user, myappv1.RedisUserFinalizer) {
+		original := user.DeepCopy()
+		controllerutil.AddFinalizer(user, myappv1.RedisUserFinalizer)
+		if err := r.Patch(ctx, user, client.MergeFrom(original)); err != nil {
+			return ctrl.Result{}, fmt.Errorf("添加 finalizer 失败: %w", err)
+		}
+	}
+
+	defer func() {
+		// 状态更新失败不影响本次调谐结果， 下次调谐会再次更新
+		if err := r.Status().Update(ctx, user); err != nil {
+			logger.V(logLevelDebug).Info("unable to update redis user status", "error", err.Error())
+		}
+	}()
+	user.Status.ObservedGeneration = user.Generation
+
+	if reserved {
+		user.Status.Pods = nil
+		setCondition(&user.Status.Conditions, user.Generation, myappv1.ConditionSynced, false, reasonReservedUser,
+			fmt.Sprintf("User %s is managed by spec.auth of the Redis and cannot be changed", myappv1.ReservedUsername))
+		return ctrl.Result{}, nil
+	}
+	if redis == nil {
+		user.Status.Pods = nil
+		setCondition(&user.Status.Conditions, user.Generation, myappv1.ConditionSynced, false, reasonRedisNotFound, fmt.Sprintf("Redis %s not found", user.Spec.RedisRef.Name))
+		return ctrl.Result{}, nil
+	}
+
+	pods, err := helper2.SyncUser2(ctx, r.Client, r.apiReader(), redis, user)
+	if helper2.IsUnsupported(err) {
+		// 升级 redis 后 pod 重建会触发调谐
+		user.Status.Pods = pods
+		setCondition(&user.Status.Conditions, user.Generation, myappv1.ConditionSynced, false, reasonUnsupported, err.Error())
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		setCondition(&user.Status.Conditions, user.Generation, myappv1.ConditionSynced, false, reasonUserSyncFailed, err.Error())
+		return ctrl.Result{}, fmt.Errorf("同步用户失败: %w", err)
+	}
+	user.Status.Pods = pods
+
+	if failed := failedPods(pods); len(failed) > 0 {
+		setCondition(&user.Status.Conditions, user.Generation, myappv1.ConditionSynced, false, reasonUserSyncFailed, fmt.Sprintf("Failed on pods: %s", strings.Join(failed, ", ")))
+		return ctrl.Result{RequeueAfter: userRetryInterval}, nil
+	}
+	if len(pods) == 0 {
+		// pod 开始运行后会触发调谐
+		setCondition(&user.Status.Conditions, user.Generation, myappv1.ConditionSynced, false, reasonNoRunningPods, "No running pods")
+		return ctrl.Result{}, nil
+	}
+
+	logger.V(logLevelDebug).Info("synced redis user", "pods", len(pods))
+	setCondition(&user.Status.Conditions, user.Generation, myappv1.ConditionSynced, true, reasonUserSynced, fmt.Sprintf("Synced to %d pods", len(pods)))
+	return ctrl.Result{RequeueAfter: userResyncInterval}, nil
+}
+
+// deleteUser 从全部运行中的 pod 上删除用户后移除 finalizer。
+// redis 已经删除时用户随 pod 一起消失， default 用户不能删除， 这两种情况直接移除 finalizer。
+func (r *RedisUserReconciler) deleteUser(ctx context.Context, user *myappv1.RedisUser, redis *myappv1.Redis) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(user, myappv1.RedisUserFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	if redis != nil {
+		pods, err := helper2.DeleteUser2(ctx, r.Client, r.apiReader(), redis, user.ACLUsername())
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("删除用户失败: %w", err)
+		}
+		if failed := failedPods(pods); len(failed) > 0 {
+			user.Status.Pods = pods
+			setCondition(&user.Status.Conditions, user.Generation, myappv1.ConditionSynced, false, reasonUserSyncFailed, fmt.Sprintf("Failed to delete the user on pods: %s", strings.Join(failed, ", ")))
+			if err := r.Status().Update(ctx, user); err != nil {
+				log.FromContext(ctx).V(logLevelDebug).Info("unable to update redis user status", "error", err.Error())
+			}
+			return ctrl.Result{RequeueAfter: userRetryInterval}, nil
+		}
+	}
+
+	original := user.DeepCopy()
+	controllerutil.RemoveFinalizer(user, myappv1.RedisUserFinalizer)
+	if err := r.Patch(ctx, user, client.MergeFrom(original)); err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, fmt.Errorf("移除 finalizer 失败: %w", err)
+	}
+	log.FromContext(ctx).Info("deleted redis user")
+	return ctrl.Result{}, nil
+}
+
+// apiReader 读取不在 cache 中的对象使用的 reader， 例如 secret 和其他分片的 redis
+func (r *RedisUserReconciler) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RedisUserReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := helper2.SetupUserIndexes(context.Background(), mgr.GetFieldIndexer()); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&myappv1.RedisUser{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// redis 创建或删除
+		Watches(
+			&source.Kind{Type: &myappv1.Redis{}},
+			handler.EnqueueRequestsFromMapFunc(r.usersFor(helper2.UserRedisIndex, func(obj client.Object) string {
+				return obj.GetName()
+			})),
+			builder.WithPredicates(predicates.Redis()),
+		).
+		// pod 重建或重启后 ACL 丢失， 需要重新同步
+		Watches(
+			&source.Kind{Type: &corev1.Pod{}},
+			handler.EnqueueRequestsFromMapFunc(r.usersFor(helper2.UserRedisIndex, func(obj client.Object) string {
+				if obj.GetLabels()[redisbuilder.LabelManagedBy] != redisbuilder.ManagedBy {
+					return ""
+				}
+				return obj.GetLabels()[redisbuilder.LabelInstance]
+			})),
+			builder.WithPredicates(predicates.Pod()),
+		).
+		// 密码更新
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.usersFor(helper2.UserSecretIndex, func(obj client.Object) string {
+				return obj.GetName()
+			})),
+			builder.OnlyMetadata,
+		).
+		Complete(r)
+}
+
+// usersFor 通过索引找到与对象相关的 RedisUser， value 返回空字符串时忽略对象
+func (r *RedisUserReconciler) usersFor(field string, value func(obj client.Object) string) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		v := value(obj)
+		if v == "" {
+			return nil
+		}
+		users, err := helper2.ListUsers2(context.Background(), r.Client, obj.GetNamespace(), field, v)
+		if err != nil {
+			log.Log.Error(err, "unable to list redis users", "field", field, "value", v)
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(users))
+		for i := range users {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&users[i])})
+		}
+		return requests
+	}
+}
+
+func failedPods(pods []myappv1.RedisUserPodStatus) []string {
+	var failed []string
+	for _, pod := range pods {
+		if !pod.Synced {
+			failed = append(failed, pod.Name)
+		}
+	}
+	return failed
+}
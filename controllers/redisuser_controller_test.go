@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/fakeclient"
+)
+
+// envtest 中 pod 不会运行， 这里验证 finalizer 和状态， ACL 命令由 helper2 的单元测试覆盖
+var _ = Describe("RedisUserReconciler", func() {
+
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	key := func(name string) types.NamespacedName {
+		return types.NamespacedName{Namespace: integrationNamespace, Name: name}
+	}
+
+	getUser := func(name string) *myappv1.RedisUser {
+		user := &myappv1.RedisUser{}
+		Expect(k8sClient.Get(ctx, key(name), user)).To(Succeed())
+		return user
+	}
+
+	syncedReason := func(name string) func() string {
+		return func() string {
+			cond := meta.FindStatusCondition(getUser(name).Status.Conditions, myappv1.ConditionSynced)
+			if cond == nil {
+				return ""
+			}
+			return cond.Reason
+		}
+	}
+
+	It("adds a finalizer, reports the sync state and removes the finalizer on deletion", func() {
+		name := "acl-user"
+		user := &myappv1.RedisUser{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: integrationNamespace},
+			Spec: myappv1.RedisUserSpec{
+				RedisRef: corev1.LocalObjectReference{Name: "acl"},
+				PasswordSecret: corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "acl-user-password"},
+					Key:                  "password",
+				},
+				Commands: []string{"+@read"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, user)).To(Succeed())
+
+		Eventually(func() []string {
+			return getUser(name).Finalizers
+		}, timeout, interval).Should(ContainElement(myappv1.RedisUserFinalizer))
+		Eventually(syncedReason(name), timeout, interval).Should(Equal(reasonRedisNotFound))
+
+		redis := &myappv1.Redis{
+			ObjectMeta: metav1.ObjectMeta{Name: "acl", Namespace: integrationNamespace},
+			Spec:       myappv1.RedisSpec{Replicas: 1, Port: 6379, Image: "redis:6-alpine"},
+		}
+		Expect(k8sClient.Create(ctx, redis)).To(Succeed())
+		Eventually(syncedReason(name), timeout, interval).Should(Equal(reasonNoRunningPods))
+
+		Expect(k8sClient.Delete(ctx, getUser(name))).To(Succeed())
+		Eventually(func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, key(name), &myappv1.RedisUser{}))
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("never manages the default user when the webhook is bypassed", func() {
+		name := "acl-default"
+		user := &myappv1.RedisUser{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: integrationNamespace},
+			Spec: myappv1.RedisUserSpec{
+				RedisRef: corev1.LocalObjectReference{Name: "acl"},
+				Username: myappv1.ReservedUsername,
+				PasswordSecret: corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "acl-user-password"},
+					Key:                  "password",
+				},
+				Commands: []string{"+@all"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, user)).To(Succeed())
+		Eventually(syncedReason(name), timeout, interval).Should(Equal(reasonReservedUser))
+
+		Expect(k8sClient.Delete(ctx, getUser(name))).To(Succeed())
+		Eventually(func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, key(name), &myappv1.RedisUser{}))
+		}, timeout, interval).Should(BeTrue())
+	})
+})
+
+// 分片 operator 的 cache 中没有其他分片的 redis， client 和 APIReader 分别模拟 cache 和 apiserver
+var _ = Describe("RedisUserReconciler with a redis in another shard", func() {
+	for _, deleting := range []bool{false, true} {
+		deleting := deleting
+		It(fmt.Sprintf("leaves the finalizer and status to the owning shard (deleting: %v)", deleting), func() {
+			ctx := context.Background()
+			user := &myappv1.RedisUser{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "other-shard-user",
+					Namespace:  "default",
+					Finalizers: []string{myappv1.RedisUserFinalizer},
+				},
+				Spec: myappv1.RedisUserSpec{RedisRef: corev1.LocalObjectReference{Name: "other-shard"}},
+			}
+			if deleting {
+				now := metav1.Now()
+				user.DeletionTimestamp = &now
+			}
+			redis := &myappv1.Redis{ObjectMeta: metav1.ObjectMeta{Name: "other-shard", Namespace: "default"}}
+
+			c := fakeclient.New(user)
+			r := &RedisUserReconciler{Client: c, Scheme: c.Scheme(), APIReader: fakeclient.New(redis)}
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(user)})
+			Expect(err).NotTo(HaveOccurred())
+
+			latest := &myappv1.RedisUser{}
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(user), latest)).To(Succeed())
+			Expect(latest.Finalizers).To(ConsistOf(myappv1.RedisUserFinalizer))
+			Expect(latest.Status.Conditions).To(BeEmpty())
+			Expect(c.Calls(fakeclient.Patch) + c.Calls(fakeclient.Update)).To(BeZero())
+		})
+	}
+})
@@ -0,0 +1,111 @@
+// Package render 离线渲染 redis 对应的 k8s 对象， 不需要连接集群。
+// 用于在 GitOps PR 中 review redis 变更最终会生成哪些对象。
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/builder"
+)
+
+// DefaultNamespace redis 没有指定命名空间时使用的命名空间
+const DefaultNamespace = "default"
+
+// Options 渲染使用的 operator 配置， 与 operator 的配置相同时渲染结果与 operator 创建的对象一致
+type Options struct {
+	// Defaults operator 配置中的默认镜像和版本目录
+	Defaults appv1.OperatorDefaults
+
+	// OperatorNamespace operator 所在的 namespace， 为空时 NetworkPolicy 中不包含 operator
+	OperatorNamespace string
+}
+
+// Load 读取 YAML 或 JSON 格式的 redis， 支持多文档
+func Load(r io.Reader) ([]*appv1.Redis, error) {
+
+	var list []*appv1.Redis
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for i := 0; ; i++ {
+		raw := runtime.RawExtension{}
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("解析第 %d 个文档失败: %w", i+1, err)
+		}
+
+		raw.Raw = bytes.TrimSpace(raw.Raw)
+		if len(raw.Raw) == 0 || bytes.Equal(raw.Raw, []byte("null")) {
+			continue
+		}
+
+		redis := &appv1.Redis{}
+		if err := yaml.UnmarshalStrict(raw.Raw, redis); err != nil {
+			return nil, fmt.Errorf("解析第 %d 个文档失败: %w", i+1, err)
+		}
+
+		gvk := redis.GroupVersionKind()
+		if gvk.GroupVersion() != appv1.GroupVersion || gvk.Kind != "Redis" {
+			return nil, fmt.Errorf("第 %d 个文档不是 %s Redis: %s", i+1, appv1.GroupVersion, gvk)
+		}
+
+		list = append(list, redis)
+	}
+
+	return list, nil
+}
+
+// Objects 对 redis 执行 webhook 中的默认值和校验逻辑， 返回生成的全部对象
+func Objects(redis *appv1.Redis, opts Options, scheme *runtime.Scheme) ([]client.Object, error) {
+
+	if redis.Namespace == "" {
+		redis.Namespace = DefaultNamespace
+	}
+
+	redis.Default(opts.Defaults)
+	if err := redis.ValidateCreate(opts.Defaults); err != nil {
+		return nil, fmt.Errorf("redis %s/%s 校验失败: %w", redis.Namespace, redis.Name, err)
+	}
+
+	return builder.Build(redis, builder.Options{
+		OperatorNamespace: opts.OperatorNamespace,
+		Versions:          opts.Defaults.Versions,
+	}, scheme)
+}
+
+// Render 读取 in 中的 redis， 将生成的对象以 YAML 格式写入 out
+func Render(in io.Reader, out io.Writer, opts Options, scheme *runtime.Scheme) error {
+
+	list, err := Load(in)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("没有找到 redis 对象")
+	}
+
+	for _, redis := range list {
+		objs, err := Objects(redis, opts, scheme)
+		if err != nil {
+			return err
+		}
+
+		data, err := builder.RenderYAML(objs, scheme)
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
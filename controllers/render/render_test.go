@@ -0,0 +1,136 @@
+package render
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+func testScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(appv1.AddToScheme(scheme))
+	return scheme
+}
+
+func TestRenderAppliesDefaults(t *testing.T) {
+	in := `
+apiVersion: myapp.tangx.in/v1
+kind: Redis
+metadata:
+  name: cache
+spec:
+  replicas: 1
+  image: redis:6-alpine
+  port: 6379
+  monitoring:
+    enabled: true
+---
+apiVersion: myapp.tangx.in/v1
+kind: Redis
+metadata:
+  name: session
+  namespace: team-a
+spec:
+  replicas: 2
+  image: redis:6-alpine
+  port: 6379
+`
+	out := &bytes.Buffer{}
+	if err := Render(strings.NewReader(in), out, Options{}, testScheme()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := out.String()
+	for _, want := range []string{
+		// 没有指定命名空间时使用 default
+		"name: cache-0\n  namespace: default",
+		// webhook 默认值补全了 exporter 镜像和端口
+		"image: " + appv1.DefaultExporterImage,
+		"containerPort: 9121",
+		"name: session-1\n  namespace: team-a",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestRenderUsesOperatorConfig(t *testing.T) {
+	in := `
+apiVersion: myapp.tangx.in/v1
+kind: Redis
+metadata:
+  name: cache
+spec:
+  replicas: 1
+  port: 6379
+  networkPolicy:
+    enabled: true
+---
+apiVersion: myapp.tangx.in/v1
+kind: Redis
+metadata:
+  name: session
+spec:
+  replicas: 1
+  port: 6379
+  version: "6.2"
+`
+	opts := Options{
+		Defaults: appv1.OperatorDefaults{
+			Image:    "registry.local/redis:6.0",
+			Versions: appv1.VersionCatalog{{Version: "6.2", Image: "registry.local/redis:6.2", RDBVersion: 9}},
+		},
+		OperatorNamespace: "redis-operator-system",
+	}
+	out := &bytes.Buffer{}
+	if err := Render(strings.NewReader(in), out, opts, testScheme()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := out.String()
+	for _, want := range []string{
+		"image: registry.local/redis:6.0",
+		"image: registry.local/redis:6.2",
+		"kubernetes.io/metadata.name: redis-operator-system",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestRenderRejectsInvalidRedis(t *testing.T) {
+	in := `
+apiVersion: myapp.tangx.in/v1
+kind: Redis
+metadata:
+  name: cache
+spec:
+  replicas: 1
+  port: 1234
+`
+	err := Render(strings.NewReader(in), &bytes.Buffer{}, Options{}, testScheme())
+	if err == nil || !strings.Contains(err.Error(), "校验失败") {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+}
+
+func TestLoadRejectsOtherKinds(t *testing.T) {
+	in := `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cache
+`
+	if _, err := Load(strings.NewReader(in)); err == nil {
+		t.Fatal("expected error for non-Redis document")
+	}
+}
@@ -0,0 +1,204 @@
+// Package resp 是 operator 直接访问 redis pod 使用的最小 RESP 客户端。
+// 只支持请求-响应形式的命令， 不支持 pipeline、 订阅和集群重定向。
+package resp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error redis 返回的错误响应， 例如 ERR unknown command
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Conn 到单个 redis 的连接， 不能并发使用
+type Conn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+// Dial 连接 redis， password 不为空时执行 AUTH
+func Dial(ctx context.Context, addr string, password string, timeout time.Duration) (*Conn, error) {
+	return DialTLS(ctx, addr, password, timeout, nil)
+}
+
+// DialTLS 通过 TLS 连接 redis， config 为 nil 时使用明文连接
+func DialTLS(ctx context.Context, addr string, password string, timeout time.Duration, config *tls.Config) (*Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	nc, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if config != nil {
+		tc := tls.Client(nc, config)
+		// go 1.16 没有 HandshakeContext， 通过 deadline 限制握手时间
+		if timeout > 0 {
+			_ = tc.SetDeadline(time.Now().Add(timeout))
+		}
+		if err := tc.Handshake(); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("TLS 握手失败: %w", err)
+		}
+		nc = tc
+	}
+
+	c := NewConn(nc, timeout)
+	if password != "" {
+		if _, err := c.Do("AUTH", password); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("AUTH 失败: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// NewConn 使用已经建立的连接， timeout 为每条命令的读写超时， 为 0 时不设置超时
+func NewConn(nc net.Conn, timeout time.Duration) *Conn {
+	return &Conn{
+		conn:    nc,
+		reader:  bufio.NewReader(nc),
+		timeout: timeout,
+	}
+}
+
+// PeerCertificates 返回 redis 在 TLS 握手中出示的证书， 明文连接返回 nil
+func (c *Conn) PeerCertificates() []*x509.Certificate {
+	tc, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	return tc.ConnectionState().PeerCertificates
+}
+
+// Close 关闭连接
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Do 执行一条命令。 返回值的类型取决于响应:
+// 简单字符串和 bulk 字符串为 string， 整数为 int64， 数组为 []interface{}， nil 响应为 nil。
+// redis 返回的错误响应为 Error。
+func (c *Conn) Do(args ...string) (interface{}, error) {
+	if c.timeout > 0 {
+		if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := c.conn.Write(encode(args)); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// String 执行命令并返回字符串响应
+func (c *Conn) String(args ...string) (string, error) {
+	reply, err := c.Do(args...)
+	if err != nil {
+		return "", err
+	}
+	s, ok := reply.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: unexpected reply %T", args[0], reply)
+	}
+	return s, nil
+}
+
+// Int 执行命令并返回整数响应
+func (c *Conn) Int(args ...string) (int64, error) {
+	reply, err := c.Do(args...)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("%s: unexpected reply %T", args[0], reply)
+	}
+	return n, nil
+}
+
+func encode(args []string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return []byte(b.String())
+}
+
+func (c *Conn) read() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			item, err := c.read()
+			if err != nil {
+				// 数组中的错误元素不中断读取
+				var redisErr Error
+				if !errors.As(err, &redisErr) {
+					return nil, err
+				}
+				item = redisErr
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply type %q", line[0])
+}
+
+func (c *Conn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
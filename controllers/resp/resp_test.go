@@ -0,0 +1,115 @@
+package resp_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tangx/k8s-operator-demo/controllers/certs"
+	"github.com/tangx/k8s-operator-demo/controllers/resp"
+	"github.com/tangx/k8s-operator-demo/controllers/resp/resptest"
+)
+
+func TestDo(t *testing.T) {
+	server, err := resptest.NewServer(func(args []string) string {
+		switch args[0] {
+		case "AUTH":
+			if args[1] != "secret" {
+				return resptest.Err("WRONGPASS invalid username-password pair")
+			}
+			return resptest.OK
+		case "GET":
+			return "$-1\r\n"
+		case "LASTSAVE":
+			return resptest.Int(1634000000)
+		case "CLIENT":
+			return resptest.Bulk("id=1 addr=10.0.0.1:5000 flags=N\nid=2 addr=10.0.0.2:5000 flags=N\n")
+		case "CONFIG":
+			return "*2\r\n" + resptest.Bulk("dir") + resptest.Bulk("/data")
+		}
+		return resptest.Err("ERR unknown command")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	ctx := context.Background()
+	if _, err := resp.Dial(ctx, server.Addr(), "wrong", time.Second); err == nil {
+		t.Fatal("expected AUTH to fail")
+	}
+
+	c, err := resp.Dial(ctx, server.Addr(), "secret", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if reply, err := c.Do("GET", "missing"); err != nil || reply != nil {
+		t.Fatalf("GET = %v, %v; want nil", reply, err)
+	}
+	if n, err := c.Int("LASTSAVE"); err != nil || n != 1634000000 {
+		t.Fatalf("LASTSAVE = %v, %v", n, err)
+	}
+	if s, err := c.String("CLIENT", "LIST"); err != nil || s == "" {
+		t.Fatalf("CLIENT LIST = %q, %v", s, err)
+	}
+	reply, err := c.Do("CONFIG", "GET", "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{"dir", "/data"}; !reflect.DeepEqual(reply, want) {
+		t.Fatalf("CONFIG GET = %v, want %v", reply, want)
+	}
+
+	_, err = c.Do("FLUSHALL")
+	var redisErr resp.Error
+	if !errors.As(err, &redisErr) || redisErr != "ERR unknown command" {
+		t.Fatalf("expected redis error, got %v", err)
+	}
+}
+
+func TestDialTLS(t *testing.T) {
+	now := time.Now()
+	ca, err := certs.NewCA("test", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := certs.Issue(ca, []string{"cache.default.svc", "127.0.0.1"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(pair.Cert, pair.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := resptest.NewTLSServer(func(args []string) string {
+		return resptest.Bulk("PONG")
+	}, &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.Cert)
+
+	ctx := context.Background()
+	if _, err := resp.DialTLS(ctx, server.Addr(), "", time.Second, &tls.Config{RootCAs: roots, ServerName: "other.default.svc"}); err == nil {
+		t.Fatal("expected the handshake to fail for a wrong server name")
+	}
+
+	c, err := resp.DialTLS(ctx, server.Addr(), "", time.Second, &tls.Config{RootCAs: roots, ServerName: "cache.default.svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if s, err := c.String("PING"); err != nil || s != "PONG" {
+		t.Fatalf("PING = %q, %v", s, err)
+	}
+}
@@ -0,0 +1,149 @@
+// Package resptest 提供测试用的 RESP 服务端， 按命令返回预设的响应
+package resptest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Handler 处理一条命令， 返回原始 RESP 响应， 例如 "+OK\r\n"
+type Handler func(args []string) string
+
+// Server 监听本地端口的 RESP 服务端
+type Server struct {
+	listener net.Listener
+	handler  Handler
+
+	mu       sync.Mutex
+	commands [][]string
+}
+
+// NewServer 启动服务端， 测试结束后需要调用 Close
+func NewServer(handler Handler) (*Server, error) {
+	return NewServerAt("127.0.0.1:0", handler)
+}
+
+// NewServerAt 在指定地址启动服务端， 用于在 127.0.0.2 等地址上模拟多个 pod
+func NewServerAt(addr string, handler Handler) (*Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{listener: l, handler: handler}
+	go s.serve()
+	return s, nil
+}
+
+// NewTLSServer 启动使用 TLS 的服务端
+func NewTLSServer(handler Handler, config *tls.Config) (*Server, error) {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{listener: l, handler: handler}
+	go s.serve()
+	return s, nil
+}
+
+// Addr 服务端地址
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Port 服务端端口
+func (s *Server) Port() int32 {
+	return int32(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Close 停止服务端
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// Commands 返回收到的全部命令
+func (s *Server) Commands() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([][]string(nil), s.commands...)
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.commands = append(s.commands, args)
+		s.mu.Unlock()
+
+		if _, err := io.WriteString(conn, s.handler(args)); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, strings.TrimSuffix(arg, "\r\n"))
+	}
+	return args, nil
+}
+
+// OK 简单字符串 OK
+const OK = "+OK\r\n"
+
+// Bulk 编码 bulk 字符串
+func Bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+// Int 编码整数
+func Int(n int64) string {
+	return fmt.Sprintf(":%d\r\n", n)
+}
+
+// Err 编码错误
+func Err(msg string) string {
+	return "-" + msg + "\r\n"
+}
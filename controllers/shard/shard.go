@@ -0,0 +1,101 @@
+// Package shard 按标签把 redis 分配给多个 operator 实例。
+// 每个实例只缓存并调谐标签匹配自己分片选择器的 redis 及其 pod、 service。
+package shard
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+)
+
+// Parse 解析分片选择器， 空字符串表示不分片
+func Parse(selector string) (labels.Selector, error) {
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("解析分片选择器 %q 失败: %w", selector, err)
+	}
+	return sel, nil
+}
+
+// LeaderElectionID 返回分片使用的选主 ID， 不同分片的 operator 各自选主
+func LeaderElectionID(base string, selector labels.Selector) string {
+	if selector.Empty() {
+		return base
+	}
+
+	// selector.String() 对条件排序， 等价的选择器得到相同的 ID
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(selector.String()))
+	return fmt.Sprintf("shard-%08x.%s", h.Sum32(), base)
+}
+
+// CacheSelectors 返回 cache 的过滤条件。
+// pod 和 service 复制了 redis 的标签， 因此使用同一个选择器过滤。
+func CacheSelectors(selector labels.Selector) cache.SelectorsByObject {
+	if selector.Empty() {
+		return nil
+	}
+	return cache.SelectorsByObject{
+		&appv1.Redis{}:    {Label: selector},
+		&corev1.Pod{}:     {Label: selector},
+		&corev1.Service{}: {Label: selector},
+	}
+}
+
+// KeySelector 返回要求存在 selector 中全部标签 key 的选择器。
+// 没有配置全部分片时， 缺少分片标签的 redis 不会被任何分片管理。
+func KeySelector(selector labels.Selector) (labels.Selector, error) {
+	result := labels.NewSelector()
+	reqs, _ := selector.Requirements()
+	for _, req := range reqs {
+		exists, err := labels.NewRequirement(req.Key(), selection.Exists, nil)
+		if err != nil {
+			return nil, err
+		}
+		result = result.Add(*exists)
+	}
+	return result, nil
+}
+
+// Unmatched 返回不匹配任何分片选择器的 redis。
+// reader 需要直接读取 apiserver， 分片 operator 的 cache 中只有本分片的 redis。
+func Unmatched(ctx context.Context, reader client.Reader, namespaces []string, shards []labels.Selector) ([]types.NamespacedName, error) {
+	if len(namespaces) == 0 {
+		// 空字符串表示所有命名空间
+		namespaces = []string{""}
+	}
+
+	var unmatched []types.NamespacedName
+	for _, ns := range namespaces {
+		list := &appv1.RedisList{}
+		if err := reader.List(ctx, list, client.InNamespace(ns)); err != nil {
+			return nil, err
+		}
+
+		for i := range list.Items {
+			redis := &list.Items[i]
+			if !matchesAny(labels.Set(redis.Labels), shards) {
+				unmatched = append(unmatched, client.ObjectKeyFromObject(redis))
+			}
+		}
+	}
+	return unmatched, nil
+}
+
+func matchesAny(set labels.Set, shards []labels.Selector) bool {
+	for _, sel := range shards {
+		if sel.Matches(set) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package shard
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+
+	appv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/fakeclient"
+)
+
+func mustParse(t *testing.T, selector string) labels.Selector {
+	t.Helper()
+
+	sel, err := Parse(selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sel
+}
+
+func TestLeaderElectionID(t *testing.T) {
+	base := "1cc0fdbc.tangx.in"
+
+	if id := LeaderElectionID(base, mustParse(t, "")); id != base {
+		t.Fatalf("unsharded id = %q, want %q", id, base)
+	}
+
+	a := LeaderElectionID(base, mustParse(t, "shard=a,tier in (cache)"))
+	same := LeaderElectionID(base, mustParse(t, "tier in (cache), shard=a"))
+	b := LeaderElectionID(base, mustParse(t, "shard=b"))
+	if a != same {
+		t.Fatalf("equivalent selectors got different ids %q and %q", a, same)
+	}
+	if a == b || a == base {
+		t.Fatalf("shards must use distinct ids, got %q and %q", a, b)
+	}
+}
+
+func TestParseRejectsInvalidSelector(t *testing.T) {
+	if _, err := Parse("shard in a"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func newRedis(name string, lbls map[string]string) *appv1.Redis {
+	return &appv1.Redis{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    lbls,
+		},
+	}
+}
+
+func TestUnmatched(t *testing.T) {
+	c := fakeclient.New(
+		newRedis("a", map[string]string{"shard": "a"}),
+		newRedis("b", map[string]string{"shard": "b"}),
+		newRedis("c", map[string]string{"shard": "c"}),
+		newRedis("none", nil),
+	)
+
+	// 配置了全部分片
+	shards := []labels.Selector{mustParse(t, "shard=a"), mustParse(t, "shard=b")}
+	unmatched, err := Unmatched(context.Background(), c, nil, shards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []types.NamespacedName{{Namespace: "default", Name: "c"}, {Namespace: "default", Name: "none"}}
+	if !reflect.DeepEqual(unmatched, want) {
+		t.Fatalf("unmatched = %v, want %v", unmatched, want)
+	}
+
+	// 只知道自己的分片时， 只报告缺少分片标签的 redis
+	keys, err := KeySelector(mustParse(t, "shard=a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmatched, err = Unmatched(context.Background(), c, []string{"default"}, []labels.Selector{keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []types.NamespacedName{{Namespace: "default", Name: "none"}}
+	if !reflect.DeepEqual(unmatched, want) {
+		t.Fatalf("unmatched = %v, want %v", unmatched, want)
+	}
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/events"
+	"github.com/tangx/k8s-operator-demo/controllers/helper2"
+)
+
+const (
+	// tlsPendingInterval 等待 cert-manager 签发证书时的检查间隔
+	tlsPendingInterval = 10 * time.Second
+	// tlsReloadInterval 等待 kubelet 更新证书 volume 后重新加载的检查间隔， volume 更新不会触发调谐。
+	tlsReloadInterval = 15 * time.Second
+	// tlsRecheckInterval 检查 operator 签发的证书是否需要更新的间隔。
+	// cert-manager 更新证书时 secret 的 watch 会触发调谐， 不需要定时检查。
+	tlsRecheckInterval = 10 * time.Minute
+)
+
+// TLSReady condition 的 reason
+const (
+	reasonCertPending = "Pending"
+	reasonCertFailed  = "IssueFailed"
+	reasonCertIssued  = "Issued"
+)
+
+// TLSRotating condition 的 reason
+const (
+	reasonRotationInProgress = "InProgress"
+	reasonRotationCompleted  = "Completed"
+	reasonRotationBlocked    = "RecreateRequired"
+)
+
+// reconcileTLS 签发或更新证书并记录到 status， 返回证书是否可以使用
+func (r *RedisReconciler) reconcileTLS(ctx context.Context, redis *myappv1.Redis) (bool, error) {
+	if !redis.TLSEnabled() {
+		redis.Status.TLS = nil
+		meta.RemoveStatusCondition(&redis.Status.Conditions, myappv1.ConditionTLSReady)
+		return true, nil
+	}
+
+	status, err := helper2.EnsureTLS2(ctx, r.Client, r.apiReader(), redis, r.Scheme)
+	if err != nil {
+		setRedisCondition(redis, myappv1.ConditionTLSReady, false, reasonCertFailed, err.Error())
+		return false, fmt.Errorf("签发证书失败: %w", err)
+	}
+	if status == nil {
+		setRedisCondition(redis, myappv1.ConditionTLSReady, false, reasonCertPending, "Waiting for cert-manager to issue the certificate")
+		return false, nil
+	}
+
+	expires := "unknown"
+	if status.NotAfter != nil {
+		expires = status.NotAfter.UTC().Format(time.RFC3339)
+	}
+	if old := redis.Status.TLS; old != nil && old.CertificateHash != status.CertificateHash {
+		log.FromContext(ctx).Info("certificate renewed", "secret", status.SecretName, "notAfter", expires)
+		r.EventRecord.Event(redis, events.ReasonCertRenewed, status.SecretName, expires)
+	}
+	redis.Status.TLS = status
+	setRedisCondition(redis, myappv1.ConditionTLSReady, true, reasonCertIssued, fmt.Sprintf("Certificate in secret %s expires at %s", status.SecretName, expires))
+	return true, nil
+}
+
+// rotatePods 让 pod 原地重新加载更新后的证书， 开启或关闭 TLS 时在允许的情况下逐个重建 pod。
+// 返回是否还有 pod 在等待重新加载证书。
+func (r *RedisReconciler) rotatePods(ctx context.Context, redis *myappv1.Redis) (bool, error) {
+	step, err := helper2.RotatePod2(ctx, r.Client, r.apiReader(), redis)
+	for _, name := range step.Reloaded {
+		r.EventRecord.Event(redis, events.ReasonCertReloaded, name)
+	}
+	if step.Deleted != "" {
+		r.EventRecord.Event(redis, events.ReasonPodRotated, step.Deleted)
+	}
+	if err != nil {
+		return false, fmt.Errorf("轮换 pod 失败: %w", err)
+	}
+
+	switch {
+	case len(step.Blocked) > 0:
+		setRedisCondition(redis, myappv1.ConditionTLSRotating, false, reasonRotationBlocked,
+			fmt.Sprintf("Pods %v must be recreated to apply the TLS change, set spec.tls.recreatePods to allow it", step.Blocked))
+	case step.Outdated > 0:
+		setRedisCondition(redis, myappv1.ConditionTLSRotating, true, reasonRotationInProgress,
+			fmt.Sprintf("%d pods are not using the current certificate yet", step.Outdated))
+	default:
+		setRedisCondition(redis, myappv1.ConditionTLSRotating, false, reasonRotationCompleted, "All pods are using the current certificate")
+	}
+	return step.Outdated > 0 && len(step.Blocked) == 0, nil
+}
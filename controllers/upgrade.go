@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
+	"github.com/tangx/k8s-operator-demo/controllers/events"
+	"github.com/tangx/k8s-operator-demo/controllers/helper2"
+)
+
+// upgradeInterval 升级过程中的检查间隔
+const upgradeInterval = 5 * time.Second
+
+// Upgrading condition 的 reason
+const (
+	reasonUpgradeInProgress = "InProgress"
+	reasonUpgradeCompleted  = "Completed"
+	reasonUpgradeBlocked    = "Blocked"
+)
+
+// upgradePods 逐个升级镜像与期望不一致的 pod 并更新 status 中的版本， 返回是否还在升级
+func (r *RedisReconciler) upgradePods(ctx context.Context, redis *myappv1.Redis) (bool, error) {
+	// 关闭 webhook 时同样不能降级， 已经升级的 pod 可能写出了旧版本无法加载的 RDB
+	image, err := redis.RedisImage(r.Versions)
+	if err == nil && redis.Status.Version != nil {
+		err = r.Versions.CheckDowngrade(redis.Status.Version.Current, redis.Spec.Version)
+	}
+	if err != nil {
+		setRedisCondition(redis, myappv1.ConditionUpgrading, false, reasonUpgradeBlocked, err.Error())
+		return false, err
+	}
+
+	step, err := helper2.UpgradePod2(ctx, r.Client, redis, r.Versions)
+	if err != nil {
+		return false, fmt.Errorf("升级 pod 失败: %w", err)
+	}
+	if step.Deleted != "" {
+		r.EventRecord.Event(redis, events.ReasonPodUpgraded, step.Deleted, image)
+	}
+
+	r.setVersionStatus(redis, step.Completed)
+	if step.Blocked != "" {
+		// 用户手动删除 pod 后会触发调谐， 不需要定时检查
+		setRedisCondition(redis, myappv1.ConditionUpgrading, false, reasonUpgradeBlocked, step.Blocked)
+		return false, nil
+	}
+	if step.Completed {
+		setRedisCondition(redis, myappv1.ConditionUpgrading, false, reasonUpgradeCompleted, fmt.Sprintf("All pods run image %s", image))
+		return false, nil
+	}
+	setRedisCondition(redis, myappv1.ConditionUpgrading, true, reasonUpgradeInProgress, fmt.Sprintf("%d pods waiting for upgrade to image %s", step.Outdated, image))
+	return true, nil
+}
+
+// setVersionStatus 记录期望的版本， 全部 pod 升级完成后更新正在运行的版本
+func (r *RedisReconciler) setVersionStatus(redis *myappv1.Redis, completed bool) {
+	if redis.Spec.Version == "" {
+		redis.Status.Version = nil
+		return
+	}
+
+	status := redis.Status.Version
+	if status == nil {
+		status = &myappv1.VersionStatus{}
+		redis.Status.Version = status
+	}
+	status.Target = redis.Spec.Version
+	if completed && status.Current != status.Target {
+		// 第一次设置 spec.version 不算升级
+		if status.Current != "" {
+			r.EventRecord.Event(redis, events.ReasonUpgraded, status.Target)
+		}
+		status.Current = status.Target
+	}
+}
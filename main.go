@@ -17,8 +17,12 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -26,13 +30,27 @@ import (
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/discovery"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	componentconfig "k8s.io/component-base/config/v1alpha1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
+	configv1alpha1 "github.com/tangx/k8s-operator-demo/api/config/v1alpha1"
 	myappv1 "github.com/tangx/k8s-operator-demo/api/v1"
 	"github.com/tangx/k8s-operator-demo/controllers"
+	"github.com/tangx/k8s-operator-demo/controllers/config"
+	"github.com/tangx/k8s-operator-demo/controllers/events"
+	"github.com/tangx/k8s-operator-demo/controllers/helper2"
+	"github.com/tangx/k8s-operator-demo/controllers/metrics"
+	"github.com/tangx/k8s-operator-demo/controllers/orphan"
+	"github.com/tangx/k8s-operator-demo/controllers/ratelimit"
+	"github.com/tangx/k8s-operator-demo/controllers/render"
+	"github.com/tangx/k8s-operator-demo/controllers/shard"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -45,18 +63,51 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(myappv1.AddToScheme(scheme))
+	utilruntime.Must(configv1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
+	// 子命令: manager render -f redis.yaml， 离线渲染 redis 生成的对象
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		os.Exit(runRender(os.Args[2:]))
+	}
+
+	var configFile string
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
-	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
-	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	var redactSecrets bool
+	var eventLanguage string
+	var defaultImage string
+	var maxConcurrentReconciles int
+	var watchNamespaces string
+	var enableWebhooks bool
+	var shardSelector string
+	var orphanPods string
+	flag.StringVar(&configFile, "config", "",
+		"The operator will load its initial configuration from this file. "+
+			"Flags set on the command line override values in this file.")
+	flag.StringVar(&metricsAddr, "metrics-bind-address", configv1alpha1.DefaultMetricsBindAddress, "The address the metric endpoint binds to.")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", configv1alpha1.DefaultHealthProbeBindAddress, "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&redactSecrets, "log-redact-secrets", true,
+		"Redact secret references and sensitive annotations from objects written to the log.")
+	flag.StringVar(&eventLanguage, "event-language", configv1alpha1.DefaultEventLanguage,
+		fmt.Sprintf("Language of event messages recorded on Redis objects, one of %v.", events.Languages()))
+	flag.StringVar(&defaultImage, "default-image", myappv1.DefaultRedisImage, "Image used for Redis objects that do not set spec.image.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", configv1alpha1.DefaultMaxConcurrentReconciles,
+		"Maximum number of Redis objects reconciled at the same time.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma separated list of namespaces to watch. Watches all namespaces when empty.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", true,
+		"Serve the Redis admission webhooks. Disable when the webhook configuration is not installed.")
+	flag.StringVar(&shardSelector, "shard-selector", "",
+		"Label selector restricting the Redis objects, and their pods and services, managed by this operator instance.")
+	flag.StringVar(&orphanPods, "orphan-pod-policy", string(configv1alpha1.DefaultOrphanPods),
+		fmt.Sprintf("How to handle operator labelled pods whose Redis was deleted or replaced, one of %v.", configv1alpha1.OrphanPolicies))
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,40 +116,165 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "1cc0fdbc.tangx.in",
+	operatorConfig, err := config.Load(configFile)
+	if err != nil {
+		setupLog.Error(err, "unable to load the config file")
+		os.Exit(1)
+	}
+
+	// 命令行中显式设置的参数覆盖配置文件
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "metrics-bind-address":
+			operatorConfig.Metrics.BindAddress = metricsAddr
+		case "health-probe-bind-address":
+			operatorConfig.Health.HealthProbeBindAddress = probeAddr
+		case "leader-elect":
+			if operatorConfig.LeaderElection == nil {
+				operatorConfig.LeaderElection = &componentconfig.LeaderElectionConfiguration{}
+			}
+			operatorConfig.LeaderElection.LeaderElect = &enableLeaderElection
+		case "log-redact-secrets":
+			operatorConfig.Operator.Policy.RedactSecrets = &redactSecrets
+		case "event-language":
+			operatorConfig.Operator.EventLanguage = eventLanguage
+		case "default-image":
+			operatorConfig.Operator.DefaultImage = defaultImage
+		case "max-concurrent-reconciles":
+			operatorConfig.Operator.MaxConcurrentReconciles = maxConcurrentReconciles
+		case "watch-namespaces":
+			operatorConfig.Operator.WatchNamespaces = splitNamespaces(watchNamespaces)
+		case "enable-webhooks":
+			operatorConfig.Operator.EnableWebhooks = &enableWebhooks
+		case "shard-selector":
+			operatorConfig.Operator.ShardSelector = shardSelector
+		case "orphan-pod-policy":
+			operatorConfig.Operator.Policy.OrphanPods = configv1alpha1.OrphanPolicy(orphanPods)
+		}
 	})
+
+	operatorConfig.Default()
+	if err := config.Validate(operatorConfig); err != nil {
+		setupLog.Error(err, "invalid operator configuration")
+		os.Exit(1)
+	}
+	setupLog.Info("loaded operator configuration", "file", configFile, "operator", operatorConfig.Operator)
+	if namespaces := operatorConfig.Operator.WatchNamespaces; len(namespaces) > 0 {
+		setupLog.Info("watching namespaces", "namespaces", namespaces)
+	} else {
+		setupLog.Info("watching all namespaces")
+	}
+
+	defaults := config.Defaults(operatorConfig)
+
+	// NetworkPolicy 需要允许 operator 访问 redis
+	namespace := operatorNamespace()
+	if namespace == "" {
+		setupLog.Info("unable to detect the operator namespace, network policies will not admit the operator")
+	}
+
+	options, err := config.ManagerOptions(operatorConfig, ctrl.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to apply the operator configuration")
+		os.Exit(1)
+	}
+
+	restConfig := ctrl.GetConfigOrDie()
+	mgr, err := ctrl.NewManager(restConfig, options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	// 检测集群中是否安装了 prometheus-operator 的 ServiceMonitor CRD
+	dc, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to create discovery client")
+		os.Exit(1)
+	}
+	serviceMonitorAvailable, err := helper2.IsServiceMonitorAvailable(dc)
+	if err != nil {
+		setupLog.Error(err, "unable to detect ServiceMonitor CRD")
+		os.Exit(1)
+	}
+	setupLog.Info("detected ServiceMonitor CRD", "available", serviceMonitorAvailable)
+
+	// 添加事件记录名称
+	eventRecorder, err := events.NewRecorder(mgr.GetEventRecorderFor("RedisOperator"), operatorConfig.Operator.EventLanguage)
+	if err != nil {
+		setupLog.Error(err, "invalid event language")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.RedisReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 
-		// 添加事件记录名称
-		EventRecord: mgr.GetEventRecorderFor("RedisOperator"),
+		EventRecord: eventRecorder,
+
+		ServiceMonitorAvailable: serviceMonitorAvailable,
+		OperatorNamespace:       namespace,
+		Versions:                defaults.Versions,
+		RedactSecrets:           *operatorConfig.Operator.Policy.RedactSecrets,
+		MaxConcurrentReconciles: operatorConfig.Operator.MaxConcurrentReconciles,
+		RateLimiter:             ratelimit.New(operatorConfig.Operator.RateLimiter, metrics.Default),
+		APIReader:               mgr.GetAPIReader(),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Redis")
 		os.Exit(1)
 	}
+	if err = (&controllers.RedisUserReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		APIReader: mgr.GetAPIReader(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "RedisUser")
+		os.Exit(1)
+	}
 
 	// 本地测试可以注释
-	if env := os.Getenv("ENV"); env != "local" {
-		if err = (&myappv1.Redis{}).SetupWebhookWithManager(mgr); err != nil {
+	if env := os.Getenv("ENV"); env != "local" && *operatorConfig.Operator.EnableWebhooks {
+		// 先注册统计拒绝规则的校验 webhook， SetupWebhookWithManager 会跳过已经注册的路径
+		hookServer := mgr.GetWebhookServer()
+		redisWebhook := &myappv1.RedisWebhook{Defaults: defaults}
+		hookServer.Register(myappv1.RedisValidatePath, metrics.RecordRejections(redisWebhook.ValidatingWebhook()))
+		hookServer.Register("/validate-myapp-tangx-in-v1-redisuser",
+			metrics.RecordRejections(admission.ValidatingWebhookFor(&myappv1.RedisUser{})))
+
+		if err = redisWebhook.SetupWebhookWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create webhook", "webhook", "Redis")
 			os.Exit(1)
 		}
+		if err = (&myappv1.RedisUser{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "RedisUser")
+			os.Exit(1)
+		}
 	}
 
 	//+kubebuilder:scaffold:builder
 
+	if policy := operatorConfig.Operator.Policy; policy.OrphanPods != configv1alpha1.OrphanPolicyIgnore {
+		if err := mgr.Add(&orphan.Sweeper{
+			Client:   mgr.GetClient(),
+			Reader:   mgr.GetAPIReader(),
+			Scheme:   mgr.GetScheme(),
+			Recorder: eventRecorder,
+			Policy:   policy.OrphanPods,
+			Interval: policy.OrphanSweepInterval.Duration,
+		}); err != nil {
+			setupLog.Error(err, "unable to set up orphan pod sweeper")
+			os.Exit(1)
+		}
+	}
+
+	if operatorConfig.Operator.ShardSelector != "" {
+		setupLog.Info("managing shard", "selector", operatorConfig.Operator.ShardSelector, "leaderElectionID", options.LeaderElectionID)
+		if err := mgr.Add(unmatchedShardCheck(mgr.GetAPIReader(), operatorConfig)); err != nil {
+			setupLog.Error(err, "unable to set up shard check")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -114,3 +290,89 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// unmatchedShardCheck 启动后检查不属于任何分片的 redis， 这些 redis 不会被任何 operator 调谐
+func unmatchedShardCheck(reader client.Reader, operatorConfig *configv1alpha1.OperatorConfig) manager.Runnable {
+	return manager.RunnableFunc(func(ctx context.Context) error {
+		shards, err := config.ShardSelectors(operatorConfig)
+		if err != nil {
+			return err
+		}
+
+		unmatched, err := shard.Unmatched(ctx, reader, operatorConfig.Operator.WatchNamespaces, shards)
+		if err != nil {
+			// 检查失败不影响调谐
+			setupLog.Error(err, "unable to check Redis objects against shard selectors")
+			return nil
+		}
+		for _, key := range unmatched {
+			setupLog.Info("WARNING: Redis matches no shard selector and will not be reconciled", "redis", key)
+		}
+		return nil
+	})
+}
+
+// operatorNamespace 返回 operator 所在的 namespace， 优先使用 downward API 注入的 POD_NAMESPACE
+func operatorNamespace() string {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	data, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// splitNamespaces 解析逗号分隔的命名空间列表， 忽略空白项
+func splitNamespaces(value string) []string {
+	var namespaces []string
+	for _, ns := range strings.Split(value, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// runRender 读取 redis 清单， 执行默认值和校验后输出生成的对象， 不连接集群
+func runRender(args []string) int {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	var file string
+	fs.StringVar(&file, "f", "-", "Path to a file containing Redis objects, or - to read from stdin.")
+	var configFile string
+	fs.StringVar(&configFile, "config", "",
+		"The operator configuration file, so that rendered objects use the same default image and version catalog as the operator.")
+	var opts render.Options
+	fs.StringVar(&opts.OperatorNamespace, "operator-namespace", "",
+		"The namespace the operator runs in, admitted by rendered network policies.")
+	_ = fs.Parse(args)
+
+	operatorConfig, err := config.Load(configFile)
+	if err == nil {
+		operatorConfig.Default()
+		err = config.Validate(operatorConfig)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	opts.Defaults = config.Defaults(operatorConfig)
+
+	in := os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	if err := render.Render(in, os.Stdout, opts, scheme); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}